@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"strings"
 	"text/template"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 )
 
 // Builder is a central object in skbtrace: it accumulates all knowledge
@@ -18,6 +21,9 @@ type Builder struct {
 	fieldObjectMap map[string][]*fieldAliasRef
 	fieldAliasMap  map[string]*fieldAliasRef
 
+	// Objectless groups for weak aliases resolved at runtime
+	autoFieldGroups map[string]*FieldGroup
+
 	probeList []*Probe
 	probeMap  map[string]*Probe
 
@@ -26,19 +32,25 @@ type Builder struct {
 	globalVars map[string]Expression
 
 	structDefs map[string]*StructDef
+
+	lookupTables     map[string]LookupTable
+	lookupTableFuncs map[string]func() LookupTable
 }
 
 // Constructs a new trace script builder
 func NewBuilder() *Builder {
 	return &Builder{
-		objectMap:       make(map[string]*Object),
-		fieldGroupMap:   make(map[string][]*FieldGroup),
-		fieldObjectMap:  make(map[string][]*fieldAliasRef),
-		fieldAliasMap:   make(map[string]*fieldAliasRef),
-		probeMap:        make(map[string]*Probe),
-		castFunctionMap: make(template.FuncMap),
-		globalVars:      make(map[string]Expression),
-		structDefs:      make(map[string]*StructDef),
+		objectMap:        make(map[string]*Object),
+		fieldGroupMap:    make(map[string][]*FieldGroup),
+		fieldObjectMap:   make(map[string][]*fieldAliasRef),
+		fieldAliasMap:    make(map[string]*fieldAliasRef),
+		autoFieldGroups:  make(map[string]*FieldGroup),
+		probeMap:         make(map[string]*Probe),
+		castFunctionMap:  make(template.FuncMap),
+		globalVars:       make(map[string]Expression),
+		structDefs:       make(map[string]*StructDef),
+		lookupTables:     make(map[string]LookupTable),
+		lookupTableFuncs: make(map[string]func() LookupTable),
 	}
 }
 
@@ -54,6 +66,14 @@ func (b *Builder) FieldGroups() []*FieldGroup {
 	return b.fieldGroupList
 }
 
+// FieldAliases returns sorted list of registered field aliases
+// including weak aliases and aliases with prefixes
+func (b *Builder) FieldAliases() []string {
+	aliases := maps.Keys(b.fieldAliasMap)
+	slices.Sort(aliases)
+	return aliases
+}
+
 // AddProbes registers probes and its aliases (including k: and kr: shortcuts)
 // within a builder.
 // Should be called on program start: might panic.
@@ -166,6 +186,29 @@ func (b *Builder) AddObjectCasts(objCasts []*Object) {
 	}
 }
 
+// SetObjectSanityFilter replaces sanity filter of already registered object.
+// Object is copied, so objects registered in other builders are not affected.
+// Should be called on program start: might panic.
+func (b *Builder) SetObjectSanityFilter(variable string, filter Filter) {
+	obj, ok := b.objectMap[variable]
+	if !ok {
+		panic(fmt.Sprintf("Object '%s' is not registered", variable))
+	}
+	if variable == filter.Object {
+		panic(fmt.Sprintf("Object '%s' uses sanity filter referring itself."+
+			" Should be replaced with field-based sanity filter", variable))
+	}
+
+	objCopy := *obj
+	objCopy.SanityFilter = filter
+	b.objectMap[variable] = &objCopy
+	for i, listObj := range b.objectList {
+		if listObj == obj {
+			b.objectList[i] = &objCopy
+		}
+	}
+}
+
 // AddCastFunction registers function accessible from casts.
 func (b *Builder) AddCastFunction(name string, f interface{}) {
 	b.castFunctionMap[name] = f
@@ -199,6 +242,31 @@ func (b *Builder) AddGlobalVars(vars map[string]Expression) {
 	}
 }
 
+// Registers lookup table which maps integer values to strings. Table is rendered
+// into BEGIN block of the program only if fields referring it are used.
+// Might be called multiple times to update table contents.
+func (b *Builder) AddLookupTable(name string, table LookupTable) {
+	b.lookupTables[name] = table
+	delete(b.lookupTableFuncs, name)
+}
+
+// Registers lookup table which contents are produced by fn when fields referring
+// it are used for the first time. Useful for tables collected from the host,
+// so commands which don't use them won't collect them.
+func (b *Builder) AddLookupTableFunc(name string, fn func() LookupTable) {
+	b.lookupTableFuncs[name] = fn
+	delete(b.lookupTables, name)
+}
+
+func (b *Builder) getLookupTable(name string) (LookupTable, bool) {
+	if fn, ok := b.lookupTableFuncs[name]; ok {
+		b.AddLookupTable(name, fn())
+	}
+
+	table, ok := b.lookupTables[name]
+	return table, ok
+}
+
 // Registers struct type definition and parses its string.
 // See StructDef for more info.
 func (b *Builder) AddStructDef(typeName string, rawText string) {
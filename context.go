@@ -0,0 +1,255 @@
+package skbtrace
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errContextFieldsNotSupported = errors.New("context fields are only supported when dumping")
+
+type traceContext struct {
+	opt *TraceCommonOptions
+
+	// Field references of keys shared by context and main probes and
+	// fields captured by context probe
+	keys   []*fieldAliasRef
+	fields []*fieldAliasRef
+
+	// Filters on captured fields applied in main probe along with the flag
+	fieldFilters [][]*ProcessedFilter
+}
+
+func (b *Builder) newTraceContext(opt *TraceCommonOptions, rows []string) (*traceContext, error) {
+	tctx := &traceContext{opt: opt}
+	if !tctx.enabled() {
+		return tctx, nil
+	}
+	if len(opt.ContextKeys) == 0 {
+		return nil, newCommonError(ErrLevelField, "context keys", ErrMsgNotSpecified)
+	}
+
+	keys, err := b.prepareKeys(opt.ContextKeys)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := b.prepareKeys(opt.ContextFields)
+	if err != nil {
+		return nil, err
+	}
+
+	fieldFilters, err := b.prepareFilters(opt.ContextFieldFilterOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	filters, err := b.prepareFilters(opt.FilterOptions)
+	if err != nil {
+		return nil, err
+	}
+	boSet := b.newBuildObjectSet(filters, rows, opt.Hints)
+	err = b.resolveWeakAliasRefs(b.getFieldWeakRefs(append(keys, fields...)), boSet)
+	if err != nil {
+		return nil, err
+	}
+	err = b.resolveWeakAliasRefs(b.getFilterWeakRefs(fieldFilters), boSet)
+	if err != nil {
+		return nil, err
+	}
+
+	tctx.keys = keys
+	tctx.fields = fields
+	tctx.fieldFilters = fieldFilters
+	for _, filterChunk := range fieldFilters {
+		for _, filter := range filterChunk {
+			if err := tctx.checkFieldFilter(filter); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return tctx, nil
+}
+
+// checkFieldFilter checks that filter only uses captured fields which
+// are saved in the same form as they would be compared in filter
+func (tctx *traceContext) checkFieldFilter(filter *ProcessedFilter) error {
+	for _, fref := range filter.frefs {
+		if tctx.fieldIndex(fref) < 0 {
+			return newErrorf(ErrLevelFilter, filter.fieldIdent(), nil,
+				"field is not captured by context probe")
+		}
+
+		convMask := fref.field.ConverterMask
+		if convMask == 0 {
+			convMask = ConverterDump
+		}
+		if fref.field.Converter != nil &&
+			(convMask&ConverterHiddenKey != 0) != (convMask&ConverterFilter != 0) {
+			return newErrorf(ErrLevelFilter, filter.fieldIdent(), nil,
+				"captured field is converted and cannot be filtered")
+		}
+	}
+	return nil
+}
+
+// fieldIndex returns index of the captured field map or -1 if field
+// is not captured by context probe
+func (tctx *traceContext) fieldIndex(fref *fieldAliasRef) int {
+	for i, field := range tctx.fields {
+		if field.fg == fref.fg && field.field == fref.field {
+			return i
+		}
+	}
+	return -1
+}
+
+func (tctx *traceContext) enabled() bool {
+	return len(tctx.opt.ContextProbeNames) > 0
+}
+
+// isThreadLocal returns true if all keys are global variables such as tid,
+// so they are accessible in the return probe of the context probe
+func (b *Builder) isThreadLocal(tctx *traceContext) bool {
+	for _, key := range tctx.keys {
+		if key.fg.Object != "" {
+			return false
+		}
+		if _, ok := b.globalVars[key.field.Name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// getBlockWithContextKeys generates expressions for context keys. Hidden key
+// converters are used so keys in context and main probes are of the same type
+func (b *Builder) getBlockWithContextKeys(
+	block *Block, tctx *traceContext,
+) (*Block, Expression, error) {
+	block, exprs, err := b.getBlockWithKeys(block, tctx.keys, ConverterHiddenKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return block, ExprJoin(exprs), nil
+}
+
+// buildContextProbes adds context probes which set flag for their keys and
+// capture context fields. If keys are thread-local, flag is reset when
+// probe function returns. Otherwise, flag and captured fields are kept until
+// the next context probe firing overwrites them or periodic cleanup
+func (b *Builder) buildContextProbes(prog *Program, tctx *traceContext) error {
+	contextFilters, err := b.prepareFilters(tctx.opt.ContextFilterOptions)
+	if err != nil {
+		return err
+	}
+
+	threadLocal := b.isThreadLocal(tctx)
+	for _, probeName := range tctx.opt.ContextProbeNames {
+		if err = b.buildTracerProbe(prog, probeName, false, contextFilters, true, func(block *Block) error {
+			keyBlock, keysExpr, err := b.getBlockWithContextKeys(block, tctx)
+			if err != nil {
+				return err
+			}
+
+			// Fields are captured in a nested block so their sanity
+			// filters won't affect the flag
+			keyBlock.Addf("@trace_flag[%s] = 1", keysExpr)
+			fieldBlock, exprs, err := b.getBlockWithKeys(keyBlock, tctx.fields, ConverterHiddenKey)
+			if err != nil {
+				return err
+			}
+			for i, expr := range exprs {
+				fieldBlock.Addf("@trace_ctx_f%d[%s] = %s", i, keysExpr, expr)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		if !threadLocal {
+			continue
+		}
+		if err = b.buildTracerProbe(prog, probeName, true, nil, false, func(block *Block) error {
+			_, keysExpr, err := b.getBlockWithContextKeys(block, tctx)
+			if err != nil {
+				return err
+			}
+
+			block.Addf("delete(@trace_flag[%s])", keysExpr)
+			for i := range tctx.fields {
+				block.Addf("delete(@trace_ctx_f%d[%s])", i, keysExpr)
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	if !threadLocal {
+		prog.addAggrCleanupBlock(tctx.maps()...)
+		return nil
+	}
+	for _, aggr := range tctx.maps() {
+		prog.EndBlock().Addf("clear(%s)", aggr)
+	}
+	return nil
+}
+
+// wrapContextBuilder wraps builder of the main probe, so it is executed
+// only if context probe has been fired for the same keys and its captured
+// fields pass field filters
+func (b *Builder) wrapContextBuilder(
+	tctx *traceContext, builder func(block *Block) error,
+) func(block *Block) error {
+	if !tctx.enabled() {
+		return builder
+	}
+
+	return func(block *Block) error {
+		keyBlock, keysExpr, err := b.getBlockWithContextKeys(block, tctx)
+		if err != nil {
+			return err
+		}
+
+		conditions := []Expression{Exprf("@trace_flag[%s]", keysExpr)}
+		for _, filterChunk := range tctx.fieldFilters {
+			for _, filter := range filterChunk {
+				exprs := make([]Expression, len(filter.frefs))
+				for i, fref := range filter.frefs {
+					exprs[i] = Exprf("@trace_ctx_f%d[%s]", tctx.fieldIndex(fref), keysExpr)
+				}
+
+				condExpr, err := b.generateFilterCondition(filter, exprs)
+				if err != nil {
+					return err
+				}
+				conditions = append(conditions, condExpr)
+			}
+		}
+		return builder(keyBlock.AddIfBlock(conditions...))
+	}
+}
+
+// addContextDumpStatements prints fields captured by context probe. It should
+// be called for the block where time is printed, so fields are printed only
+// along with the event
+func (b *Builder) addContextDumpStatements(block *Block, tctx *traceContext) error {
+	if len(tctx.fields) == 0 {
+		return nil
+	}
+
+	block, keysExpr, err := b.getBlockWithContextKeys(block, tctx)
+	if err != nil {
+		return err
+	}
+	block.Add(b.generateMapPrintStatement(block, "CONTEXT", tctx.fields, "trace_ctx_f%d", keysExpr))
+	return nil
+}
+
+// maps returns maps populated by context probes
+func (tctx *traceContext) maps() []string {
+	aggrs := []string{"@trace_flag"}
+	for i := range tctx.fields {
+		aggrs = append(aggrs, fmt.Sprintf("@trace_ctx_f%d", i))
+	}
+	return aggrs
+}
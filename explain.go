@@ -0,0 +1,207 @@
+package skbtrace
+
+import (
+	"errors"
+	"fmt"
+)
+
+var errExplainNotSupported = errors.New("explain probe is only supported when dumping outliers")
+
+type explainContext struct {
+	opt   *TimeExplainOptions
+	rows  []string
+	hints []string
+
+	// Field references of explain probe, used for dumping
+	fields []*fieldAliasRef
+}
+
+func (b *Builder) newExplainContext(
+	opt *TimeExplainOptions, commonOpt *CommonOptions, rows []string,
+) (*explainContext, error) {
+	ectx := &explainContext{
+		opt:   opt,
+		rows:  rows,
+		hints: commonOpt.Hints,
+	}
+	if !ectx.enabled() {
+		return ectx, nil
+	}
+
+	// Fields are prepared before probes are built as they are
+	// dumped by to probe which may precede explain probe
+	fields, err := b.prepareKeys(opt.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	boSet := b.newBuildObjectSet(nil, opt.MissRows, ectx.hints)
+	err = b.resolveWeakAliasRefs(b.getFieldWeakRefs(fields), boSet)
+	if err != nil {
+		return nil, err
+	}
+	ectx.fields = fields
+	return ectx, nil
+}
+
+func (ectx *explainContext) enabled() bool {
+	return ectx.opt.Spec.Probe != ""
+}
+
+// recordMaps returns maps filled by explain probe
+func (ectx *explainContext) recordMaps() []string {
+	aggrs := []string{"@explain_hit"}
+	for i := range ectx.fields {
+		aggrs = append(aggrs, fmt.Sprintf("@explain_f%d", i))
+	}
+	return aggrs
+}
+
+// prepareExplainKeys generates expressions for keys of the from or to probe
+// which match keys of the explain probe
+func (b *Builder) prepareExplainKeys(
+	ectx *explainContext, ctx *timeProbeContext, rawKeys []string,
+) (*Block, Expression, error) {
+	if len(rawKeys) != len(ectx.opt.Spec.Keys) {
+		return nil, "", fmt.Errorf("explain probe expects %d keys, got %d",
+			len(ectx.opt.Spec.Keys), len(rawKeys))
+	}
+
+	keys, err := b.prepareKeys(rawKeys)
+	if err != nil {
+		return nil, "", err
+	}
+
+	boSet := b.newBuildObjectSet(ctx.filters, ectx.rows, ectx.hints)
+	err = b.resolveWeakAliasRefs(b.getFieldWeakRefs(keys), boSet)
+	if err != nil {
+		return nil, "", err
+	}
+
+	block, exprs, err := b.getBlockWithKeys(ctx.block, keys, ConverterHiddenKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return block, ExprJoin(exprs), nil
+}
+
+// newExplainMark marks packets seen by from probe, so explain probe
+// would ignore lookups done for unrelated packets. Fields recorded for
+// the previous packet of the flow are reset
+func newExplainMark(ectx *explainContext) timeBuilderHelper {
+	return func(b *Builder, ctx *timeProbeContext) error {
+		if !ectx.enabled() {
+			return nil
+		}
+
+		block, keysExpr, err := b.prepareExplainKeys(ectx, ctx, ectx.opt.FromKeys)
+		if err != nil {
+			return err
+		}
+
+		block.Addf("@explain_flow[%s] = 1", keysExpr)
+		for _, aggr := range ectx.recordMaps() {
+			block.Addf("delete(%s[%s])", aggr, keysExpr)
+		}
+		return nil
+	}
+}
+
+// newExplainRecord saves explain fields of the marked packets and dumps
+// them immediately if miss filters match
+func newExplainRecord(ectx *explainContext, dumpOpt CommonDumpOptions) timeBuilderHelper {
+	return func(b *Builder, ctx *timeProbeContext) error {
+		keysExpr := ExprJoin(ctx.keysExprs)
+		ctx.block = ctx.block.AddIfBlock(Exprf("@explain_flow[%s]", keysExpr))
+
+		// Fields are recorded in a nested block so their sanity filters
+		// won't affect miss dumps
+		block, exprs, err := b.getBlockWithKeys(ctx.block, ectx.fields, ConverterHiddenKey)
+		if err != nil {
+			return err
+		}
+		block.Addf("@explain_hit[%s] = 1", keysExpr)
+		for i, expr := range exprs {
+			block.Addf("@explain_f%d[%s] = %s", i, keysExpr, expr)
+		}
+
+		missOpt := ectx.opt.MissFilterOptions
+		if len(missOpt.Filters) == 0 && len(missOpt.RawFilters) == 0 {
+			return nil
+		}
+
+		missFilters, err := b.prepareFilters(missOpt)
+		if err != nil {
+			return err
+		}
+		boSet := b.newBuildObjectSet(missFilters, ectx.opt.MissRows, ectx.hints)
+		err = b.resolveWeakAliasRefs(b.getFilterWeakRefs(missFilters), boSet)
+		if err != nil {
+			return err
+		}
+
+		missBlock, err := b.wrapFilters(ctx.block, missFilters)
+		if err != nil {
+			return err
+		}
+
+		dumpOpt.FieldGroupRows = ectx.opt.MissRows
+		missBlock.Add(Stmt(`printf("MISS ")`))
+		_, err = b.addDumpRowsStatements(missBlock, dumpOpt)
+		return err
+	}
+}
+
+// newExplainDump dumps fields recorded by explain probe for the outlier
+// and deletes them, so they won't be attached to the later packets
+func newExplainDump(ectx *explainContext) timeBuilderHelper {
+	return func(b *Builder, ctx *timeProbeContext) error {
+		if !ectx.enabled() {
+			return nil
+		}
+
+		block, keysExpr, err := b.prepareExplainKeys(ectx, ctx, ectx.opt.ToKeys)
+		if err != nil {
+			return err
+		}
+
+		explainBlock := block.AddIfBlock(Exprf("@explain_hit[%s]", keysExpr))
+		explainBlock.Add(b.generateMapPrintStatement(
+			explainBlock, "EXPLAIN", ectx.fields, "explain_f%d", keysExpr))
+
+		block.Addf("delete(@explain_flow[%s])", keysExpr)
+		for _, aggr := range ectx.recordMaps() {
+			block.Addf("delete(%s[%s])", aggr, keysExpr)
+		}
+		return nil
+	}
+}
+
+// buildExplainProbe adds explain probe to the program
+func (b *Builder) buildExplainProbe(
+	prog *Program, ectx *explainContext, commonOpt *CommonOptions, dumpOpt CommonDumpOptions,
+) error {
+	if !ectx.enabled() {
+		return nil
+	}
+
+	_, err := b.buildTimeProbe(prog, nil,
+		ectx.opt.Spec, ectx.opt.MissRows, commonOpt,
+		combineTimeHelpers(
+			newTimeMeasurePrepare(ConverterHiddenKey),
+			newExplainRecord(ectx, dumpOpt)))
+	if err != nil {
+		return newProbeBuildError(fmt.Sprintf("%s (explain)", ectx.opt.Spec.Probe), err)
+	}
+	return nil
+}
+
+// addExplainCleanup returns maps which should be cleaned up along
+// with start time maps
+func (prog *Program) addExplainCleanup(ectx *explainContext) []string {
+	if !ectx.enabled() {
+		return nil
+	}
+
+	return append([]string{"@explain_flow"}, ectx.recordMaps()...)
+}
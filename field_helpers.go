@@ -45,6 +45,15 @@ func NewObjectConvExpr(format string) FieldConverter {
 	}
 }
 
+// NewLookupConv creates converter which prints value along with the string
+// from the lookup table, so FmtSpec of the field should contain two specifiers.
+// Field should refer to the table by Field.LookupTable.
+func NewLookupConv(table string) FieldConverter {
+	return func(obj, field string) ([]Statement, Expression) {
+		return nil, Exprf("%[1]s->%[2]s, @%[3]s[%[1]s->%[2]s]", obj, field, table)
+	}
+}
+
 func NewObjectBinOpConvExpr(left, right string, binOp string, convType BuiltinType, mask FeatureFlagMask) FieldConverter {
 	if convType != "" {
 		if mask.Supports(FeatureBuiltinTypes) {
@@ -78,6 +78,10 @@ type Field struct {
 	// ConverterMask overrides contexts in which converter will be used
 	ConverterMask uint
 
+	// LookupTable is a name of the lookup table which is referred by
+	// converter expression (see AddLookupTable)
+	LookupTable string
+
 	// Preprocessor function is used to convert human-readable value used in filter
 	// to machine value
 	Preprocessor FieldPreprocessor
@@ -117,6 +121,9 @@ type FieldGroup struct {
 	// aliases prefixed with this string and a dash. Useful for multiple instances
 	// of header, or in/out interface logic
 	FieldAliasPrefix string
+
+	// Candidate groups of weak alias which value is picked at runtime
+	autoGroups []*FieldGroup
 }
 
 type fieldAliasRef struct {
@@ -205,14 +212,28 @@ func (fg *FieldGroup) findFieldByAlias(aliasName string) *Field {
 // If field is being printed (in dump or aggregate) useConverters may be passed to
 // apply human-readable converters
 func (b *Builder) generateFieldExpression(
-	fg *FieldGroup, field *Field, probe *Probe, convMask uint,
+	fg *FieldGroup, field *Field, block *Block, convMask uint,
 ) ([]Statement, Expression, error) {
+	if fg.autoGroups != nil {
+		return b.generateAutoFieldExpression(fg, field, block, convMask)
+	}
+
+	probe := block.probe
 	if field.Converter != nil {
 		fieldConvMask := field.ConverterMask
 		if fieldConvMask == 0 {
 			fieldConvMask = ConverterDump
 		}
 		if convMask&fieldConvMask != 0 {
+			if field.LookupTable != "" {
+				table, ok := b.getLookupTable(field.LookupTable)
+				if !ok {
+					return nil, "", newFieldRefErrorf(fg.Object, field.Name, nil,
+						"lookup table '%s' is not registered", field.LookupTable)
+				}
+				block.prog.addLookupTable(field.LookupTable, table)
+			}
+
 			convStmts, expr := field.Converter(fg.Object, field.Name)
 			return convStmts, expr, nil
 		}
@@ -226,6 +247,10 @@ func (b *Builder) generateFieldExpression(
 		if arg, ok := probe.Args[field.Name]; ok {
 			return nil, Expr(arg), nil
 		}
+		if field.Name == retvalArg {
+			return nil, "", newFieldRefErrorf(fg.Object, field.Name, nil,
+				"return value is only accessible in return probes")
+		}
 
 		return nil, "", newFieldRefErrorf(fg.Object, field.Name, nil,
 			"cannot generate field expression for objectless field")
@@ -237,35 +262,68 @@ func (b *Builder) generateFieldExpression(
 // Generates printf() statements for the corresponding field group
 // No context checking is performed here as it is expected that caller already
 // performed necessary casts
-func (b *Builder) generatePrintStatements(fg *FieldGroup, probe *Probe) ([]Statement, error) {
+func (b *Builder) generatePrintStatements(fg *FieldGroup, block *Block) ([]Statement, error) {
 	var stmts []Statement
 	var values []Expression
 	var fmtSpecs []string
 
 	for _, field := range fg.Fields {
-		fieldStms, expr, err := b.generateFieldExpression(fg, field, probe, ConverterDump)
+		fieldStms, expr, err := b.generateFieldExpression(fg, field, block, ConverterDump)
 		if err != nil {
 			return nil, err
 		}
 
 		stmts = append(stmts, fieldStms...)
 		values = append(values, expr)
-
-		fmtKey := field.FmtKey
-		if len(fmtKey) == 0 {
-			fmtKey = field.Name
-		}
-		fmtSpec := field.FmtSpec
-		if len(fmtSpec) == 0 {
-			fmtSpec = "%d"
-		}
-		fmtSpecs = append(fmtSpecs, fmt.Sprintf("%s %s", fmtKey, fmtSpec))
+		fmtSpecs = append(fmtSpecs, field.keyFmtSpec())
 	}
 
 	return append(stmts, Stmtf(`printf("%s: %s\n", %s)`,
 		strings.ToUpper(fg.Row), strings.Join(fmtSpecs, " "), ExprJoin(values))), nil
 }
 
+// keyFmtSpec returns format key and specifier of the field used in printf()
+func (field *Field) keyFmtSpec() string {
+	fmtKey := field.FmtKey
+	if len(fmtKey) == 0 {
+		fmtKey = field.Name
+	}
+	fmtSpec := field.FmtSpec
+	if len(fmtSpec) == 0 {
+		fmtSpec = "%d"
+	}
+	return fmt.Sprintf("%s %s", fmtKey, fmtSpec)
+}
+
+// generateMapPrintStatement generates printf() statement which prints values
+// of fields saved in maps named by mapFmt and field index for the keys.
+func (b *Builder) generateMapPrintStatement(
+	block *Block, title string, fields []*fieldAliasRef, mapFmt string, keysExpr Expression,
+) Statement {
+	fmtSpecs, values := b.generateMapPrintValues(block, fields, mapFmt, keysExpr)
+	return Stmtf(`printf("%s: %s\n", %s)`, title, strings.Join(fmtSpecs, " "), ExprJoin(values))
+}
+
+// generateMapPrintValues generates format specifiers and values of fields
+// saved in maps. Values of hidden keys are stored in maps, so lookup tables
+// are applied here
+func (b *Builder) generateMapPrintValues(
+	block *Block, fields []*fieldAliasRef, mapFmt string, keysExpr Expression,
+) (fmtSpecs []string, values []Expression) {
+	for i, fref := range fields {
+		fmtSpecs = append(fmtSpecs, fref.field.keyFmtSpec())
+
+		value := Exprf("@"+mapFmt+"[%s]", i, keysExpr)
+		values = append(values, value)
+		if table := fref.field.LookupTable; table != "" {
+			lookupTable, _ := b.getLookupTable(table)
+			block.prog.addLookupTable(table, lookupTable)
+			values = append(values, Exprf("@%s[%s]", table, value))
+		}
+	}
+	return
+}
+
 // Parses key as supplied in CLI in obj->field notation to pair of token and
 // fieldName which can be used for
 func (b *Builder) parseKey(key string) (token string, fieldName string, err error) {
@@ -304,10 +362,10 @@ func (b *Builder) prepareKeys(rawKeys []string) ([]*fieldAliasRef, error) {
 // Generates expressions and statements for keys used by associative arrays.
 // If array is dumped to stdout, useConverters should be set to true to apply converters
 func (b *Builder) generateKeyExpressions(
-	keys []*fieldAliasRef, probe *Probe, convMask uint,
+	keys []*fieldAliasRef, block *Block, convMask uint,
 ) (stmts []Statement, exprs []Expression, err error) {
 	for _, fref := range keys {
-		keyStmts, expr, err := b.generateFieldExpression(fref.fg, fref.field, probe, convMask)
+		keyStmts, expr, err := b.generateFieldExpression(fref.fg, fref.field, block, convMask)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -333,7 +391,7 @@ func (b *Builder) getBlockWithKeys(
 		block = keyBlock
 	}
 
-	stmts, exprs, err := b.generateKeyExpressions(keys, block.probe, convMask)
+	stmts, exprs, err := b.generateKeyExpressions(keys, block, convMask)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -10,8 +10,8 @@ const (
 	reFilterOpGroup = "(==|!=|>=|<=|<|>)"
 
 	// Filter value. Supports dotted notation and some string constants
-	// for field preprocessors
-	reFilterValueGroup = `([A-Za-z0-9.:|]*|"[^"]*")`
+	// (including dashed mnemonics) for field preprocessors
+	reFilterValueGroup = `([A-Za-z0-9.:|\-]*|"[^"]*")`
 )
 
 var reFilter = regexp.MustCompile("^" + strings.Join(
@@ -85,6 +85,16 @@ func (b *Builder) prepareFilters(opt FilterOptions) ([][]*ProcessedFilter, error
 }
 
 func (b *Builder) parseFilter(rawFilter string) ([]*ProcessedFilter, error) {
+	filter, err := ParseFilter(rawFilter)
+	if err != nil {
+		return nil, err
+	}
+	return b.processFilter(filter)
+}
+
+// ParseFilter splits raw filter into its components without resolving
+// fields. For filters using aliases, Object contains alias and Field is empty.
+func ParseFilter(rawFilter string) (*Filter, error) {
 	groups := reFilter.FindStringSubmatch(rawFilter)
 	if len(groups) < 4 || len(groups) > 5 {
 		return nil, newCommonError(ErrLevelFilter, rawFilter, ErrMsgParseError)
@@ -95,13 +105,12 @@ func (b *Builder) parseFilter(rawFilter string) ([]*ProcessedFilter, error) {
 		fieldName = groups[2]
 	}
 
-	filter := &Filter{
+	return &Filter{
 		Object: groups[1],
 		Field:  fieldName,
 		Op:     groups[len(groups)-2],
 		Value:  groups[len(groups)-1],
-	}
-	return b.processFilter(filter)
+	}, nil
 }
 
 func (b *Builder) processFilter(filter *Filter) ([]*ProcessedFilter, error) {
@@ -134,6 +143,22 @@ func (b *Builder) processFilter(filter *Filter) ([]*ProcessedFilter, error) {
 	return []*ProcessedFilter{pf}, nil
 }
 
+// IsReturnValueFilter returns true if filter uses value returned by the
+// function directly or via an alias, so it is only applicable to return probes
+func (b *Builder) IsReturnValueFilter(filter *Filter) (bool, error) {
+	filterChunk, err := b.processFilter(filter)
+	if err != nil {
+		return false, err
+	}
+
+	for _, fref := range filterChunk[0].frefs {
+		if fref.fg.Object == "" && fref.field.Name == retvalArg {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func (b *Builder) processFieldSanityFilters(obj string) []*ProcessedFilter {
 	var sanityFilters []*ProcessedFilter
 	fields := b.fieldObjectMap[obj]
@@ -160,7 +185,7 @@ func (b *Builder) addFilterBlock(
 	for _, filter := range filters {
 		var exprs []Expression
 		for _, fref := range filter.frefs {
-			stmts, expr, err := b.generateFieldExpression(fref.fg, fref.field, block.probe, ConverterFilter)
+			stmts, expr, err := b.generateFieldExpression(fref.fg, fref.field, block, ConverterFilter)
 			if err != nil {
 				return nil, newErrorf(ErrLevelFilter, filter.fieldIdent(), err,
 					"error generating field expression in filter")
@@ -170,37 +195,47 @@ func (b *Builder) addFilterBlock(
 			exprs = append(exprs, expr)
 		}
 
-		var condExprList []Expression
-		for _, value := range strings.Split(filter.Value, "|") {
-			for i, fref := range filter.frefs {
-				fieldValue := value
-				if fref.field.Preprocessor != nil {
-					// Preprocess human-readable value
-					preprocessedValue, err := fref.field.Preprocessor(filter.Op, value)
-					if err != nil {
-						return nil, newErrorf(ErrLevelFilter, filter.fieldIdent(), err,
-							"error preprocessing value")
-					}
-					fieldValue = preprocessedValue
+		condExpr, err := b.generateFilterCondition(filter, exprs)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, condExpr)
+	}
+
+	return block.AddIfBlock(conditions...), nil
+}
+
+// generateFilterCondition generates condition of the filter which compares
+// expressions of its fields to the filter values
+func (b *Builder) generateFilterCondition(filter *ProcessedFilter, exprs []Expression) (Expression, error) {
+	var condExprList []Expression
+	for _, value := range strings.Split(filter.Value, "|") {
+		for i, fref := range filter.frefs {
+			fieldValue := value
+			if fref.field.Preprocessor != nil {
+				// Preprocess human-readable value
+				preprocessedValue, err := fref.field.Preprocessor(filter.Op, value)
+				if err != nil {
+					return NilExpr, newErrorf(ErrLevelFilter, filter.fieldIdent(), err,
+						"error preprocessing value")
 				}
+				fieldValue = preprocessedValue
+			}
 
-				if fref.field.FilterOperator != nil {
-					condExpr, err := fref.field.FilterOperator(exprs[i], filter.Op, fieldValue)
-					if err != nil {
-						return nil, newErrorf(ErrLevelFilter, filter.fieldIdent(), err,
-							"error in filter operator")
-					}
-					condExprList = append(condExprList, condExpr)
-				} else {
-					condExprList = append(condExprList,
-						Exprf("%s %s %s", exprs[i], filter.Op, fieldValue))
+			if fref.field.FilterOperator != nil {
+				condExpr, err := fref.field.FilterOperator(exprs[i], filter.Op, fieldValue)
+				if err != nil {
+					return NilExpr, newErrorf(ErrLevelFilter, filter.fieldIdent(), err,
+						"error in filter operator")
 				}
+				condExprList = append(condExprList, condExpr)
+			} else {
+				condExprList = append(condExprList,
+					Exprf("%s %s %s", exprs[i], filter.Op, fieldValue))
 			}
 		}
-		conditions = append(conditions, ExprJoinOp(condExprList, "||"))
 	}
-
-	return block.AddIfBlock(conditions...), nil
+	return ExprJoinOp(condExprList, "||"), nil
 }
 
 func (b *Builder) getFilterWeakRefs(filters [][]*ProcessedFilter) []weakAliasRef {
@@ -0,0 +1,228 @@
+package skbtrace
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yandex-cloud/skbtrace/pkg/bpfout"
+)
+
+// flowsEndMarker is printed after flow maps so output processor can
+// render them as a table
+const flowsEndMarker = "--- END OF FLOWS ---"
+
+// Options for BuildFlows
+type FlowOptions struct {
+	TraceCommonOptions
+
+	// Interval of printing flows table
+	Interval time.Duration
+
+	// Keys identifying the flow such as 5-tuple
+	Keys []string
+
+	// Fields for packet length and TTL (hop limit) statistics
+	LenField string
+	TtlField string
+
+	// Optional TCP fields for collecting flags seen in the flow and
+	// counting retransmits, which are detected as sequence number
+	// going backwards
+	TcpFlagsField string
+	SeqField      string
+}
+
+type flowStat struct {
+	column bpfout.Column
+	field  string
+
+	// Converters applied to the field, by default raw value is used
+	convMask uint
+
+	build func(block *Block, keys, expr Expression)
+}
+
+func newFlowStats(opt *FlowOptions) []flowStat {
+	stats := []flowStat{
+		{column: bpfout.Column{Header: "PKTS", Map: "flow_packets"},
+			build: func(block *Block, keys, expr Expression) {
+				block.Addf("@flow_packets[%s] = count()", keys)
+			}},
+		{column: bpfout.Column{Header: "BYTES", Map: "flow_bytes"}, field: opt.LenField,
+			build: func(block *Block, keys, expr Expression) {
+				block.Addf("@flow_bytes[%s] = sum(%s)", keys, expr)
+			}},
+		{column: bpfout.Column{Header: "FIRST", Map: "flow_first", Format: formatFlowFirstTime},
+			build: func(block *Block, keys, expr Expression) {
+				block.AddIfBlock(Exprf("@flow_first[%s] == 0", keys)).Addf(
+					"@flow_first[%s] = elapsed / 1000000 + 1", keys)
+			}},
+		{column: bpfout.Column{Header: "LAST", Map: "flow_last", Format: formatFlowTime},
+			build: func(block *Block, keys, expr Expression) {
+				block.Addf("@flow_last[%s] = elapsed / 1000000", keys)
+			}},
+		{column: bpfout.Column{Header: "TTL MIN", Map: "flow_ttl_min"}, field: opt.TtlField,
+			build: func(block *Block, keys, expr Expression) {
+				block.Addf("@flow_ttl_min[%s] = min(%s)", keys, expr)
+			}},
+		{column: bpfout.Column{Header: "TTL MAX", Map: "flow_ttl_max"}, field: opt.TtlField,
+			build: func(block *Block, keys, expr Expression) {
+				block.Addf("@flow_ttl_max[%s] = max(%s)", keys, expr)
+			}},
+	}
+
+	if opt.TcpFlagsField != "" {
+		stats = append(stats, flowStat{
+			column: bpfout.Column{Header: "FLAGS", Map: "flow_flags", Format: formatFlowTcpFlags},
+			field:  opt.TcpFlagsField,
+			build: func(block *Block, keys, expr Expression) {
+				block.Addf("@flow_flags[%s] = @flow_flags[%s] | %s", keys, keys, expr)
+			}})
+	}
+	if opt.SeqField != "" {
+		stats = append(stats, flowStat{
+			column: bpfout.Column{Header: "RETRANS", Map: "flow_retrans", Default: "0"},
+			field:  opt.SeqField, convMask: ConverterDump,
+			build: func(block *Block, keys, expr Expression) {
+				block.Addf("$seq = %s", expr)
+				block.AddIfBlock(Exprf("$seq < @flow_seq[%s]", keys)).Addf(
+					"@flow_retrans[%s] = count()", keys)
+				block.AddBlock("else").Addf("@flow_seq[%s] = $seq", keys)
+			}})
+	}
+	return stats
+}
+
+// formatFlowTime formats milliseconds since start of tracing as seconds
+func formatFlowTime(value string) string {
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return value
+	}
+	return fmt.Sprintf("%d.%03d", ms/1000, ms%1000)
+}
+
+// formatFlowFirstTime formats first seen time which is stored with offset
+// of one millisecond, so flows seen in the first millisecond of tracing are
+// distinguishable from the flows which are not seen yet
+func formatFlowFirstTime(value string) string {
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return value
+	}
+	return formatFlowTime(strconv.FormatInt(ms-1, 10))
+}
+
+// formatFlowTcpFlags formats union of TCP flags seen in the flow in the
+// same way as tcp-flags field is formatted in dumps
+func formatFlowTcpFlags(value string) string {
+	flags, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		return value
+	}
+
+	var sb strings.Builder
+	for _, flag := range []struct {
+		chr byte
+		val uint64
+	}{{'S', 0x02}, {'A', 0x10}, {'P', 0x08}, {'F', 0x01}, {'R', 0x04}} {
+		if flags&flag.val != 0 {
+			sb.WriteByte(flag.chr)
+		} else {
+			sb.WriteByte('-')
+		}
+	}
+	return sb.String()
+}
+
+// BuildFlows builds a program which collects per-flow statistics such as
+// number of packets and bytes, first and last seen times, and prints them
+// as a conntrack-like table on interval and on exit.
+func (b *Builder) BuildFlows(opt FlowOptions) (*Program, error) {
+	if len(opt.Keys) == 0 {
+		return nil, newCommonError(ErrLevelField, "keys", ErrMsgNotSpecified)
+	}
+
+	stats := newFlowStats(&opt)
+	prog, err := b.buildTracerImpl(&opt.TraceCommonOptions, nil,
+		func(block *Block) error {
+			boSet := b.newBuildObjectSet(nil, nil, opt.Hints)
+
+			keys, err := b.prepareKeys(opt.Keys)
+			if err != nil {
+				return err
+			}
+			err = b.resolveWeakAliasRefs(b.getFieldWeakRefs(keys), boSet)
+			if err != nil {
+				return err
+			}
+
+			keyBlock, keyExprs, err := b.getBlockWithKeys(block, keys, ConverterDump)
+			if err != nil {
+				return err
+			}
+			keysExpr := ExprJoin(keyExprs)
+
+			for _, stat := range stats {
+				var fields []*fieldAliasRef
+				if stat.field != "" {
+					fields, err = b.prepareKeys([]string{stat.field})
+					if err != nil {
+						return err
+					}
+					err = b.resolveWeakAliasRefs(b.getFieldWeakRefs(fields), boSet)
+					if err != nil {
+						return err
+					}
+				}
+
+				convMask := stat.convMask
+				if convMask == 0 {
+					convMask = ConverterHiddenKey
+				}
+
+				statBlock, exprs, err := b.getBlockWithKeys(keyBlock, fields, convMask)
+				if err != nil {
+					return err
+				}
+
+				var expr Expression
+				if len(exprs) > 0 {
+					expr = exprs[0]
+				}
+				stat.build(statBlock, keysExpr, expr)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	processor := &bpfout.TableProcessor{EndMarker: flowsEndMarker}
+	for _, key := range opt.Keys {
+		processor.KeyHeaders = append(processor.KeyHeaders, strings.ToUpper(key))
+	}
+
+	// Counters are cumulative, so table summarizes flows since they are
+	// first seen. They expire all together with periodic cleanup so memory
+	// stays bounded and flows seen again start over
+	var printMaps, stateMaps []string
+	for _, stat := range stats {
+		processor.Columns = append(processor.Columns, stat.column)
+
+		aggr := "@" + stat.column.Map
+		printMaps = append(printMaps, aggr)
+		stateMaps = append(stateMaps, aggr)
+	}
+	if opt.SeqField != "" {
+		stateMaps = append(stateMaps, "@flow_seq")
+	}
+
+	prog.addTableDumpBlocks(opt.Interval, flowsEndMarker, printMaps, nil)
+	prog.addAggrCleanupBlock(stateMaps...)
+
+	prog.OutputProcessor = processor
+	return prog, nil
+}
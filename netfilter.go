@@ -0,0 +1,70 @@
+package skbtrace
+
+// Options for BuildNetfilter
+type NetfilterOptions struct {
+	TraceCommonOptions
+
+	// Keys verdicts are aggregated by, such as table and hook.
+	// Individual rules within the table or chain are not identified as
+	// the rule is not accessible when verdict is returned
+	Keys []string
+
+	// Field containing verdict in the return probe
+	VerdictField string
+
+	// If set, verdicts are aggregated by keys instead of being dumped
+	Aggregate bool
+	AggregateCommonOptions
+
+	CommonDumpOptions
+}
+
+// BuildNetfilter builds a program which reports verdicts of netfilter probes
+// per packet or aggregates them by keys such as table and hook. Netfilter probes are return probes
+// which stash their arguments, so packet and hook state are accessible when
+// verdict is returned.
+func (b *Builder) BuildNetfilter(opt NetfilterOptions) (*Program, error) {
+	if opt.VerdictField == "" {
+		return nil, newCommonError(ErrLevelField, "verdict", ErrMsgNotSpecified)
+	}
+
+	prog, err := b.buildTracerImpl(&opt.TraceCommonOptions, opt.FieldGroupRows,
+		func(block *Block) error {
+			if opt.Aggregate {
+				return b.addNetfilterAggregate(block, &opt)
+			}
+			_, err := b.addDumpRowsStatements(block, opt.CommonDumpOptions)
+			return err
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.Aggregate {
+		prog.addAggrDumpBlock(&opt.AggregateCommonOptions)
+	}
+	return prog, nil
+}
+
+func (b *Builder) addNetfilterAggregate(block *Block, opt *NetfilterOptions) error {
+	keys, err := b.prepareKeys(append(append([]string{}, opt.Keys...), opt.VerdictField))
+	if err != nil {
+		return err
+	}
+	err = b.resolveWeakAliasRefs(b.getFieldWeakRefs(keys),
+		b.newBuildObjectSet(nil, nil, opt.Hints))
+	if err != nil {
+		return err
+	}
+
+	keyBlock, keyExprs, err := b.getBlockWithKeys(block, keys, ConverterDump)
+	if err != nil {
+		return err
+	}
+
+	if len(opt.ProbeNames) > 1 {
+		keyExprs = append(keyExprs, Exprf(`"%s"`, block.probe.Name))
+	}
+	keyBlock.Addf("@[%s] = count()", ExprJoin(keyExprs))
+	return nil
+}
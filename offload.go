@@ -0,0 +1,156 @@
+package skbtrace
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/yandex-cloud/skbtrace/pkg/bpfout"
+)
+
+// offloadEndMarker is printed after offload maps so output processor can
+// render them as a table
+const offloadEndMarker = "--- END OF OFFLOAD ---"
+
+const (
+	offloadDirRx = "rx"
+	offloadDirTx = "tx"
+)
+
+// Options for BuildOffload
+type OffloadOptions struct {
+	TraceCommonOptions
+
+	// Interval of printing offload table
+	Interval time.Duration
+
+	// Keys identifying the device and the flow
+	Keys []string
+
+	// Probes in which received and transmitted skbs are accounted and
+	// the probe which fires when GSO is done in software. Empty probes
+	// are skipped
+	RxProbe    string
+	TxProbe    string
+	SwGsoProbe string
+
+	// Fields for GSO segmentation info of skb
+	GsoSizeField string
+	GsoSegsField string
+}
+
+var offloadColumns = []bpfout.Column{
+	{Header: "SKBS", Map: "offload_skbs"},
+	{Header: "SEGS", Map: "offload_segs"},
+	{Header: "SEGS/SKB", Compute: bpfout.Ratio("offload_segs", "offload_skbs")},
+	{Header: "NO OFFLOAD", Map: "offload_single", Default: "0"},
+	{Header: "SW GSO", Map: "offload_sw_gso", Default: "0"},
+}
+
+// addOffloadSegments accounts number of wire segments represented by skb. GSO
+// segments might not be yet computed for skbs coming from untrusted sources such
+// as virtio or tap devices. They cannot be estimated from skb length as it
+// includes headers which are replicated in each segment, so such skbs are skipped
+func (b *Builder) addOffloadSegments(block *Block, opt *OffloadOptions, keysExpr Expression) error {
+	fields, err := b.prepareKeys([]string{opt.GsoSizeField, opt.GsoSegsField})
+	if err != nil {
+		return err
+	}
+
+	block, exprs, err := b.getBlockWithKeys(block, fields, ConverterHiddenKey)
+	if err != nil {
+		return err
+	}
+
+	gsoSizeExpr, gsoSegsExpr := exprs[0], exprs[1]
+	block.Addf("$segs = %s", gsoSegsExpr)
+	block.AddIfBlock(Exprf("%s == 0", gsoSizeExpr)).Add(Stmt("$segs = 1"))
+
+	block = block.AddIfBlock(Expr("$segs > 0"))
+	block.Addf("@offload_skbs[%s] = count()", keysExpr)
+	block.Addf("@offload_segs[%s] = sum($segs)", keysExpr)
+	block.AddIfBlock(Expr("$segs == 1")).Addf(
+		"@offload_single[%s] = count()", keysExpr)
+	return nil
+}
+
+// BuildOffload builds a program which reports how many wire segments skbs
+// represent per device and flow in each direction, so GRO merge ratio and GSO
+// split ratio can be seen along with skbs which bypass offloading and skbs
+// segmented in software.
+func (b *Builder) BuildOffload(opt OffloadOptions) (*Program, error) {
+	if len(opt.Keys) == 0 {
+		return nil, newCommonError(ErrLevelField, "keys", ErrMsgNotSpecified)
+	}
+
+	probeDirs := make(map[*Probe]string)
+	opt.ProbeNames = nil
+	for _, probe := range []struct {
+		name string
+		dir  string
+	}{{opt.RxProbe, offloadDirRx}, {opt.TxProbe, offloadDirTx}, {opt.SwGsoProbe, offloadDirTx}} {
+		if probe.name == "" {
+			continue
+		}
+
+		p, ok := b.probeMap[probe.name]
+		if !ok {
+			return nil, newCommonError(ErrLevelProbe, probe.name, ErrMsgNotFound)
+		}
+		probeDirs[p] = probe.dir
+		opt.ProbeNames = append(opt.ProbeNames, probe.name)
+	}
+	if len(opt.ProbeNames) == 0 {
+		return nil, errors.New("no probes are specified for offload tracing")
+	}
+	swGsoProbe := b.probeMap[opt.SwGsoProbe]
+
+	prog, err := b.buildTracerImpl(&opt.TraceCommonOptions, nil,
+		func(block *Block) error {
+			keys, err := b.prepareKeys(opt.Keys)
+			if err != nil {
+				return err
+			}
+			err = b.resolveWeakAliasRefs(b.getFieldWeakRefs(keys),
+				b.newBuildObjectSet(nil, nil, opt.Hints))
+			if err != nil {
+				return err
+			}
+
+			keyBlock, keyExprs, err := b.getBlockWithKeys(block, keys, ConverterDump)
+			if err != nil {
+				return err
+			}
+
+			dirExpr := Exprf(`"%s"`, probeDirs[block.probe])
+			keysExpr := ExprJoin(append([]Expression{dirExpr}, keyExprs...))
+			if block.probe == swGsoProbe {
+				keyBlock.Addf("@offload_sw_gso[%s] = count()", keysExpr)
+				return nil
+			}
+			return b.addOffloadSegments(keyBlock, &opt, keysExpr)
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	processor := &bpfout.TableProcessor{
+		KeyHeaders: []string{"DIR"},
+		Columns:    offloadColumns,
+		EndMarker:  offloadEndMarker,
+	}
+	for _, key := range opt.Keys {
+		processor.KeyHeaders = append(processor.KeyHeaders, strings.ToUpper(key))
+	}
+
+	var aggrs []string
+	for _, column := range offloadColumns {
+		if column.Compute == nil {
+			aggrs = append(aggrs, "@"+column.Map)
+		}
+	}
+	prog.addTableDumpBlocks(opt.Interval, offloadEndMarker, aggrs, aggrs)
+
+	prog.OutputProcessor = processor
+	return prog, nil
+}
@@ -0,0 +1,41 @@
+// Package bpfout parses output of bpftrace scripts such as printed maps
+// so it can be post-processed before showing it to user.
+package bpfout
+
+import (
+	"regexp"
+	"strings"
+)
+
+// MapEntry is a single entry of the map printed by print() or on exit
+type MapEntry struct {
+	// Name of the map without '@' prefix, empty for anonymous map
+	Map string
+
+	// Keys of the entry, nil for scalar maps
+	Keys []string
+
+	Value string
+}
+
+var reMapEntry = regexp.MustCompile(`^@(\w*)(?:\[(.*)\])?: (.*)$`)
+
+// ParseMapEntry parses line in form of '@map[key1, key2]: value'. Returns
+// false if line doesn't look like a map entry.
+func ParseMapEntry(line string) (*MapEntry, bool) {
+	groups := reMapEntry.FindStringSubmatch(line)
+	if groups == nil {
+		return nil, false
+	}
+
+	entry := &MapEntry{Map: groups[1], Value: groups[3]}
+	if len(groups[2]) > 0 {
+		entry.Keys = strings.Split(groups[2], ", ")
+	}
+	return entry, true
+}
+
+// KeyString joins keys of the entry so it can be used as identity of the row
+func (entry *MapEntry) KeyString() string {
+	return strings.Join(entry.Keys, ", ")
+}
@@ -0,0 +1,104 @@
+package bpfout
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMapEntry(t *testing.T) {
+	t.Run("Keys", func(t *testing.T) {
+		entry, ok := ParseMapEntry("@flow_packets[10.0.0.1, 10.0.0.2, 80]: 12")
+		require.True(t, ok)
+		assert.Equal(t, &MapEntry{Map: "flow_packets",
+			Keys: []string{"10.0.0.1", "10.0.0.2", "80"}, Value: "12"}, entry)
+	})
+
+	t.Run("Scalar", func(t *testing.T) {
+		entry, ok := ParseMapEntry("@: 5")
+		require.True(t, ok)
+		assert.Equal(t, &MapEntry{Value: "5"}, entry)
+	})
+
+	t.Run("NotEntry", func(t *testing.T) {
+		_, ok := ParseMapEntry("12:00:01")
+		assert.False(t, ok)
+	})
+}
+
+func TestTableProcessor(t *testing.T) {
+	tp := &TableProcessor{
+		KeyHeaders: []string{"SRC"},
+		Columns: []Column{
+			{Header: "PKTS", Map: "pkts"},
+			{Header: "RETRANS", Map: "retrans", Default: "0"},
+			{Header: "SEEN", Map: "seen", Format: func(value string) string { return value + "ms" }},
+		},
+		EndMarker: "--",
+	}
+
+	input := strings.Join([]string{
+		"12:00:01",
+		"@pkts[10.0.0.2]: 3",
+		"@pkts[10.0.0.1]: 1",
+		"",
+		"@retrans[10.0.0.2]: 1",
+		"",
+		"@seen[10.0.0.1]: 10",
+		"@seen[10.0.0.2]: 20",
+		"@seen[10.0.0.3]: 5",
+		"--",
+		"@hits[xmit]: 4",
+	}, "\n")
+
+	out := bytes.NewBuffer(nil)
+	require.NoError(t, tp.Process(strings.NewReader(input), out))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 6)
+	assert.Equal(t, "12:00:01", lines[0])
+	assert.Equal(t, []string{"SRC", "PKTS", "RETRANS", "SEEN"}, strings.Fields(strings.ReplaceAll(lines[1], "|", "")))
+	assert.Equal(t, []string{"10.0.0.1", "1", "0", "10ms"}, strings.Fields(strings.ReplaceAll(lines[3], "|", "")))
+	assert.Equal(t, []string{"10.0.0.2", "3", "1", "20ms"}, strings.Fields(strings.ReplaceAll(lines[4], "|", "")))
+	assert.Equal(t, "@hits[xmit]: 4", lines[5])
+}
+
+func TestRatio(t *testing.T) {
+	ratio := Ratio("segs", "skbs")
+	assert.Equal(t, "2.50", ratio(map[string]string{"segs": "5", "skbs": "2"}))
+	assert.Equal(t, "-", ratio(map[string]string{"segs": "5"}))
+	assert.Equal(t, "-", ratio(map[string]string{"segs": "5", "skbs": "0"}))
+}
+
+func TestParseHist(t *testing.T) {
+	entry, ok := ParseMapHeader("@[eth0, fq_codel]:")
+	require.True(t, ok)
+	assert.Equal(t, &MapEntry{Keys: []string{"eth0", "fq_codel"}}, entry)
+
+	_, ok = ParseMapHeader("@[eth0]: 5")
+	assert.False(t, ok)
+
+	var buckets []HistBucket
+	for _, line := range []string{
+		"[1]                    2 |@@@@                                    |",
+		"[2, 4)                 5 |@@@@@@@@@@                              |",
+		"[4K, 8K)               3 |@@@@@@                                  |",
+	} {
+		bucket, ok := ParseHistBucket(line)
+		require.True(t, ok, line)
+		buckets = append(buckets, *bucket)
+	}
+	assert.Equal(t, HistBucket{Low: "4K", High: "8K", Count: 3}, buckets[2])
+	assert.Equal(t, "[1]", buckets[0].String())
+
+	assert.EqualValues(t, 10, HistCount(buckets))
+	assert.Equal(t, "[2, 4)", HistPercentile(buckets, 50).String())
+	assert.Equal(t, "[4K, 8K)", HistPercentile(buckets, 99).String())
+	assert.Nil(t, HistPercentile(nil, 50))
+
+	_, ok = ParseHistBucket("12:00:01")
+	assert.False(t, ok)
+}
@@ -0,0 +1,89 @@
+package bpfout
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// HistBucket is a single bucket of the histogram printed by hist() or lhist()
+type HistBucket struct {
+	// Low and High are bounds of the bucket as printed by bpftrace, i.e.
+	// "4K". High is empty for buckets which contain a single value.
+	Low  string
+	High string
+
+	Count uint64
+}
+
+var (
+	reMapHeader  = regexp.MustCompile(`^@(\w*)(?:\[(.*)\])?:$`)
+	reHistBucket = regexp.MustCompile(`^[\[(]([^,\])]*)(?:, ([^\])]*))?[\])]\s+(\d+) \|`)
+)
+
+// ParseMapHeader parses line in form of '@map[key1, key2]:' which precedes
+// buckets of a histogram. Returns false if line doesn't look like a header.
+func ParseMapHeader(line string) (*MapEntry, bool) {
+	groups := reMapHeader.FindStringSubmatch(line)
+	if groups == nil {
+		return nil, false
+	}
+
+	entry := &MapEntry{Map: groups[1]}
+	if len(groups[2]) > 0 {
+		entry.Keys = strings.Split(groups[2], ", ")
+	}
+	return entry, true
+}
+
+// ParseHistBucket parses line in form of '[4, 8)    20 |@@@@   |'. Returns
+// false if line doesn't look like a histogram bucket.
+func ParseHistBucket(line string) (*HistBucket, bool) {
+	groups := reHistBucket.FindStringSubmatch(line)
+	if groups == nil {
+		return nil, false
+	}
+
+	count, err := strconv.ParseUint(groups[3], 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	return &HistBucket{Low: groups[1], High: groups[2], Count: count}, true
+}
+
+// String formats bucket bounds the same way bpftrace does
+func (bucket *HistBucket) String() string {
+	if bucket.High == "" {
+		return fmt.Sprintf("[%s]", bucket.Low)
+	}
+	return fmt.Sprintf("[%s, %s)", bucket.Low, bucket.High)
+}
+
+// HistCount returns total number of values in histogram buckets
+func HistCount(buckets []HistBucket) uint64 {
+	var count uint64
+	for _, bucket := range buckets {
+		count += bucket.Count
+	}
+	return count
+}
+
+// HistPercentile returns bucket which contains specified percentile of
+// values or nil if histogram is empty
+func HistPercentile(buckets []HistBucket, percentile float64) *HistBucket {
+	total := HistCount(buckets)
+	if total == 0 {
+		return nil
+	}
+
+	threshold := float64(total) * percentile / 100
+	var count uint64
+	for i := range buckets {
+		count += buckets[i].Count
+		if float64(count) >= threshold {
+			return &buckets[i]
+		}
+	}
+	return &buckets[len(buckets)-1]
+}
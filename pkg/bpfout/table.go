@@ -0,0 +1,153 @@
+package bpfout
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/olekukonko/tablewriter"
+)
+
+// Column describes a column of the table collected from a single map
+type Column struct {
+	Header string
+	Map    string
+
+	// Format converts raw value of the map entry, by default value is
+	// printed as is
+	Format func(value string) string
+
+	// Default is printed if map doesn't contain entry for the row
+	Default string
+
+	// Compute derives value of the column without map from values of
+	// the other columns in the row keyed by their map names
+	Compute func(values map[string]string) string
+}
+
+// Ratio returns compute function for a column which divides values
+// of two maps. Dash is printed if either of values is missing.
+func Ratio(numMap, denomMap string) func(values map[string]string) string {
+	return func(values map[string]string) string {
+		num, err := strconv.ParseFloat(values[numMap], 64)
+		if err != nil {
+			return "-"
+		}
+		denom, err := strconv.ParseFloat(values[denomMap], 64)
+		if err != nil || denom == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.2f", num/denom)
+	}
+}
+
+// TableProcessor joins maps which use same keys into a table. Maps are
+// collected until end marker line is printed by script. Other lines are
+// passed as is. Rows which lack value of the first column are considered
+// stale and are not rendered.
+type TableProcessor struct {
+	KeyHeaders []string
+	Columns    []Column
+
+	EndMarker string
+}
+
+type tableRow struct {
+	keys   []string
+	values map[string]string
+}
+
+func (tp *TableProcessor) findColumn(mapName string) *Column {
+	for i := range tp.Columns {
+		if tp.Columns[i].Compute == nil && tp.Columns[i].Map == mapName {
+			return &tp.Columns[i]
+		}
+	}
+	return nil
+}
+
+// Process reads output of bpftrace from r and writes it to w replacing
+// collected maps with tables
+func (tp *TableProcessor) Process(r io.Reader, w io.Writer) error {
+	rows := make(map[string]*tableRow)
+	collecting := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == tp.EndMarker {
+			tp.render(w, rows)
+			rows = make(map[string]*tableRow)
+			collecting = false
+			continue
+		}
+
+		if entry, ok := ParseMapEntry(line); ok {
+			if column := tp.findColumn(entry.Map); column != nil {
+				key := entry.KeyString()
+				row, ok := rows[key]
+				if !ok {
+					row = &tableRow{keys: entry.Keys, values: make(map[string]string)}
+					rows[key] = row
+				}
+
+				row.values[column.Map] = entry.Value
+				collecting = true
+				continue
+			}
+		}
+
+		// print() separates maps with empty lines which are not needed in table
+		if collecting && len(line) == 0 {
+			continue
+		}
+
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (tp *TableProcessor) render(w io.Writer, rows map[string]*tableRow) {
+	keys := make([]string, 0, len(rows))
+	for key, row := range rows {
+		if _, ok := row.values[tp.Columns[0].Map]; ok {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+	sort.Strings(keys)
+
+	header := append([]string{}, tp.KeyHeaders...)
+	for _, column := range tp.Columns {
+		header = append(header, column.Header)
+	}
+
+	tw := tablewriter.NewWriter(w)
+	tw.SetHeader(header)
+	tw.SetAutoFormatHeaders(false)
+	tw.SetBorder(false)
+	for _, key := range keys {
+		row := rows[key]
+		line := append([]string{}, row.keys...)
+		for _, column := range tp.Columns {
+			value, ok := row.values[column.Map]
+			switch {
+			case column.Compute != nil:
+				value = column.Compute(row.values)
+			case !ok:
+				value = column.Default
+			case column.Format != nil:
+				value = column.Format(value)
+			}
+			line = append(line, value)
+		}
+		tw.Append(line)
+	}
+	tw.Render()
+}
@@ -0,0 +1,21 @@
+package bpfsim
+
+import "fmt"
+
+// ScriptError is returned when script cannot be parsed or uses
+// constructs which are not supported by simulator
+type ScriptError struct {
+	Line    int
+	Message string
+}
+
+func (e *ScriptError) Error() string {
+	if e.Line == 0 {
+		return "bpfsim: " + e.Message
+	}
+	return fmt.Sprintf("bpfsim: line %d: %s", e.Line, e.Message)
+}
+
+func newSyntaxError(line int, format string, args ...interface{}) *ScriptError {
+	return &ScriptError{Line: line, Message: fmt.Sprintf(format, args...)}
+}
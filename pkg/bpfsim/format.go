@@ -0,0 +1,99 @@
+package bpfsim
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sprintf formats arguments according to printf() format of bpftrace.
+// Length modifiers are ignored as all integers are 64-bit.
+func (m *machine) sprintf(format string, args []value) string {
+	var buf strings.Builder
+	argIndex := 0
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			buf.WriteByte(c)
+			continue
+		}
+		if i+1 < len(format) && format[i+1] == '%' {
+			buf.WriteByte('%')
+			i++
+			continue
+		}
+
+		spec := "%"
+		for i++; i < len(format) && strings.IndexByte("-+ 0#.0123456789", format[i]) >= 0; i++ {
+			spec += string(format[i])
+		}
+		for i < len(format) && strings.IndexByte("lhzj", format[i]) >= 0 {
+			i++
+		}
+		if i == len(format) {
+			m.fail("incomplete format specifier in '%s'", format)
+		}
+		if argIndex == len(args) {
+			m.fail("not enough arguments for format '%s'", format)
+		}
+
+		arg := args[argIndex]
+		argIndex++
+		switch verb := format[i]; verb {
+		case 'd', 'i':
+			fmt.Fprintf(&buf, spec+"d", m.toInt(m.rvalue(arg)))
+		case 'u':
+			fmt.Fprintf(&buf, spec+"d", uint64(m.toInt(m.rvalue(arg))))
+		case 'x', 'X', 'o':
+			fmt.Fprintf(&buf, spec+string(verb), uint64(m.toInt(m.rvalue(arg))))
+		case 'p':
+			fmt.Fprintf(&buf, "0x%x", uint64(m.toInt(arg)))
+		case 'c':
+			fmt.Fprintf(&buf, spec+"c", rune(m.toInt(m.rvalue(arg))))
+		case 's':
+			fmt.Fprintf(&buf, spec+"s", m.toString(arg))
+		default:
+			m.fail("unsupported format specifier '%c'", verb)
+		}
+	}
+
+	if argIndex != len(args) {
+		m.fail("too many arguments for format '%s'", format)
+	}
+	return buf.String()
+}
+
+var strftimeLayouts = map[byte]string{
+	'H': "15",
+	'M': "04",
+	'S': "05",
+	'Y': "2006",
+	'y': "06",
+	'm': "01",
+	'd': "02",
+	'b': "Jan",
+	'a': "Mon",
+	'T': "15:04:05",
+	'F': "2006-01-02",
+}
+
+// strftime formats time as time() does. Simulated time is always in UTC.
+func strftime(format string, t time.Time) string {
+	var buf strings.Builder
+	t = t.UTC()
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			buf.WriteByte(format[i])
+			continue
+		}
+
+		i++
+		if layout, ok := strftimeLayouts[format[i]]; ok {
+			buf.WriteString(t.Format(layout))
+		} else {
+			buf.WriteByte('%')
+			buf.WriteByte(format[i])
+		}
+	}
+	return buf.String()
+}
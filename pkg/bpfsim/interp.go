@@ -0,0 +1,557 @@
+package bpfsim
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// machine executes probes of the script against simulated memory
+type machine struct {
+	script *script
+	mem    *memory
+	maps   map[string]*bpfMap
+	w      io.Writer
+
+	start time.Time
+	now   time.Time
+
+	// State of the probe being executed
+	event  *Event
+	args   map[string]value
+	vars   map[string]value
+	line   int
+	exited bool
+}
+
+func (m *machine) fail(format string, args ...interface{}) {
+	panic(newSyntaxError(m.line, format, args...))
+}
+
+// runProbe executes probe for the event. Errors are reported by panics
+// which are recovered by the caller
+func (m *machine) runProbe(pr *probe, ev *Event, args map[string]value) {
+	m.event = ev
+	m.args = args
+	m.vars = make(map[string]value)
+	m.execBlock(pr.body)
+}
+
+func (m *machine) execBlock(stmts []stmt) {
+	for _, st := range stmts {
+		if m.exited {
+			return
+		}
+		m.execStmt(st)
+	}
+}
+
+func (m *machine) execStmt(st stmt) {
+	switch st := st.(type) {
+	case *exprStmt:
+		m.line = st.line
+		m.eval(st.x)
+	case *assignStmt:
+		m.line = st.line
+		m.execAssign(st)
+	case *ifStmt:
+		m.line = st.line
+		if m.isTrue(m.eval(st.cond)) {
+			m.execBlock(st.then)
+		} else {
+			m.execBlock(st.els)
+		}
+	}
+}
+
+func (m *machine) execAssign(st *assignStmt) {
+	if ref, ok := st.target.(*varRef); ok {
+		v := m.eval(st.value)
+		if st.op != "=" {
+			cur, ok := m.vars[ref.name]
+			if !ok {
+				m.fail("variable %s is used before assignment", ref.name)
+			}
+			v = m.binaryOp(strings.TrimSuffix(st.op, "="), cur, v)
+		}
+		m.vars[ref.name] = v
+		return
+	}
+
+	ref := st.target.(*mapRef)
+	bm, key, keys := m.lookupMap(ref)
+	if call, ok := st.value.(*callExpr); ok && aggregationFuncs[call.fn] {
+		if st.op != "=" {
+			m.fail("%s() can be only assigned", call.fn)
+		}
+		m.aggregate(bm, key, keys, call)
+		return
+	}
+
+	v := m.rvalue(m.eval(st.value))
+	entry, ok := bm.entries[key]
+	if st.op != "=" {
+		cur := intValue(0)
+		if ok {
+			cur = entry.value()
+		}
+		v = m.binaryOp(strings.TrimSuffix(st.op, "="), cur, v)
+	}
+	if ok && entry.aggr != nil {
+		m.fail("map %s contains aggregation", ref.name)
+	}
+	bm.entries[key] = &mapEntry{keys: keys, val: v}
+}
+
+func (m *machine) aggregate(bm *bpfMap, key string, keys []value, call *callExpr) {
+	n := int64(1)
+	if call.fn == "count" {
+		if len(call.args) != 0 {
+			m.fail("count() doesn't have arguments")
+		}
+	} else {
+		if len(call.args) != 1 {
+			m.fail("%s() expects single argument", call.fn)
+		}
+		n = m.toInt(m.eval(call.args[0]))
+	}
+
+	entry, ok := bm.entries[key]
+	if !ok {
+		entry = &mapEntry{keys: keys, aggr: &aggregation{fn: call.fn, buckets: make(map[int]int64)}}
+		bm.entries[key] = entry
+	} else if entry.aggr == nil || entry.aggr.fn != call.fn {
+		m.fail("map %s is already used with different value", bm.name)
+	}
+	entry.aggr.add(n)
+}
+
+func (m *machine) lookupMap(ref *mapRef) (*bpfMap, string, []value) {
+	bm, ok := m.maps[ref.name]
+	if !ok {
+		bm = &bpfMap{name: ref.name, entries: make(map[string]*mapEntry)}
+		m.maps[ref.name] = bm
+	}
+
+	keys := make([]value, 0, len(ref.keys))
+	for _, key := range ref.keys {
+		keys = append(keys, m.rvalue(m.eval(key)))
+	}
+	return bm, keyString(keys), keys
+}
+
+func (m *machine) isTrue(v value) bool {
+	if v.isStr {
+		return v.s != ""
+	}
+	return v.n != 0
+}
+
+func (m *machine) toInt(v value) int64 {
+	if v.isStr || v.isAddress() {
+		m.fail("integer is expected")
+	}
+	return v.n
+}
+
+// rvalue converts char arrays to strings, so they can be used as map keys
+// and values
+func (m *machine) rvalue(v value) value {
+	switch {
+	case v.isStr:
+		return v
+	case v.typ != nil && v.typ.isCharArray():
+		return strValue(m.mem.readString(uint64(v.n), v.typ.size))
+	case v.isAddress():
+		m.fail("%s cannot be used as a value", v.typ)
+	}
+	return value{n: v.n}
+}
+
+func (m *machine) toString(v value) string {
+	v = m.rvalue(v)
+	if !v.isStr {
+		return strconv.FormatInt(v.n, 10)
+	}
+	return v.s
+}
+
+func (m *machine) load(addr int64, t *Type) value {
+	switch t.kind {
+	case kindInt:
+		return value{typ: t, n: m.mem.readInt(uint64(addr), t)}
+	case kindPointer:
+		return value{typ: t, n: m.mem.readInt(uint64(addr), intTypes["uint64"])}
+	}
+	return value{typ: t, n: addr}
+}
+
+func (m *machine) eval(x expr) value {
+	switch x := x.(type) {
+	case *intLit:
+		return intValue(x.n)
+	case *strLit:
+		return strValue(x.s)
+	case *varRef:
+		v, ok := m.vars[x.name]
+		if !ok {
+			m.fail("variable %s is used before assignment", x.name)
+		}
+		return v
+	case *mapRef:
+		bm, key, _ := m.lookupMap(x)
+		if entry, ok := bm.entries[key]; ok {
+			return entry.value()
+		}
+		return intValue(0)
+	case *builtinRef:
+		return m.evalBuiltin(x.name)
+	case *fieldExpr:
+		return m.evalField(x)
+	case *indexExpr:
+		base := m.eval(x.x)
+		index := m.toInt(m.eval(x.index))
+		if base.typ == nil || (base.typ.kind != kindArray && base.typ.kind != kindPointer) {
+			m.fail("only arrays and pointers can be indexed")
+		}
+		elem := base.typ.elem
+		return m.load(base.n+index*int64(elem.size), elem)
+	case *castExpr:
+		v := m.eval(x.x)
+		switch x.typ.kind {
+		case kindInt:
+			return value{typ: x.typ, n: truncateInt(m.toInt(v), x.typ)}
+		case kindPointer:
+			return value{typ: x.typ, n: m.toInt(v)}
+		}
+		m.fail("cannot cast to %s", x.typ)
+	case *unaryExpr:
+		return m.evalUnary(x)
+	case *binaryExpr:
+		switch x.op {
+		case "&&":
+			return boolValue(m.isTrue(m.eval(x.l)) && m.isTrue(m.eval(x.r)))
+		case "||":
+			return boolValue(m.isTrue(m.eval(x.l)) || m.isTrue(m.eval(x.r)))
+		}
+		return m.binaryOp(x.op, m.eval(x.l), m.eval(x.r))
+	case *condExpr:
+		if m.isTrue(m.eval(x.cond)) {
+			return m.eval(x.then)
+		}
+		return m.eval(x.els)
+	case *callExpr:
+		return m.evalCall(x)
+	}
+
+	m.fail("unsupported expression %T", x)
+	return value{}
+}
+
+func (m *machine) evalBuiltin(name string) value {
+	if strings.HasPrefix(name, "arg") || name == "retval" {
+		v, ok := m.args[name]
+		if !ok {
+			m.fail("probe doesn't have %s", name)
+		}
+		return v
+	}
+
+	ev := m.event
+	switch name {
+	case "nsecs":
+		return intValue(m.now.UnixNano())
+	case "elapsed":
+		return intValue(m.now.Sub(m.start).Nanoseconds())
+	case "pid":
+		return intValue(int64(ev.Pid))
+	case "tid":
+		if ev.Tid == 0 {
+			return intValue(int64(ev.Pid))
+		}
+		return intValue(int64(ev.Tid))
+	case "cpu":
+		return intValue(int64(ev.CPU))
+	case "comm":
+		return strValue(ev.Comm)
+	case "cgroup":
+		return intValue(int64(ev.Cgroup))
+	case "kstack", "ustack":
+		return strValue("")
+	}
+
+	m.fail("unsupported builtin '%s'", name)
+	return value{}
+}
+
+func (m *machine) evalField(x *fieldExpr) value {
+	if ref, ok := x.x.(*builtinRef); ok && ref.name == "args" {
+		v, ok := m.args[x.name]
+		if !ok {
+			m.fail("tracepoint doesn't have argument %s", x.name)
+		}
+		return v
+	}
+
+	base := m.eval(x.x)
+	st := base.typ
+	if x.arrow {
+		if st == nil || st.kind != kindPointer {
+			m.fail("-> is used for a value which is not a pointer")
+		}
+		st = st.elem
+	}
+	if st == nil || st.kind != kindStruct {
+		m.fail("field %s is accessed in a value which is not a struct", x.name)
+	}
+	if st.fields == nil {
+		m.fail("struct %s is not defined", st.name)
+	}
+
+	offset, typ, ok := st.findField(x.name)
+	if !ok {
+		m.fail("struct %s doesn't have field %s", st.name, x.name)
+	}
+	return m.load(base.n+int64(offset), typ)
+}
+
+func (m *machine) evalUnary(x *unaryExpr) value {
+	v := m.eval(x.x)
+	switch x.op {
+	case "!":
+		return boolValue(!m.isTrue(v))
+	case "~":
+		return intValue(^m.toInt(v))
+	case "-":
+		return intValue(-m.toInt(v))
+	case "*":
+		if v.typ == nil || v.typ.kind != kindPointer {
+			m.fail("dereferenced value is not a pointer")
+		}
+		return m.load(v.n, v.typ.elem)
+	}
+
+	m.fail("unsupported operator '%s'", x.op)
+	return value{}
+}
+
+func (m *machine) binaryOp(op string, l, r value) value {
+	if l.isStr || r.isStr || (l.typ != nil && l.typ.isCharArray()) {
+		ls, rs := m.rvalue(l), m.rvalue(r)
+		if !ls.isStr || !rs.isStr {
+			m.fail("string is compared with integer")
+		}
+		switch op {
+		case "==":
+			return boolValue(ls.s == rs.s)
+		case "!=":
+			return boolValue(ls.s != rs.s)
+		}
+		m.fail("operator '%s' is not supported for strings", op)
+	}
+
+	a, b := m.toPointerInt(l), m.toPointerInt(r)
+	switch op {
+	case "+":
+		return intValue(a + b)
+	case "-":
+		return intValue(a - b)
+	case "*":
+		return intValue(a * b)
+	case "/":
+		if b == 0 {
+			return intValue(0)
+		}
+		return intValue(a / b)
+	case "%":
+		if b == 0 {
+			return intValue(0)
+		}
+		return intValue(a % b)
+	case "&":
+		return intValue(a & b)
+	case "|":
+		return intValue(a | b)
+	case "^":
+		return intValue(a ^ b)
+	case "<<":
+		return intValue(a << uint64(b))
+	case ">>":
+		return intValue(int64(uint64(a) >> uint64(b)))
+	case "==":
+		return boolValue(a == b)
+	case "!=":
+		return boolValue(a != b)
+	case "<":
+		return boolValue(a < b)
+	case ">":
+		return boolValue(a > b)
+	case "<=":
+		return boolValue(a <= b)
+	case ">=":
+		return boolValue(a >= b)
+	}
+
+	m.fail("unsupported operator '%s'", op)
+	return value{}
+}
+
+// toPointerInt allows arithmetics over pointers and arrays which are treated
+// as their addresses as bpftrace does
+func (m *machine) toPointerInt(v value) int64 {
+	if v.isStr {
+		m.fail("integer is expected")
+	}
+	return v.n
+}
+
+func (m *machine) evalCall(x *callExpr) value {
+	switch x.fn {
+	case "printf":
+		if len(x.args) == 0 {
+			m.fail("printf() expects format string")
+		}
+		format, ok := x.args[0].(*strLit)
+		if !ok {
+			m.fail("format of printf() should be a string literal")
+		}
+		args := make([]value, 0, len(x.args)-1)
+		for _, arg := range x.args[1:] {
+			args = append(args, m.eval(arg))
+		}
+		io.WriteString(m.w, m.sprintf(format.s, args))
+	case "time":
+		format := "%H:%M:%S\n"
+		if len(x.args) > 0 {
+			lit, ok := x.args[0].(*strLit)
+			if !ok {
+				m.fail("format of time() should be a string literal")
+			}
+			format = lit.s
+		}
+		io.WriteString(m.w, strftime(format, m.now))
+	case "print":
+		if len(x.args) == 0 {
+			m.fail("print() expects map")
+		}
+		ref, ok := x.args[0].(*mapRef)
+		if !ok || len(ref.keys) > 0 {
+			v := m.eval(x.args[0])
+			fmt.Fprintln(m.w, m.toString(v))
+			break
+		}
+		top := 0
+		if len(x.args) > 1 {
+			top = int(m.toInt(m.eval(x.args[1])))
+		}
+		if bm, ok := m.maps[ref.name]; ok {
+			bm.print(m.w, top)
+		}
+	case "clear", "zero":
+		ref := m.mapArg(x)
+		if bm, ok := m.maps[ref.name]; ok {
+			bm.entries = make(map[string]*mapEntry)
+		}
+	case "delete":
+		ref := m.mapArg(x)
+		bm, key, _ := m.lookupMap(ref)
+		delete(bm.entries, key)
+	case "exit":
+		m.exited = true
+	case "ntop":
+		return m.evalNtop(x)
+	case "bswap":
+		if len(x.args) != 1 {
+			m.fail("bswap() expects single argument")
+		}
+		return m.evalBswap(m.eval(x.args[0]))
+	case "str":
+		if len(x.args) == 0 {
+			m.fail("str() expects argument")
+		}
+		s := m.toString(m.eval(x.args[0]))
+		if len(x.args) > 1 {
+			n := int(m.toInt(m.eval(x.args[1])))
+			if n < len(s) {
+				s = s[:n]
+			}
+		}
+		return strValue(s)
+	default:
+		if aggregationFuncs[x.fn] {
+			m.fail("%s() should be assigned to map", x.fn)
+		}
+		m.fail("unsupported function %s()", x.fn)
+	}
+	return intValue(0)
+}
+
+func (m *machine) mapArg(x *callExpr) *mapRef {
+	if len(x.args) != 1 {
+		m.fail("%s() expects single argument", x.fn)
+	}
+	ref, ok := x.args[0].(*mapRef)
+	if !ok {
+		m.fail("%s() expects map", x.fn)
+	}
+	return ref
+}
+
+func (m *machine) evalNtop(x *callExpr) value {
+	if len(x.args) < 1 || len(x.args) > 2 {
+		m.fail("ntop() expects address and optional address family")
+	}
+
+	v := m.eval(x.args[len(x.args)-1])
+	var addr []byte
+	if v.typ != nil && v.typ.kind == kindArray {
+		addr = m.mem.read(uint64(v.n), v.typ.size)
+	} else {
+		addr = make([]byte, 8)
+		byteOrder.PutUint64(addr, uint64(m.toInt(v)))
+		addr = addr[:4]
+		if v.typ != nil && v.typ.size > 4 {
+			addr = addr[:v.typ.size]
+		}
+	}
+
+	if len(x.args) == 2 {
+		switch af := m.toInt(m.eval(x.args[0])); af {
+		case 2:
+			addr = addr[:4]
+		case 10:
+			if len(addr) < 16 {
+				m.fail("address is too short for AF_INET6")
+			}
+			addr = addr[:16]
+		default:
+			m.fail("unsupported address family %d", af)
+		}
+	}
+	if len(addr) != 4 && len(addr) != 16 {
+		m.fail("unsupported address length %d", len(addr))
+	}
+	return strValue(net.IP(addr).String())
+}
+
+func (m *machine) evalBswap(v value) value {
+	n := m.toInt(v)
+	size := 8
+	if v.typ != nil {
+		size = v.typ.size
+	}
+
+	switch size {
+	case 1:
+		return v
+	case 2:
+		return value{typ: v.typ, n: int64(bits.ReverseBytes16(uint16(n)))}
+	case 4:
+		return value{typ: v.typ, n: int64(bits.ReverseBytes32(uint32(n)))}
+	}
+	return value{typ: v.typ, n: int64(bits.ReverseBytes64(uint64(n)))}
+}
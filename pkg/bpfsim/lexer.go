@@ -0,0 +1,175 @@
+package bpfsim
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokVar
+	tokMap
+	tokInt
+	tokString
+	tokPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	n    int64
+	line int
+}
+
+func (tok token) is(punct string) bool {
+	return tok.kind == tokPunct && tok.text == punct
+}
+
+func (tok token) String() string {
+	switch tok.kind {
+	case tokEOF:
+		return "end of script"
+	case tokString:
+		return strconv.Quote(tok.text)
+	}
+	return fmt.Sprintf("'%s'", tok.text)
+}
+
+// Operators are ordered so longer ones are matched first
+var punctuators = []string{
+	"<<=", ">>=",
+	"->", "++", "--", "+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=",
+	"<<", ">>", "<=", ">=", "==", "!=", "&&", "||",
+	"{", "}", "(", ")", "[", "]", ";", ",", ".", "?", ":", "=",
+	"+", "-", "*", "/", "%", "&", "|", "^", "~", "!", "<", ">",
+}
+
+func isIdentByte(c byte, first bool) bool {
+	switch {
+	case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	}
+	return false
+}
+
+// tokenize splits script into tokens skipping comments and preprocessor
+// directives such as #include as kernel headers are not used by simulator
+func tokenize(script string) ([]token, error) {
+	var tokens []token
+	line := 1
+	for i := 0; i < len(script); {
+		c := script[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#' || strings.HasPrefix(script[i:], "//"):
+			for i < len(script) && script[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				return nil, newSyntaxError(line, "unterminated comment")
+			}
+			line += strings.Count(script[i:i+end+4], "\n")
+			i += end + 4
+		case c == '"':
+			s, n, err := unquote(script[i:])
+			if err != nil {
+				return nil, newSyntaxError(line, err.Error())
+			}
+			tokens = append(tokens, token{kind: tokString, text: s, line: line})
+			i += n
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(script) && isIdentByte(script[i], false) {
+				i++
+			}
+			n, err := strconv.ParseInt(script[start:i], 0, 64)
+			if err != nil {
+				u, uerr := strconv.ParseUint(script[start:i], 0, 64)
+				if uerr != nil {
+					return nil, newSyntaxError(line, "invalid number '%s'", script[start:i])
+				}
+				n = int64(u)
+			}
+			tokens = append(tokens, token{kind: tokInt, text: script[start:i], n: n, line: line})
+		case c == '$' || c == '@' || isIdentByte(c, true):
+			kind := tokIdent
+			start := i
+			switch c {
+			case '$':
+				kind = tokVar
+				i++
+			case '@':
+				kind = tokMap
+				i++
+			}
+			for i < len(script) && isIdentByte(script[i], i == start+1 && kind == tokVar) {
+				i++
+			}
+			if kind == tokVar && i == start+1 {
+				return nil, newSyntaxError(line, "invalid variable name")
+			}
+			tokens = append(tokens, token{kind: kind, text: script[start:i], line: line})
+		default:
+			matched := false
+			for _, punct := range punctuators {
+				if strings.HasPrefix(script[i:], punct) {
+					tokens = append(tokens, token{kind: tokPunct, text: punct, line: line})
+					i += len(punct)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, newSyntaxError(line, "unexpected character '%c'", c)
+			}
+		}
+	}
+
+	return append(tokens, token{kind: tokEOF, line: line}), nil
+}
+
+// unquote parses string literal at the beginning of s and returns its value
+// and length of the literal
+func unquote(s string) (string, int, error) {
+	var buf strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return buf.String(), i + 1, nil
+		case '\n':
+			return "", 0, fmt.Errorf("unterminated string")
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", 0, fmt.Errorf("unterminated string")
+			}
+			switch s[i] {
+			case 'n':
+				buf.WriteByte('\n')
+			case 't':
+				buf.WriteByte('\t')
+			case 'r':
+				buf.WriteByte('\r')
+			case '0':
+				buf.WriteByte(0)
+			default:
+				buf.WriteByte(s[i])
+			}
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
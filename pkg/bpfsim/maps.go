@@ -0,0 +1,173 @@
+package bpfsim
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+const histBarWidth = 52
+
+// aggregation keeps state of the aggregation function such as count() or
+// hist() assigned to the map entry
+type aggregation struct {
+	fn                   string
+	count, sum, min, max int64
+	buckets              map[int]int64
+	minBucket, maxBucket int
+}
+
+var aggregationFuncs = map[string]bool{
+	"count": true, "sum": true, "avg": true, "min": true, "max": true, "hist": true,
+}
+
+func (aggr *aggregation) add(n int64) {
+	if aggr.count == 0 || n < aggr.min {
+		aggr.min = n
+	}
+	if aggr.count == 0 || n > aggr.max {
+		aggr.max = n
+	}
+	aggr.count++
+	aggr.sum += n
+
+	if aggr.fn == "hist" {
+		bucket := histBucket(n)
+		if len(aggr.buckets) == 0 || bucket < aggr.minBucket {
+			aggr.minBucket = bucket
+		}
+		if len(aggr.buckets) == 0 || bucket > aggr.maxBucket {
+			aggr.maxBucket = bucket
+		}
+		aggr.buckets[bucket]++
+	}
+}
+
+func (aggr *aggregation) value() int64 {
+	switch aggr.fn {
+	case "sum":
+		return aggr.sum
+	case "min":
+		return aggr.min
+	case "max":
+		return aggr.max
+	case "avg":
+		return aggr.sum / aggr.count
+	}
+	return aggr.count
+}
+
+// histBucket returns index of power-of-2 bucket: -1 for negative values,
+// 0 for zero and n for values in [2^(n-1), 2^n)
+func histBucket(n int64) int {
+	if n < 0 {
+		return -1
+	}
+	return bits.Len64(uint64(n))
+}
+
+func histBucketLabel(bucket int) string {
+	switch bucket {
+	case -1:
+		return "(..., 0)"
+	case 0:
+		return "[0]"
+	case 1:
+		return "[1]"
+	}
+	return fmt.Sprintf("[%s, %s)", histBound(bucket-1), histBound(bucket))
+}
+
+// histBound formats power of 2 using suffixes for powers of 1024
+func histBound(power int) string {
+	suffixes := []string{"", "K", "M", "G", "T", "P", "E"}
+	unit := power / 10
+	return fmt.Sprintf("%d%s", 1<<(power-unit*10), suffixes[unit])
+}
+
+type mapEntry struct {
+	keys []value
+	val  value
+	aggr *aggregation
+}
+
+func (entry *mapEntry) value() value {
+	if entry.aggr != nil {
+		return intValue(entry.aggr.value())
+	}
+	return entry.val
+}
+
+type bpfMap struct {
+	name    string
+	entries map[string]*mapEntry
+}
+
+func (m *bpfMap) sortedEntries() []*mapEntry {
+	entries := make([]*mapEntry, 0, len(m.entries))
+	for _, entry := range m.entries {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		vi, vj := entries[i].value(), entries[j].value()
+		if !vi.isStr && !vj.isStr && vi.n != vj.n {
+			return vi.n < vj.n
+		}
+		return keyString(entries[i].keys) < keyString(entries[j].keys)
+	})
+	return entries
+}
+
+// print prints entries of the map as bpftrace does sorting them by value,
+// so top entries are printed last
+func (m *bpfMap) print(w io.Writer, top int) {
+	if len(m.entries) == 0 {
+		return
+	}
+
+	entries := m.sortedEntries()
+	if top > 0 && len(entries) > top {
+		entries = entries[len(entries)-top:]
+	}
+
+	for _, entry := range entries {
+		name := m.name
+		if len(entry.keys) > 0 {
+			keys := make([]string, 0, len(entry.keys))
+			for _, key := range entry.keys {
+				keys = append(keys, key.String())
+			}
+			name = fmt.Sprintf("%s[%s]", name, strings.Join(keys, ", "))
+		}
+
+		if entry.aggr != nil && entry.aggr.fn == "hist" {
+			fmt.Fprintf(w, "%s:\n", name)
+			printHist(w, entry.aggr)
+			fmt.Fprintln(w)
+			continue
+		}
+		fmt.Fprintf(w, "%s: %s\n", name, entry.value())
+	}
+
+	if entries[0].aggr == nil || entries[0].aggr.fn != "hist" {
+		fmt.Fprintln(w)
+	}
+}
+
+func printHist(w io.Writer, aggr *aggregation) {
+	var maxCount int64
+	for _, count := range aggr.buckets {
+		if count > maxCount {
+			maxCount = count
+		}
+	}
+
+	for bucket := aggr.minBucket; bucket <= aggr.maxBucket; bucket++ {
+		count := aggr.buckets[bucket]
+		bar := strings.Repeat("@", int(count*histBarWidth/maxCount))
+		fmt.Fprintf(w, "%-16s%8d |%-*s|\n", histBucketLabel(bucket), count, histBarWidth, bar)
+	}
+}
@@ -0,0 +1,126 @@
+package bpfsim
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// Simulated kernel is little-endian x86_64 regardless of the host, so byte
+// order errors in scripts are revealed on any host
+var byteOrder = binary.LittleEndian
+
+const (
+	memoryBase = 0x100000
+
+	// Regions are separated by gaps, so reading beyond the region reads
+	// zeroes instead of the neighbour region
+	memoryAlign = 0x1000
+)
+
+type region struct {
+	addr uint64
+	data []byte
+}
+
+// memory is a simulated kernel memory which contains structures built for
+// the events. Reads of unmapped memory return zeroes as probe_read() does
+// on failure.
+type memory struct {
+	regions []*region
+	next    uint64
+}
+
+func newMemory() *memory {
+	return &memory{next: memoryBase}
+}
+
+// alloc maps zeroed region of the given size and returns its address
+func (mem *memory) alloc(size int) uint64 {
+	addr := mem.next
+	mem.regions = append(mem.regions, &region{addr: addr, data: make([]byte, size)})
+	mem.next += (uint64(size)/memoryAlign + 2) * memoryAlign
+	return addr
+}
+
+func (mem *memory) find(addr uint64) (*region, int) {
+	i := sort.Search(len(mem.regions), func(i int) bool {
+		r := mem.regions[i]
+		return r.addr+uint64(len(r.data)) > addr
+	})
+	if i == len(mem.regions) || mem.regions[i].addr > addr {
+		return nil, 0
+	}
+	return mem.regions[i], int(addr - mem.regions[i].addr)
+}
+
+func (mem *memory) read(addr uint64, size int) []byte {
+	buf := make([]byte, size)
+	for i := 0; i < size; {
+		r, off := mem.find(addr + uint64(i))
+		if r == nil {
+			i++
+			continue
+		}
+		i += copy(buf[i:], r.data[off:])
+	}
+	return buf
+}
+
+func (mem *memory) write(addr uint64, data []byte) {
+	for i := 0; i < len(data); {
+		r, off := mem.find(addr + uint64(i))
+		if r == nil {
+			i++
+			continue
+		}
+		i += copy(r.data[off:], data[i:])
+	}
+}
+
+// readInt reads integer of the given type sign-extending signed values
+func (mem *memory) readInt(addr uint64, t *Type) int64 {
+	buf := mem.read(addr, t.size)
+	var u uint64
+	switch t.size {
+	case 1:
+		u = uint64(buf[0])
+	case 2:
+		u = uint64(byteOrder.Uint16(buf))
+	case 4:
+		u = uint64(byteOrder.Uint32(buf))
+	default:
+		u = byteOrder.Uint64(buf)
+	}
+	return truncateInt(int64(u), t)
+}
+
+func (mem *memory) writeInt(addr uint64, t *Type, n int64) {
+	buf := make([]byte, 8)
+	byteOrder.PutUint64(buf, uint64(n))
+	mem.write(addr, buf[:t.size])
+}
+
+// readString reads zero-terminated string limited by size
+func (mem *memory) readString(addr uint64, size int) string {
+	buf := mem.read(addr, size)
+	for i, c := range buf {
+		if c == 0 {
+			return string(buf[:i])
+		}
+	}
+	return string(buf)
+}
+
+// truncateInt converts integer to the integer type
+func truncateInt(n int64, t *Type) int64 {
+	if t == nil || t.size >= 8 {
+		return n
+	}
+
+	bits := uint(t.size * 8)
+	u := uint64(n) & (1<<bits - 1)
+	if t.signed && u&(1<<(bits-1)) != 0 {
+		return int64(u | ^uint64(0)<<bits)
+	}
+	return int64(u)
+}
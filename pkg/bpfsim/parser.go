@@ -0,0 +1,589 @@
+package bpfsim
+
+import (
+	"strings"
+)
+
+// probe is a block of statements attached to one or more attach points
+type probe struct {
+	attachPoints []string
+	body         []stmt
+}
+
+type script struct {
+	probes []*probe
+	types  typeRegistry
+}
+
+type stmt interface{}
+
+type exprStmt struct {
+	line int
+	x    expr
+}
+
+type assignStmt struct {
+	line   int
+	target expr
+	op     string
+	value  expr
+}
+
+type ifStmt struct {
+	line      int
+	cond      expr
+	then, els []stmt
+}
+
+type expr interface{}
+
+type (
+	intLit struct {
+		n int64
+	}
+	strLit struct {
+		s string
+	}
+	varRef struct {
+		name string
+	}
+	mapRef struct {
+		name string
+		keys []expr
+	}
+	builtinRef struct {
+		name string
+	}
+	fieldExpr struct {
+		x     expr
+		name  string
+		arrow bool
+	}
+	indexExpr struct {
+		x, index expr
+	}
+	callExpr struct {
+		fn   string
+		args []expr
+	}
+	castExpr struct {
+		typ *Type
+		x   expr
+	}
+	unaryExpr struct {
+		op string
+		x  expr
+	}
+	binaryExpr struct {
+		op   string
+		l, r expr
+	}
+	condExpr struct {
+		cond, then, els expr
+	}
+)
+
+// Binary operators by precedence from lowest to highest as in C
+var binaryOps = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", ">", "<=", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+var assignOps = []string{"=", "+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "<<=", ">>="}
+
+type parser struct {
+	tokens []token
+	pos    int
+	types  typeRegistry
+}
+
+// parse parses script along with structure definitions. Kernel structures
+// of the simulator are parsed first, so script may use them without
+// defining them.
+func parse(text string) (*script, error) {
+	types := make(typeRegistry)
+	if _, err := parseWithTypes(kernelStructs, types); err != nil {
+		return nil, err
+	}
+	return parseWithTypes(text, types)
+}
+
+func parseWithTypes(text string, types typeRegistry) (s *script, err error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, types: types}
+	defer func() {
+		if r := recover(); r != nil {
+			scriptErr, ok := r.(*ScriptError)
+			if !ok {
+				panic(r)
+			}
+			err = scriptErr
+		}
+	}()
+
+	s = &script{types: types}
+	for p.peek().kind != tokEOF {
+		if p.peek().text == "struct" && p.peekAt(2).is("{") {
+			p.parseStructDef()
+			continue
+		}
+		s.probes = append(s.probes, p.parseProbe())
+	}
+	return s, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) peekAt(offset int) token {
+	if p.pos+offset >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) fail(format string, args ...interface{}) {
+	panic(newSyntaxError(p.peek().line, format, args...))
+}
+
+func (p *parser) expect(punct string) token {
+	tok := p.next()
+	if !tok.is(punct) {
+		p.pos--
+		p.fail("expected '%s', got %s", punct, tok)
+	}
+	return tok
+}
+
+func (p *parser) accept(punct string) bool {
+	if p.peek().is(punct) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectIdent() string {
+	tok := p.next()
+	if tok.kind != tokIdent {
+		p.pos--
+		p.fail("expected identifier, got %s", tok)
+	}
+	return tok.text
+}
+
+// parseProbe parses attach points such as 'kprobe:dev_queue_xmit' separated
+// by commas and the block of the probe
+func (p *parser) parseProbe() *probe {
+	pr := &probe{}
+	var attachPoint strings.Builder
+	for {
+		tok := p.next()
+		switch {
+		case tok.kind == tokEOF:
+			p.fail("unexpected end of script in probe definition")
+		case tok.is("{") || tok.is(","):
+			if attachPoint.Len() == 0 {
+				p.pos--
+				p.fail("expected attach point, got %s", tok)
+			}
+			pr.attachPoints = append(pr.attachPoints, attachPoint.String())
+			attachPoint.Reset()
+		default:
+			attachPoint.WriteString(tok.text)
+		}
+
+		if tok.is("{") {
+			break
+		}
+	}
+
+	pr.body = p.parseBlockBody()
+	return pr
+}
+
+// parseBlockBody parses statements until closing brace. Opening brace
+// should be already consumed
+func (p *parser) parseBlockBody() []stmt {
+	var stmts []stmt
+	for !p.accept("}") {
+		if p.peek().kind == tokEOF {
+			p.fail("unexpected end of script, expected '}'")
+		}
+		if p.accept(";") {
+			continue
+		}
+		stmts = append(stmts, p.parseStmt())
+	}
+	return stmts
+}
+
+func (p *parser) parseStmt() stmt {
+	line := p.peek().line
+	if p.peek().kind == tokIdent && p.peek().text == "if" {
+		p.next()
+		p.expect("(")
+		st := &ifStmt{line: line, cond: p.parseExpr()}
+		p.expect(")")
+		p.expect("{")
+		st.then = p.parseBlockBody()
+		if p.peek().kind == tokIdent && p.peek().text == "else" {
+			p.next()
+			if p.peek().text == "if" {
+				st.els = []stmt{p.parseStmt()}
+			} else {
+				p.expect("{")
+				st.els = p.parseBlockBody()
+			}
+		}
+		return st
+	}
+
+	x := p.parseExpr()
+	switch {
+	case p.accept("++"):
+		return &assignStmt{line: line, target: x, op: "+=", value: &intLit{n: 1}}
+	case p.accept("--"):
+		return &assignStmt{line: line, target: x, op: "-=", value: &intLit{n: 1}}
+	}
+	for _, op := range assignOps {
+		if p.accept(op) {
+			switch x.(type) {
+			case *varRef, *mapRef:
+			default:
+				p.fail("only variables and maps can be assigned")
+			}
+			st := &assignStmt{line: line, target: x, op: op, value: p.parseExpr()}
+			p.endStmt()
+			return st
+		}
+	}
+
+	p.endStmt()
+	return &exprStmt{line: line, x: x}
+}
+
+func (p *parser) endStmt() {
+	if !p.peek().is("}") {
+		p.expect(";")
+	}
+}
+
+func (p *parser) parseExpr() expr {
+	cond := p.parseBinary(0)
+	if !p.accept("?") {
+		return cond
+	}
+
+	then := p.parseExpr()
+	p.expect(":")
+	return &condExpr{cond: cond, then: then, els: p.parseExpr()}
+}
+
+func (p *parser) parseBinary(level int) expr {
+	if level == len(binaryOps) {
+		return p.parseUnary()
+	}
+
+	l := p.parseBinary(level + 1)
+	for {
+		tok := p.peek()
+		matched := false
+		for _, op := range binaryOps[level] {
+			if tok.is(op) {
+				p.next()
+				l = &binaryExpr{op: op, l: l, r: p.parseBinary(level + 1)}
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return l
+		}
+	}
+}
+
+func (p *parser) parseUnary() expr {
+	for _, op := range []string{"!", "~", "-", "*", "&"} {
+		if p.accept(op) {
+			return &unaryExpr{op: op, x: p.parseUnary()}
+		}
+	}
+
+	if p.peek().is("(") {
+		if typ, n := p.tryParseCastType(); typ != nil {
+			p.pos += n
+			return &castExpr{typ: typ, x: p.parseUnary()}
+		}
+	}
+	return p.parsePostfix(p.parsePrimary())
+}
+
+// tryParseCastType checks if parenthesis contain type such as '(uint16)' or
+// '(struct sk_buff**)' and returns it along with the number of tokens.
+// Structures may be referred without struct keyword.
+func (p *parser) tryParseCastType() (*Type, int) {
+	n := 1
+	isStruct := false
+	if p.peekAt(n).text == "struct" {
+		isStruct = true
+		n++
+	}
+
+	var nameParts []string
+	for p.peekAt(n).kind == tokIdent {
+		nameParts = append(nameParts, p.peekAt(n).text)
+		n++
+	}
+	if len(nameParts) == 0 {
+		return nil, 0
+	}
+
+	name := strings.Join(nameParts, " ")
+	var typ *Type
+	if it, ok := intTypes[name]; ok && !isStruct {
+		typ = it
+	}
+
+	pointers := 0
+	for p.peekAt(n).is("*") {
+		pointers++
+		n++
+	}
+	if !p.peekAt(n).is(")") || (typ == nil && !isStruct && pointers == 0) {
+		return nil, 0
+	}
+
+	if typ == nil {
+		if len(nameParts) > 1 {
+			return nil, 0
+		}
+		typ = p.types.lookupStruct(name)
+	}
+	for i := 0; i < pointers; i++ {
+		typ = pointerTo(typ)
+	}
+	return typ, n + 1
+}
+
+func (p *parser) parsePrimary() expr {
+	tok := p.next()
+	switch tok.kind {
+	case tokInt:
+		return &intLit{n: tok.n}
+	case tokString:
+		return &strLit{s: tok.text}
+	case tokVar:
+		return &varRef{name: tok.text}
+	case tokMap:
+		ref := &mapRef{name: tok.text}
+		if p.accept("[") {
+			ref.keys = p.parseExprList("]")
+		}
+		return ref
+	case tokIdent:
+		if p.accept("(") {
+			return &callExpr{fn: tok.text, args: p.parseExprList(")")}
+		}
+		return &builtinRef{name: tok.text}
+	case tokPunct:
+		if tok.is("(") {
+			x := p.parseExpr()
+			p.expect(")")
+			return x
+		}
+	}
+
+	p.pos--
+	p.fail("unexpected %s", tok)
+	return nil
+}
+
+func (p *parser) parseExprList(closing string) []expr {
+	var exprs []expr
+	for !p.accept(closing) {
+		if len(exprs) > 0 {
+			p.expect(",")
+		}
+		exprs = append(exprs, p.parseExpr())
+	}
+	return exprs
+}
+
+func (p *parser) parsePostfix(x expr) expr {
+	for {
+		switch {
+		case p.accept("->"):
+			x = &fieldExpr{x: x, name: p.expectIdent(), arrow: true}
+		case p.accept("."):
+			x = &fieldExpr{x: x, name: p.expectIdent()}
+		case p.accept("["):
+			x = &indexExpr{x: x, index: p.parseExpr()}
+			p.expect("]")
+		default:
+			return x
+		}
+	}
+}
+
+// parseStructDef parses definition of the structure in the form of
+// 'struct name { fields }' optionally followed by semicolon
+func (p *parser) parseStructDef() {
+	p.next()
+	name := p.expectIdent()
+	t := p.types.lookupStruct(name)
+	if t.fields != nil {
+		p.fail("struct %s is redefined", name)
+	}
+
+	p.expect("{")
+	p.parseStructBody(t, false)
+	p.accept(";")
+}
+
+// parseStructBody parses fields of the structure or the union until
+// closing brace and attributes which follow it
+func (p *parser) parseStructBody(t *Type, isUnion bool) {
+	t.fields = []*structField{}
+	for !p.accept("}") {
+		baseType := p.parseFieldType()
+		if p.accept(";") {
+			// Anonymous structure or union
+			p.addField(t, isUnion, &structField{typ: baseType})
+			continue
+		}
+
+		for {
+			typ := baseType
+			for p.accept("*") {
+				typ = pointerTo(typ)
+			}
+
+			field := &structField{name: p.expectIdent(), typ: typ}
+			var dims []int
+			for p.accept("[") {
+				tok := p.next()
+				if tok.kind != tokInt {
+					p.pos--
+					p.fail("expected array length, got %s", tok)
+				}
+				dims = append(dims, int(tok.n))
+				p.expect("]")
+			}
+			for i := len(dims) - 1; i >= 0; i-- {
+				field.typ = &Type{kind: kindArray, elem: field.typ,
+					length: dims[i], size: dims[i] * field.typ.size}
+			}
+			if field.typ.kind == kindStruct && field.typ.fields == nil {
+				p.fail("field '%s' has incomplete type %s", field.name, field.typ)
+			}
+
+			p.addField(t, isUnion, field)
+			if !p.accept(",") {
+				break
+			}
+		}
+		p.expect(";")
+	}
+	p.skipAttributes()
+}
+
+func (p *parser) addField(t *Type, isUnion bool, field *structField) {
+	if isUnion {
+		if field.typ.size > t.size {
+			t.size = field.typ.size
+		}
+	} else {
+		field.offset = t.size
+		t.size += field.typ.size
+	}
+	t.fields = append(t.fields, field)
+}
+
+// parseFieldType parses type of the field which is either an integer type,
+// a reference to the structure or an inline structure or union
+func (p *parser) parseFieldType() *Type {
+	tok := p.peek()
+	if tok.kind != tokIdent {
+		p.fail("expected field type, got %s", tok)
+	}
+
+	if tok.text == "struct" || tok.text == "union" {
+		p.next()
+		isUnion := tok.text == "union"
+		name := ""
+		if p.peek().kind == tokIdent {
+			name = p.next().text
+		}
+		if !p.accept("{") {
+			if name == "" {
+				p.fail("expected struct name")
+			}
+			return p.types.lookupStruct(name)
+		}
+
+		t := &Type{kind: kindStruct, name: name}
+		p.parseStructBody(t, isUnion)
+		return t
+	}
+
+	name := p.next().text
+	if name == "unsigned" || name == "signed" {
+		switch p.peek().text {
+		case "char", "short", "int", "long":
+			name += " " + p.next().text
+		}
+	}
+	for name == "long" && p.peek().text == "long" || name == "unsigned long" && p.peek().text == "long" {
+		p.next()
+	}
+
+	typ, ok := intTypes[name]
+	if !ok {
+		p.pos--
+		p.fail("unknown type '%s'", name)
+	}
+	return typ
+}
+
+func (p *parser) skipAttributes() {
+	for p.peek().text == "__attribute__" {
+		p.next()
+		depth := 0
+		for {
+			tok := p.next()
+			switch {
+			case tok.kind == tokEOF:
+				p.fail("unterminated attribute")
+			case tok.is("("):
+				depth++
+			case tok.is(")"):
+				depth--
+			}
+			if depth == 0 {
+				break
+			}
+		}
+	}
+}
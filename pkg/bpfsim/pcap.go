@@ -0,0 +1,113 @@
+package bpfsim
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	pcapMagicMicro = 0xa1b2c3d4
+	pcapMagicNano  = 0xa1b23c4d
+
+	pcapHeaderLength       = 24
+	pcapRecordHeaderLength = 16
+
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+
+	// Values of DLT_RAW which are used by some platforms in place of
+	// LINKTYPE_RAW
+	linkTypeRawAlt1 = 12
+	linkTypeRawAlt2 = 14
+)
+
+// Packet is a packet captured by pcap starting from Ethernet header
+type Packet struct {
+	Time time.Time
+	Data []byte
+}
+
+// ReadPcapFile reads packets from the file in classic pcap format
+func ReadPcapFile(path string) ([]*Packet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	packets, err := ReadPcap(f)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read '%s': %w", path, err)
+	}
+	return packets, nil
+}
+
+// ReadPcap reads packets in classic pcap format. Ethernet and raw IP link
+// types are supported, Ethernet header is added to raw IP packets.
+func ReadPcap(r io.Reader) ([]*Packet, error) {
+	header := make([]byte, pcapHeaderLength)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("cannot read pcap header: %w", err)
+	}
+
+	var order binary.ByteOrder
+	var fracUnit time.Duration
+	for _, bo := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch bo.Uint32(header) {
+		case pcapMagicMicro:
+			order, fracUnit = bo, time.Microsecond
+		case pcapMagicNano:
+			order, fracUnit = bo, time.Nanosecond
+		}
+	}
+	if order == nil {
+		return nil, errors.New("not a pcap file")
+	}
+
+	linkType := order.Uint32(header[20:])
+	switch linkType {
+	case linkTypeEthernet, linkTypeRaw, linkTypeRawAlt1, linkTypeRawAlt2:
+	default:
+		return nil, fmt.Errorf("unsupported link type %d", linkType)
+	}
+
+	var packets []*Packet
+	recordHeader := make([]byte, pcapRecordHeaderLength)
+	for {
+		if _, err := io.ReadFull(r, recordHeader); err != nil {
+			if err == io.EOF {
+				return packets, nil
+			}
+			return nil, fmt.Errorf("cannot read packet %d: %w", len(packets)+1, err)
+		}
+
+		data := make([]byte, order.Uint32(recordHeader[8:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("cannot read packet %d: %w", len(packets)+1, err)
+		}
+		if linkType != linkTypeEthernet {
+			data = addEthernetHeader(data)
+		}
+
+		packets = append(packets, &Packet{
+			Time: time.Unix(int64(order.Uint32(recordHeader)), 0).Add(
+				time.Duration(order.Uint32(recordHeader[4:])) * fracUnit).UTC(),
+			Data: data,
+		})
+	}
+}
+
+func addEthernetHeader(data []byte) []byte {
+	proto := uint16(ethPIPv4)
+	if len(data) > 0 && data[0]>>4 == 6 {
+		proto = ethPIPv6
+	}
+
+	frame := make([]byte, ethHeaderLength, ethHeaderLength+len(data))
+	binary.BigEndian.PutUint16(frame[12:], proto)
+	return append(frame, data...)
+}
@@ -0,0 +1,352 @@
+// Package bpfsim interprets the subset of bpftrace language which is used
+// by skbtrace programs, so generated scripts can be tested against packets
+// without running bpftrace. Packets are placed into synthetic sk_buff
+// structures which are passed to probes as their arguments.
+package bpfsim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Device is a network device which sk_buff refers to
+type Device struct {
+	Name    string
+	Ifindex int
+	MTU     int
+
+	// Inode number of the network namespace of the device
+	Netns uint32
+}
+
+// Inode number of the initial network namespace
+const InitNetns = 0xf0000000
+
+// DefaultDevice is used by events which don't specify device
+var DefaultDevice = &Device{Name: "eth0", Ifindex: 2, MTU: 1500, Netns: InitNetns}
+
+// ArgKind defines how value of the probe argument is produced
+type ArgKind int
+
+const (
+	// ArgInt passes integer value
+	ArgInt ArgKind = iota
+
+	// ArgSkb passes pointer to sk_buff built from the packet of the event
+	ArgSkb
+
+	// ArgSkbRef passes pointer to pointer to sk_buff, i.e. for
+	// __netif_receive_skb_core() in newer kernels
+	ArgSkbRef
+)
+
+// Arg is an argument of the probe such as arg0 or tracepoint field
+type Arg struct {
+	Kind  ArgKind
+	Value int64
+}
+
+// Shortcuts for the arguments which refer to the packet of the event
+var (
+	SkbArg    = Arg{Kind: ArgSkb}
+	SkbRefArg = Arg{Kind: ArgSkbRef}
+)
+
+// IntArg creates integer argument
+func IntArg(n int64) Arg {
+	return Arg{Kind: ArgInt, Value: n}
+}
+
+// Event fires probe at the specified time
+type Event struct {
+	Time time.Time
+
+	// Probe is an attach point in the full form such as
+	// 'kprobe:dev_queue_xmit' or 'tracepoint:net:net_dev_xmit'
+	Probe string
+
+	// Args maps names of arguments such as 'arg0', 'retval' or tracepoint
+	// fields to their values
+	Args map[string]Arg
+
+	// Packet is placed into sk_buff which is passed to skb arguments.
+	// Events which share packet share sk_buff too.
+	Packet *Packet
+	Device *Device
+
+	Pid, Tid int
+	CPU      int
+	Comm     string
+	Cgroup   uint64
+}
+
+// PacketEvents creates event for each packet which fires probe with the
+// specified arguments at the time packet was captured with extra delay
+func PacketEvents(probe string, args map[string]Arg, delay time.Duration, packets []*Packet) []Event {
+	events := make([]Event, 0, len(packets))
+	for _, pkt := range packets {
+		events = append(events, Event{
+			Time:   pkt.Time.Add(delay),
+			Probe:  probe,
+			Args:   args,
+			Packet: pkt,
+		})
+	}
+	return events
+}
+
+// Simulator runs script for the events in order of their time. Interval
+// probes are fired between events, so simulated time is not related to
+// real time, and simulation ends after the last event unless Duration is
+// set. On exit, END probes are run and maps are printed as bpftrace does.
+type Simulator struct {
+	Events []Event
+
+	// Start is a time of starting script. If it is not set, time of the
+	// first event is used
+	Start time.Time
+
+	// Duration of the simulation after the start
+	Duration time.Duration
+}
+
+type intervalProbe struct {
+	probe  *probe
+	period time.Duration
+	next   time.Time
+}
+
+// RunScript parses script and runs it printing output to w
+func (sim *Simulator) RunScript(r io.Reader, w io.Writer) error {
+	text, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s, err := parse(string(text))
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	run := &simulation{
+		machine: &machine{
+			script: s,
+			mem:    newMemory(),
+			maps:   make(map[string]*bpfMap),
+			w:      out,
+		},
+		skbs:       make(map[*Packet]uint64),
+		devices:    make(map[*Device]uint64),
+		namespaces: make(map[uint32]uint64),
+	}
+	return run.run(sim)
+}
+
+type simulation struct {
+	*machine
+
+	probes    map[string][]*probe
+	intervals []*intervalProbe
+
+	skbs       map[*Packet]uint64
+	devices    map[*Device]uint64
+	namespaces map[uint32]uint64
+}
+
+func (run *simulation) run(sim *Simulator) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			scriptErr, ok := r.(*ScriptError)
+			if !ok {
+				panic(r)
+			}
+			err = scriptErr
+		}
+	}()
+
+	events := make([]Event, len(sim.Events))
+	copy(events, sim.Events)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+
+	run.start = sim.Start
+	if run.start.IsZero() && len(events) > 0 {
+		run.start = events[0].Time
+	}
+	end := run.start.Add(sim.Duration)
+	if sim.Duration == 0 && len(events) > 0 {
+		end = events[len(events)-1].Time
+	}
+
+	if err := run.attachProbes(); err != nil {
+		return err
+	}
+
+	run.now = run.start
+	run.fire("BEGIN", &Event{})
+	for i := range events {
+		if run.exited {
+			break
+		}
+		run.fireIntervals(events[i].Time)
+		if !run.exited {
+			run.now = events[i].Time
+			run.fire(normalizeAttachPoint(events[i].Probe), &events[i])
+		}
+	}
+	if !run.exited {
+		run.fireIntervals(end)
+	}
+
+	run.exited = false
+	run.fire("END", &Event{})
+	run.printMaps()
+	return nil
+}
+
+// attachProbes groups probes by attach point and prints the number of
+// attached probes
+func (run *simulation) attachProbes() error {
+	run.probes = make(map[string][]*probe)
+	count := 0
+	for _, pr := range run.script.probes {
+		for _, attachPoint := range pr.attachPoints {
+			attachPoint = normalizeAttachPoint(attachPoint)
+			count++
+
+			if !strings.HasPrefix(attachPoint, "interval:") {
+				run.probes[attachPoint] = append(run.probes[attachPoint], pr)
+				continue
+			}
+
+			period, err := parseInterval(attachPoint)
+			if err != nil {
+				return err
+			}
+			run.intervals = append(run.intervals, &intervalProbe{
+				probe:  pr,
+				period: period,
+				next:   run.start.Add(period),
+			})
+		}
+	}
+
+	fmt.Fprintf(run.w, "Attaching %d probes...\n", count)
+	return nil
+}
+
+// normalizeAttachPoint expands short forms of the probe types
+func normalizeAttachPoint(attachPoint string) string {
+	for short, full := range map[string]string{
+		"k:": "kprobe:", "kr:": "kretprobe:", "t:": "tracepoint:", "i:": "interval:",
+	} {
+		if strings.HasPrefix(attachPoint, short) {
+			return full + attachPoint[len(short):]
+		}
+	}
+	return attachPoint
+}
+
+func parseInterval(attachPoint string) (time.Duration, error) {
+	parts := strings.Split(attachPoint, ":")
+	if len(parts) == 3 {
+		n, err := strconv.Atoi(parts[2])
+		if err == nil && n > 0 {
+			switch parts[1] {
+			case "s":
+				return time.Duration(n) * time.Second, nil
+			case "ms":
+				return time.Duration(n) * time.Millisecond, nil
+			case "us":
+				return time.Duration(n) * time.Microsecond, nil
+			}
+		}
+	}
+	return 0, &ScriptError{Message: fmt.Sprintf("unsupported interval '%s'", attachPoint)}
+}
+
+// fireIntervals fires interval probes which are due before the time
+func (run *simulation) fireIntervals(until time.Time) {
+	for !run.exited {
+		var next *intervalProbe
+		for _, ip := range run.intervals {
+			if !ip.next.After(until) && (next == nil || ip.next.Before(next.next)) {
+				next = ip
+			}
+		}
+		if next == nil {
+			return
+		}
+
+		run.now = next.next
+		next.next = next.next.Add(next.period)
+		run.runProbe(next.probe, &Event{}, nil)
+	}
+}
+
+func (run *simulation) fire(attachPoint string, ev *Event) {
+	probes := run.probes[attachPoint]
+	if len(probes) == 0 {
+		return
+	}
+
+	args := run.buildArgs(ev)
+	for _, pr := range probes {
+		if run.exited {
+			return
+		}
+		run.runProbe(pr, ev, args)
+	}
+}
+
+func (run *simulation) buildArgs(ev *Event) map[string]value {
+	args := make(map[string]value, len(ev.Args))
+	for name, arg := range ev.Args {
+		switch arg.Kind {
+		case ArgInt:
+			args[name] = intValue(arg.Value)
+		case ArgSkb, ArgSkbRef:
+			if ev.Packet == nil {
+				panic(&ScriptError{Message: fmt.Sprintf(
+					"event %s has skb argument %s without packet", ev.Probe, name)})
+			}
+
+			skb := run.buildSkb(ev)
+			if arg.Kind == ArgSkbRef {
+				ref := run.mem.alloc(8)
+				run.mem.writeInt(ref, intTypes["uint64"], int64(skb))
+				skb = ref
+			}
+			args[name] = intValue(int64(skb))
+		}
+	}
+	return args
+}
+
+// printMaps prints non-empty maps on exit
+func (run *simulation) printMaps() {
+	names := make([]string, 0, len(run.maps))
+	for name := range run.maps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sep := "\n"
+	for _, name := range names {
+		bm := run.maps[name]
+		if len(bm.entries) > 0 {
+			io.WriteString(run.w, sep)
+			bm.print(run.w, 0)
+			sep = ""
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package bpfsim
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testStart = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestPacket(offset time.Duration, ipID uint16, saddr byte) *Packet {
+	data := make([]byte, ethHeaderLength+20)
+	binary.BigEndian.PutUint16(data[12:], ethPIPv4)
+
+	iph := data[ethHeaderLength:]
+	iph[0] = 0x45
+	binary.BigEndian.PutUint16(iph[2:], 20)
+	binary.BigEndian.PutUint16(iph[4:], ipID)
+	iph[8] = 64
+	copy(iph[12:], []byte{10, 0, 0, saddr})
+	copy(iph[16:], []byte{10, 0, 0, 100})
+	return &Packet{Time: testStart.Add(offset), Data: data}
+}
+
+func TestSimulatorRunScript(t *testing.T) {
+	packets := []*Packet{
+		newTestPacket(100*time.Millisecond, 1, 1),
+		newTestPacket(200*time.Millisecond, 2, 2),
+		newTestPacket(1500*time.Millisecond, 3, 1),
+	}
+	sim := &Simulator{
+		Events: PacketEvents("kprobe:dev_queue_xmit",
+			map[string]Arg{"arg0": SkbArg}, 0, packets),
+		Duration: 2 * time.Second,
+	}
+
+	script := `
+struct iphdr {
+    uint8_t ihl_version;
+    uint8_t tos;
+    uint16_t tot_len;
+    uint16_t id;
+    uint16_t frag_off;
+    uint8_t ttl;
+    uint8_t protocol;
+    uint16_t check;
+    uint32_t saddr;
+    uint32_t daddr;
+} __attribute__((packed));
+
+k:dev_queue_xmit {
+    $skb = (struct sk_buff*) arg0;
+    $iph = (struct iphdr*) ($skb->head + $skb->network_header);
+    if ($iph->saddr == 0x0100000a) {
+        printf("%s id %d %s\n", $skb->dev->name, bswap($iph->id), ntop($iph->daddr));
+        @bytes[ntop($iph->saddr)] = sum($skb->len);
+    } else {
+        @other = count();
+    }
+}
+
+interval:s:1 {
+    time("%H:%M:%S\n");
+    print(@bytes);
+    clear(@bytes);
+}
+
+END {
+    clear(@bytes);
+}
+`
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, sim.RunScript(strings.NewReader(script), buf))
+	assert.Equal(t, "Attaching 3 probes...\n"+
+		"eth0 id 1 10.0.0.100\n"+
+		"12:00:01\n"+
+		"@bytes[10.0.0.1]: 34\n"+
+		"\n"+
+		"eth0 id 3 10.0.0.100\n"+
+		"12:00:02\n"+
+		"@bytes[10.0.0.1]: 34\n"+
+		"\n"+
+		"\n"+
+		"@other: 1\n"+
+		"\n", buf.String())
+}
+
+func TestSimulatorHist(t *testing.T) {
+	var events []Event
+	for _, n := range []int64{0, 1, 3, 3, 1500} {
+		events = append(events, Event{
+			Time:  testStart,
+			Probe: "tracepoint:net:net_dev_xmit",
+			Args:  map[string]Arg{"len": IntArg(n)},
+		})
+	}
+
+	buf := bytes.NewBuffer(nil)
+	sim := &Simulator{Events: events}
+	require.NoError(t, sim.RunScript(strings.NewReader(
+		"t:net:net_dev_xmit { @len = hist(args->len); }"), buf))
+	assert.Equal(t, "Attaching 1 probes...\n\n@len:\n"+
+		"[0]                    1 |@@@@@@@@@@@@@@@@@@@@@@@@@@                          |\n"+
+		"[1]                    1 |@@@@@@@@@@@@@@@@@@@@@@@@@@                          |\n"+
+		"[2, 4)                 2 |@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@|\n"+
+		"[4, 8)                 0 |                                                    |\n"+
+		"[8, 16)                0 |                                                    |\n"+
+		"[16, 32)               0 |                                                    |\n"+
+		"[32, 64)               0 |                                                    |\n"+
+		"[64, 128)              0 |                                                    |\n"+
+		"[128, 256)             0 |                                                    |\n"+
+		"[256, 512)             0 |                                                    |\n"+
+		"[512, 1K)              0 |                                                    |\n"+
+		"[1K, 2K)               1 |@@@@@@@@@@@@@@@@@@@@@@@@@@                          |\n"+
+		"\n", buf.String())
+}
+
+func TestSimulatorErrors(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		script string
+		err    string
+	}{
+		{"Syntax", "BEGIN {\n  $x = ;\n}", "bpfsim: line 2: "},
+		{"UnknownStruct", "BEGIN {\n  $x = ((struct foo*) 0)->bar;\n}", "bpfsim: line 2: "},
+		{"UnknownFunction", "BEGIN {\n\n  kaddr(\"x\");\n}", "bpfsim: line 3: "},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			sim := &Simulator{}
+			err := sim.RunScript(strings.NewReader(test.script), bytes.NewBuffer(nil))
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+}
+
+func TestReadPcap(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	header := make([]byte, pcapHeaderLength)
+	binary.BigEndian.PutUint32(header, pcapMagicNano)
+	binary.BigEndian.PutUint32(header[20:], linkTypeRaw)
+	buf.Write(header)
+
+	iph := newTestPacket(0, 1, 1).Data[ethHeaderLength:]
+	recordHeader := make([]byte, pcapRecordHeaderLength)
+	binary.BigEndian.PutUint32(recordHeader, uint32(testStart.Unix()))
+	binary.BigEndian.PutUint32(recordHeader[4:], 1500)
+	binary.BigEndian.PutUint32(recordHeader[8:], uint32(len(iph)))
+	buf.Write(recordHeader)
+	buf.Write(iph)
+
+	packets, err := ReadPcap(buf)
+	require.NoError(t, err)
+	require.Len(t, packets, 1)
+	assert.Equal(t, testStart.Add(1500*time.Nanosecond), packets[0].Time)
+	assert.Equal(t, []byte{0x08, 0x00}, packets[0].Data[12:14])
+	assert.Equal(t, iph, packets[0].Data[ethHeaderLength:])
+
+	_, err = ReadPcap(bytes.NewReader(make([]byte, pcapHeaderLength)))
+	assert.ErrorContains(t, err, "not a pcap file")
+}
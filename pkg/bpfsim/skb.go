@@ -0,0 +1,140 @@
+package bpfsim
+
+import (
+	"encoding/binary"
+)
+
+const (
+	skbHeadroom = 64
+
+	ethHeaderLength  = 14
+	vlanHeaderLength = 4
+
+	ethPIPv4 = 0x0800
+	ethPIPv6 = 0x86dd
+	ethPVlan = 0x8100
+	ethPQinQ = 0x88a8
+
+	arphrdEther = 1
+)
+
+// buildSkb places packet of the event into sk_buff as driver does before
+// passing it to the stack: headers offsets are set, data points to
+// Ethernet header. sk_buff is built once for the packet, but its device
+// is updated for each event.
+func (run *simulation) buildSkb(ev *Event) uint64 {
+	dev := ev.Device
+	if dev == nil {
+		dev = DefaultDevice
+	}
+
+	skbType := run.script.types.lookupStruct("sk_buff")
+	skb, ok := run.skbs[ev.Packet]
+	if !ok {
+		skb = run.mem.alloc(skbType.size)
+		run.skbs[ev.Packet] = skb
+		run.fillSkb(skb, ev.Packet)
+	}
+
+	run.setField(skb, skbType, "dev", int64(run.buildDevice(dev)))
+	run.setField(skb, skbType, "skb_iif", int64(dev.Ifindex))
+	return skb
+}
+
+func (run *simulation) fillSkb(skb uint64, pkt *Packet) {
+	skbType := run.script.types.lookupStruct("sk_buff")
+	shinfoType := run.script.types.lookupStruct("skb_shared_info")
+
+	data := pkt.Data
+	head := run.mem.alloc(skbHeadroom + len(data) + shinfoType.size)
+	run.mem.write(head+skbHeadroom, data)
+
+	macHeader := skbHeadroom
+	networkHeader := macHeader + ethHeaderLength
+	proto := uint16(0)
+	if len(data) >= ethHeaderLength {
+		proto = binary.BigEndian.Uint16(data[12:14])
+	}
+	for (proto == ethPVlan || proto == ethPQinQ) && len(data) >= networkHeader-skbHeadroom+vlanHeaderLength {
+		proto = binary.BigEndian.Uint16(data[networkHeader-skbHeadroom+2:])
+		networkHeader += vlanHeaderLength
+	}
+
+	transportHeader := networkHeader
+	switch proto {
+	case ethPIPv4:
+		if len(data) > networkHeader-skbHeadroom {
+			transportHeader += int(data[networkHeader-skbHeadroom]&0xf) * 4
+		}
+	case ethPIPv6:
+		transportHeader += 40
+	}
+
+	tail := skbHeadroom + len(data)
+	fields := map[string]int64{
+		"head":             int64(head),
+		"data":             int64(head) + int64(macHeader),
+		"len":              int64(len(data)),
+		"mac_len":          ethHeaderLength,
+		"mac_header":       int64(macHeader),
+		"network_header":   int64(networkHeader),
+		"transport_header": int64(transportHeader),
+		"tail":             int64(tail),
+		"end":              int64(tail),
+		"truesize":         int64(tail + shinfoType.size + skbType.size),
+		"users":            1,
+
+		// Protocol is stored in network byte order
+		"protocol": int64(proto>>8 | proto<<8),
+	}
+	for name, n := range fields {
+		run.setField(skb, skbType, name, n)
+	}
+
+	run.setField(head+uint64(tail), shinfoType, "gso_segs", 1)
+}
+
+func (run *simulation) buildDevice(dev *Device) uint64 {
+	if addr, ok := run.devices[dev]; ok {
+		return addr
+	}
+
+	devType := run.script.types.lookupStruct("net_device")
+	addr := run.mem.alloc(devType.size)
+	run.devices[dev] = addr
+
+	offset, _, _ := devType.findField("name")
+	run.mem.write(addr+uint64(offset), []byte(dev.Name))
+	run.setField(addr, devType, "ifindex", int64(dev.Ifindex))
+	run.setField(addr, devType, "mtu", int64(dev.MTU))
+	run.setField(addr, devType, "type", arphrdEther)
+
+	offset, ndNetType, _ := devType.findField("nd_net")
+	run.setField(addr+uint64(offset), ndNetType, "net", int64(run.buildNetns(dev.Netns)))
+	return addr
+}
+
+func (run *simulation) buildNetns(inum uint32) uint64 {
+	if addr, ok := run.namespaces[inum]; ok {
+		return addr
+	}
+
+	netType := run.script.types.lookupStruct("net")
+	addr := run.mem.alloc(netType.size)
+	run.namespaces[inum] = addr
+
+	offset, nsType, _ := netType.findField("ns")
+	run.setField(addr+uint64(offset), nsType, "inum", int64(inum))
+	return addr
+}
+
+func (run *simulation) setField(addr uint64, t *Type, name string, n int64) {
+	offset, typ, ok := t.findField(name)
+	if !ok {
+		panic(&ScriptError{Message: "struct " + t.name + " doesn't have field " + name})
+	}
+	if typ.kind == kindPointer {
+		typ = intTypes["uint64"]
+	}
+	run.mem.writeInt(addr+uint64(offset), typ, n)
+}
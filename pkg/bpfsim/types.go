@@ -0,0 +1,205 @@
+package bpfsim
+
+import (
+	"fmt"
+	"strings"
+)
+
+type typeKind int
+
+const (
+	kindInt typeKind = iota
+	kindPointer
+	kindArray
+	kindStruct
+)
+
+// Type describes layout of the value in simulated memory. Structures are
+// always packed: scripts define their structures with packed attribute,
+// and kernel structures of the simulator are laid out so fields are aligned.
+type Type struct {
+	kind   typeKind
+	name   string
+	size   int
+	signed bool
+
+	// Element of the pointer or the array
+	elem   *Type
+	length int
+
+	// Fields of the structure, nil if structure is not defined
+	fields []*structField
+}
+
+type structField struct {
+	// Name of the field, empty for anonymous structures and unions which
+	// fields are accessed directly
+	name   string
+	offset int
+	typ    *Type
+}
+
+var intTypes = map[string]*Type{}
+
+func init() {
+	for _, it := range []struct {
+		names  []string
+		size   int
+		signed bool
+	}{
+		{[]string{"uint8", "uint8_t", "u8", "__u8", "unsigned char", "bool"}, 1, false},
+		{[]string{"int8", "int8_t", "s8", "char", "signed char"}, 1, true},
+		{[]string{"uint16", "uint16_t", "u16", "__u16", "__be16", "unsigned short"}, 2, false},
+		{[]string{"int16", "int16_t", "s16", "short"}, 2, true},
+		{[]string{"uint32", "uint32_t", "u32", "__u32", "__be32", "unsigned int", "unsigned"}, 4, false},
+		{[]string{"int32", "int32_t", "s32", "int"}, 4, true},
+		{[]string{"uint64", "uint64_t", "u64", "__u64", "unsigned long", "size_t"}, 8, false},
+		{[]string{"int64", "int64_t", "s64", "long"}, 8, true},
+	} {
+		for _, name := range it.names {
+			intTypes[name] = &Type{kind: kindInt, name: name, size: it.size, signed: it.signed}
+		}
+	}
+}
+
+func pointerTo(elem *Type) *Type {
+	return &Type{kind: kindPointer, size: 8, elem: elem}
+}
+
+func (t *Type) String() string {
+	switch t.kind {
+	case kindPointer:
+		return t.elem.String() + "*"
+	case kindArray:
+		return fmt.Sprintf("%s[%d]", t.elem, t.length)
+	case kindStruct:
+		return "struct " + t.name
+	}
+	return t.name
+}
+
+// isCharArray returns true for arrays which contain C strings such as
+// device name
+func (t *Type) isCharArray() bool {
+	return t.kind == kindArray && t.elem.kind == kindInt && t.elem.size == 1
+}
+
+// findField looks up field of the structure including fields of anonymous
+// structures and unions and returns its offset relative to the structure
+func (t *Type) findField(name string) (int, *Type, bool) {
+	for _, field := range t.fields {
+		if field.name == name {
+			return field.offset, field.typ, true
+		}
+		if field.name == "" {
+			if offset, typ, ok := field.typ.findField(name); ok {
+				return field.offset + offset, typ, true
+			}
+		}
+	}
+	return 0, nil, false
+}
+
+// typeRegistry keeps structures defined by script and by simulator, so
+// pointers to structures which are defined later are resolved
+type typeRegistry map[string]*Type
+
+func (reg typeRegistry) lookupStruct(name string) *Type {
+	if t, ok := reg[name]; ok {
+		return t
+	}
+
+	t := &Type{kind: kindStruct, name: name}
+	reg[name] = t
+	return t
+}
+
+// lookupType returns integer type or structure by its name which is given
+// without struct keyword as bpftrace allows it
+func (reg typeRegistry) lookupType(name string) *Type {
+	name = strings.TrimPrefix(name, "struct ")
+	if t, ok := intTypes[name]; ok {
+		return t
+	}
+	return reg.lookupStruct(name)
+}
+
+// kernelStructs defines layout of the kernel structures which are built
+// from packets. Offsets of sk_buff fields follow x86_64 kernel, so cb field
+// is at SkbCbOffset.
+const kernelStructs = `
+struct sk_buff {
+    struct sk_buff *next;
+    struct sk_buff *prev;
+    struct net_device *dev;
+    struct sock *sk;
+    uint64_t tstamp;
+    char cb[48];
+    unsigned long _skb_refdst;
+    uint32_t len;
+    uint32_t data_len;
+    uint16_t mac_len;
+    uint16_t hdr_len;
+    uint16_t queue_mapping;
+    uint8_t pkt_type;
+    uint8_t ip_summed;
+    uint32_t priority;
+    int skb_iif;
+    uint32_t hash;
+    uint16_t vlan_proto;
+    uint16_t vlan_tci;
+    uint32_t mark;
+    uint16_t csum_start;
+    uint16_t csum_offset;
+    uint16_t protocol;
+    uint16_t transport_header;
+    uint16_t network_header;
+    uint16_t mac_header;
+    uint32_t tail;
+    uint32_t end;
+    unsigned char *head;
+    unsigned char *data;
+    uint32_t truesize;
+    uint32_t users;
+};
+
+struct skb_shared_info {
+    uint8_t flags;
+    uint8_t meta_len;
+    uint8_t nr_frags;
+    uint8_t tx_flags;
+    uint16_t gso_size;
+    uint16_t gso_segs;
+    struct sk_buff *frag_list;
+    uint8_t hwtstamps[8];
+    uint32_t gso_type;
+    uint32_t tskey;
+};
+
+struct ns_common {
+    uint64_t stashed;
+    uint64_t ops;
+    uint32_t inum;
+    uint32_t count;
+};
+
+struct net {
+    uint64_t passive;
+    uint64_t list[2];
+    struct ns_common ns;
+};
+
+struct net_device {
+    char name[16];
+    uint64_t state;
+    uint64_t features;
+    int ifindex;
+    uint32_t flags;
+    uint32_t mtu;
+    uint16_t type;
+    uint16_t hard_header_len;
+    struct {
+        struct net *net;
+    } nd_net;
+};
+`
@@ -0,0 +1,61 @@
+package bpfsim
+
+import (
+	"strconv"
+	"strings"
+)
+
+// value is a result of expression. Integers without type are 64-bit,
+// pointers and integers loaded from memory keep their types, so casts and
+// bswap know their size. Values of structures and arrays hold their address.
+type value struct {
+	typ   *Type
+	n     int64
+	s     string
+	isStr bool
+}
+
+func intValue(n int64) value {
+	return value{n: n}
+}
+
+func boolValue(b bool) value {
+	if b {
+		return intValue(1)
+	}
+	return intValue(0)
+}
+
+func strValue(s string) value {
+	return value{s: s, isStr: true}
+}
+
+// isAddress returns true for values of structures and arrays which are not
+// loaded from memory
+func (v value) isAddress() bool {
+	return v.typ != nil && (v.typ.kind == kindStruct || v.typ.kind == kindArray)
+}
+
+// String formats value as bpftrace prints map keys and values
+func (v value) String() string {
+	if v.isStr {
+		return v.s
+	}
+	return strconv.FormatInt(v.n, 10)
+}
+
+// keyString returns unique representation of map key
+func keyString(keys []value) string {
+	var buf strings.Builder
+	for _, key := range keys {
+		if key.isStr {
+			buf.WriteString("s:")
+			buf.WriteString(key.s)
+		} else {
+			buf.WriteString("i:")
+			buf.WriteString(strconv.FormatInt(key.n, 10))
+		}
+		buf.WriteByte(0)
+	}
+	return buf.String()
+}
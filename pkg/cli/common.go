@@ -2,13 +2,16 @@ package cli
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/yandex-cloud/skbtrace"
+	"github.com/yandex-cloud/skbtrace/pkg/proto"
 	"github.com/yandex-cloud/skbtrace/pkg/skb"
+	"github.com/yandex-cloud/skbtrace/pkg/sysinfo"
 )
 
 const (
@@ -68,6 +71,50 @@ func (v *aggrFuncValue) Set(newValue string) error {
 	return fmt.Errorf("invalid aggregate function '%s'", newValue)
 }
 
+// sampleValue parses sampling ratio specified as '1/N' or simply 'N'
+type sampleValue struct {
+	sample *int
+}
+
+func (v *sampleValue) Type() string { return "1/N" }
+func (v *sampleValue) String() string {
+	if *v.sample == 0 {
+		return ""
+	}
+	return fmt.Sprintf("1/%d", *v.sample)
+}
+func (v *sampleValue) Set(newValue string) error {
+	sample, err := strconv.Atoi(strings.TrimPrefix(newValue, "1/"))
+	if err != nil || sample <= 0 {
+		return fmt.Errorf("invalid sampling ratio '%s', should be in form of 1/N", newValue)
+	}
+
+	*v.sample = sample
+	return nil
+}
+
+// rateValue parses rate limit specified as 'N/s' or simply 'N'
+type rateValue struct {
+	rate *int
+}
+
+func (v *rateValue) Type() string { return "N/s" }
+func (v *rateValue) String() string {
+	if *v.rate == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/s", *v.rate)
+}
+func (v *rateValue) Set(newValue string) error {
+	rate, err := strconv.Atoi(strings.TrimSuffix(newValue, "/s"))
+	if err != nil || rate <= 0 {
+		return fmt.Errorf("invalid rate '%s', should be in form of N/s", newValue)
+	}
+
+	*v.rate = rate
+	return nil
+}
+
 func PassCommonOptions(ctx *VisitorContext, cmd *cobra.Command, dst, src *skbtrace.CommonOptions) {
 	ctx.AddPreRun(cmd, func(cmd *cobra.Command, args []string) error {
 		*dst = *src
@@ -81,6 +128,43 @@ func NewDeviceFilter(itfName string) *skbtrace.Filter {
 		Value: fmt.Sprintf(`"%s"`, itfName)}
 }
 
+func NewIfindexFilter(ifindex int64) *skbtrace.Filter {
+	return &skbtrace.Filter{
+		Object: skb.IfindexAlias, Op: "==",
+		Value: strconv.FormatInt(ifindex, 10)}
+}
+
+// newInterfaceFilter creates filter by interface index if interface can be
+// resolved from sysfs, or falls back to filter by name (i.e. for interfaces
+// that will be created later or live in other network namespaces, or if it
+// is requested by --iface-by-name)
+func newInterfaceFilter(ctx *VisitorContext, itfName string) *skbtrace.Filter {
+	if ifindex, err := strconv.ParseInt(itfName, 10, 32); err == nil {
+		return NewIfindexFilter(ifindex)
+	}
+	if ctx.IfaceByName {
+		return NewDeviceFilter(itfName)
+	}
+
+	ifindex, err := sysinfo.ResolveIfindex(ctx.SysRoot, itfName)
+	if err != nil {
+		return NewDeviceFilter(itfName)
+	}
+	return NewIfindexFilter(ifindex)
+}
+
+func NewNetnsFilter(netnsIno uint64) *skbtrace.Filter {
+	return &skbtrace.Filter{
+		Object: skb.NetnsAlias, Op: "==",
+		Value: strconv.FormatUint(netnsIno, 10)}
+}
+
+func NewCgroupFilter(cgroupId uint64) *skbtrace.Filter {
+	return &skbtrace.Filter{
+		Object: proto.CgroupAlias, Op: "==",
+		Value: strconv.FormatUint(cgroupId, 10)}
+}
+
 func RegisterCommonDumpOptions(flags *pflag.FlagSet, opt *skbtrace.CommonDumpOptions) {
 	opt.TimeMode = skbtrace.TMTime
 
@@ -96,6 +180,24 @@ func RegisterCommonDumpOptions(flags *pflag.FlagSet, opt *skbtrace.CommonDumpOpt
 		`Dump userspace stack on each probe.`)
 }
 
+func RegisterDumpLimitOptions(flags *pflag.FlagSet, opt *skbtrace.DumpLimitOptions) {
+	flags.Var(&sampleValue{&opt.Sample}, "sample",
+		`Print only each N-th event on each CPU.`)
+	flags.Var(&rateValue{&opt.Rate}, "rate",
+		`Print no more than N events per second on each CPU.`)
+	flags.IntVar(&opt.MaxEvents, "max-events", 0,
+		`Exit after printing specified number of events.`)
+}
+
+func RegisterPreTriggerOptions(flags *pflag.FlagSet, opt *skbtrace.PreTriggerOptions) {
+	flags.IntVar(&opt.PreTrigger, "pre-trigger", 0,
+		`Record last N events for each set of keys and dump them when trigger fires.`)
+	flags.StringSliceVar(&opt.PreTriggerFields, "pre-trigger-fields", nil,
+		`Numeric fields recorded for each event in pre-trigger history.`)
+	flags.StringSliceVar(&opt.PreTriggerProbes, "pre-trigger-probe", nil,
+		`Extra probes such as 'free' which dump pre-trigger history of the keys when hit.`)
+}
+
 func RegisterFilterOptions(flags *pflag.FlagSet, options *skbtrace.FilterOptions) {
 	flags.VarP(newRawFilterSliceValue(&options.RawFilters), "filter", "F",
 		`Filters. Use 'fields' subcommand to list available fields.`)
@@ -111,8 +213,13 @@ func RegisterTracerContextOptions(flags *pflag.FlagSet, opts *skbtrace.TraceComm
 		`Probe names that trigger normal probe execution. Use 'probes' subcommand to list available probes.`)
 	flags.Var(newRawFilterSliceValue(&opts.ContextFilterOptions.RawFilters), "context-filter",
 		`Filters. Use 'fields' subcommand to list available fields.`)
-	flags.StringVar(&opts.ContextKey, "context-key", defaultContextKey,
-		`Key to be used to map context probe firings to normal probe firings`)
+	flags.StringSliceVar(&opts.ContextKeys, "context-key", []string{defaultContextKey},
+		`Keys to be used to map context probe firings to normal probe firings. `+
+			`Use 'fields' subcommand to list available fields.`)
+	flags.StringSliceVar(&opts.ContextFields, "context-fields", nil,
+		`Fields captured by context probe and printed by normal probe when dumping.`)
+	flags.Var(newRawFilterSliceValue(&opts.ContextFieldFilterOptions.RawFilters), "context-field-filter",
+		`Filters on fields captured by context probe applied by normal probe.`)
 }
 
 func RegisterAggregateCommonOptions(flags *pflag.FlagSet, opts *skbtrace.AggregateCommonOptions) {
@@ -125,7 +232,8 @@ func RegisterInterfaceOptions(
 ) {
 	var itfName string
 	cmd.PersistentFlags().StringVarP(&itfName, "iface", "i", "",
-		`Interface device name. Shortcut for '$netdev->name == "Device"' filter.`)
+		`Interface device name or index. Shortcut for 'ifindex == INDEX' filter if interface`+
+			` exists on host, or '$netdev->name == "Device"' filter otherwise or if --iface-by-name is set.`)
 
 	ctx.AddPreRun(cmd, func(cmd *cobra.Command, args []string) error {
 		if itfName == "" {
@@ -137,7 +245,53 @@ func RegisterInterfaceOptions(
 			return err
 		}
 
-		options.Filters = append(options.Filters, NewDeviceFilter(itfName))
+		options.Filters = append(options.Filters, newInterfaceFilter(ctx, itfName))
+		return nil
+	})
+}
+
+func RegisterNetnsOptions(
+	ctx *VisitorContext, cmd *cobra.Command, options *skbtrace.FilterOptions,
+) {
+	var netnsSpec string
+	cmd.PersistentFlags().StringVar(&netnsSpec, "netns", "",
+		`Network namespace of the device specified by pid or path such as /run/netns/NAME.`+
+			` Shortcut for 'netns == INODE' filter.`)
+
+	ctx.AddPreRun(cmd, func(cmd *cobra.Command, args []string) error {
+		if netnsSpec == "" {
+			return nil
+		}
+
+		netnsIno, err := sysinfo.ResolveNetns(ctx.ProcRoot, netnsSpec)
+		if err != nil {
+			return err
+		}
+
+		options.Filters = append(options.Filters, NewNetnsFilter(netnsIno))
+		return nil
+	})
+}
+
+func RegisterCgroupOptions(
+	ctx *VisitorContext, cmd *cobra.Command, options *skbtrace.FilterOptions,
+) {
+	var cgroupPath string
+	cmd.PersistentFlags().StringVar(&cgroupPath, "cgroup", "",
+		`Path to cgroup v2 directory owning the socket such as /sys/fs/cgroup/kubepods.slice/...`+
+			` Shortcut for 'cgroup == ID' filter.`)
+
+	ctx.AddPreRun(cmd, func(cmd *cobra.Command, args []string) error {
+		if cgroupPath == "" {
+			return nil
+		}
+
+		cgroupId, err := sysinfo.ResolveCgroup(cgroupPath)
+		if err != nil {
+			return err
+		}
+
+		options.Filters = append(options.Filters, NewCgroupFilter(cgroupId))
 		return nil
 	})
 }
@@ -154,7 +308,7 @@ func RegisterTimeIntervalArg(ctx *VisitorContext, cmd *cobra.Command, interval *
 func buildInterfaceFilter(ctx *VisitorContext, itfName string) ([]*skbtrace.Filter, error) {
 	itfName, err := ctx.Dependencies.PreprocessInterface(itfName)
 	if err == nil {
-		return []*skbtrace.Filter{NewDeviceFilter(itfName)}, nil
+		return []*skbtrace.Filter{newInterfaceFilter(ctx, itfName)}, nil
 	}
 	return nil, err
 }
@@ -162,7 +316,7 @@ func buildInterfaceFilter(ctx *VisitorContext, itfName string) ([]*skbtrace.Filt
 func buildUnderlayFilter(ctx *VisitorContext, itfName, underlayName string) ([]*skbtrace.Filter, error) {
 	underlayFilter, err := ctx.Dependencies.GuessUnderlayDeviceFilters(itfName)
 	if underlayFilter == nil && err == nil {
-		underlayFilter = []*skbtrace.Filter{NewDeviceFilter(underlayName)}
+		underlayFilter = []*skbtrace.Filter{newInterfaceFilter(ctx, underlayName)}
 	}
 	return underlayFilter, err
 }
@@ -0,0 +1,128 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+type completionFunc func(ctx *VisitorContext, toComplete string) []string
+
+// completionFlags maps names of the flags to functions which complete them
+var completionFlags = map[string]completionFunc{
+	"probe":              completeProbes,
+	"context-probe":      completeProbes,
+	"row":                completeRows,
+	"key":                completeFields,
+	"arg":                completeFields,
+	"context-key":        completeFields,
+	"context-fields":     completeFields,
+	"pre-trigger-fields": completeFields,
+	"filter":             completeFilters,
+	"context-filter":     completeFilters,
+}
+
+// addCompletions registers completion functions for the flags of the command
+// and its children. As these functions are called without running pre-run
+// functions, builder is set up by them using global options such as -6 and -e
+func addCompletions(ctx *VisitorContext, cmd *cobra.Command, setup PreRunEFunc) {
+	cmd.LocalFlags().VisitAll(func(flag *pflag.Flag) {
+		complete, ok := completionFlags[flag.Name]
+		if !ok {
+			return
+		}
+
+		_, isSlice := flag.Value.(pflag.SliceValue)
+		cmd.RegisterFlagCompletionFunc(flag.Name,
+			func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+				if !ctx.completionReady {
+					if err := setup(cmd, args); err != nil {
+						cobra.CompErrorln(err.Error())
+						return nil, cobra.ShellCompDirectiveError
+					}
+					ctx.completionReady = true
+				}
+
+				// Complete only the last item of comma-separated list
+				var prefix string
+				if isSlice {
+					if index := strings.LastIndexByte(toComplete, ','); index >= 0 {
+						prefix, toComplete = toComplete[:index+1], toComplete[index+1:]
+					}
+				}
+
+				var completions []string
+				for _, completion := range complete(ctx, toComplete) {
+					if strings.HasPrefix(completion, toComplete) {
+						completions = append(completions, prefix+completion)
+					}
+				}
+				return completions, cobra.ShellCompDirectiveNoFileComp
+			})
+	})
+
+	for _, child := range cmd.Commands() {
+		addCompletions(ctx, child, setup)
+	}
+}
+
+func completeProbes(ctx *VisitorContext, toComplete string) []string {
+	var completions []string
+	for _, probe := range ctx.Builder.Probes() {
+		for _, name := range append(probe.Aliases, probe.Name) {
+			completions = append(completions, fmt.Sprintf("%s\t%s", name, probe.Help))
+		}
+	}
+	return completions
+}
+
+func completeRows(ctx *VisitorContext, toComplete string) []string {
+	var completions []string
+	seen := make(map[string]struct{})
+	for _, fg := range ctx.Builder.FieldGroups() {
+		if _, ok := seen[fg.Row]; ok {
+			continue
+		}
+		seen[fg.Row] = struct{}{}
+		completions = append(completions, fg.Row)
+	}
+	return completions
+}
+
+// completeFields completes field aliases and objectless fields such as
+// global variables. Fields of the objects are completed in $obj->field
+// notation only if object name is being typed, i.e. after '$' sign
+func completeFields(ctx *VisitorContext, toComplete string) []string {
+	completions := ctx.Builder.FieldAliases()
+
+	seen := make(map[string]struct{})
+	for _, fg := range ctx.Builder.FieldGroups() {
+		isObject := fg.Object != ""
+		if isObject && !strings.HasPrefix(toComplete, fg.Object[:1]) {
+			continue
+		}
+
+		for _, field := range fg.Fields {
+			name := field.Name
+			if isObject {
+				name = fmt.Sprintf("%s->%s", fg.Object, field.Name)
+			}
+			if _, ok := seen[name]; !ok {
+				seen[name] = struct{}{}
+				completions = append(completions, name)
+			}
+		}
+	}
+	return completions
+}
+
+// completeFilters completes field part of the filter, so values
+// and operators are left to user
+func completeFilters(ctx *VisitorContext, toComplete string) []string {
+	if strings.ContainsAny(strings.ReplaceAll(toComplete, "->", ""), " =!<>") {
+		return nil
+	}
+	return completeFields(ctx, toComplete)
+}
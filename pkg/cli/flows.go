@@ -0,0 +1,84 @@
+package cli
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yandex-cloud/skbtrace"
+	"github.com/yandex-cloud/skbtrace/pkg/stringutil"
+)
+
+const defaultFlowsInterval = 5 * time.Second
+
+var (
+	flowKeysIp        = []string{"src", "dst"}
+	flowKeysTransport = []string{"src", "dst", "sport", "dport"}
+)
+
+type flowsOptions struct {
+	isInner bool
+}
+
+type FlowsTracerCommand struct{}
+
+func (*FlowsTracerCommand) Visit(
+	ctx *VisitorContext, cmd *cobra.Command,
+	commonOpts *skbtrace.TraceCommonOptions,
+) {
+	opts := skbtrace.FlowOptions{Interval: defaultFlowsInterval}
+	var flowsOpts flowsOptions
+	PassTraceCommonOptions(ctx, cmd, &opts.TraceCommonOptions, commonOpts)
+	RegisterTimeIntervalArg(ctx, cmd, &opts.Interval)
+	cmd.Flags().BoolVar(&flowsOpts.isInner, "inner", false,
+		`Use 5-tuple of encapsulated packet as flow key.`)
+
+	ctx.AddPreRun(cmd, func(cmd *cobra.Command, args []string) error {
+		buildFlowOptions(&opts, &flowsOpts)
+		return nil
+	})
+
+	cmd.Run = NewRun(ctx, func() (*skbtrace.Program, error) {
+		return ctx.Builder.BuildFlows(opts)
+	})
+}
+
+func buildFlowOptions(opts *skbtrace.FlowOptions, flowsOpts *flowsOptions) {
+	opts.Keys = flowKeysIp
+	opts.LenField = "$skb->len"
+	opts.TtlField = "ttl"
+
+	var transHint string
+	switch {
+	case stringutil.SliceContains(opts.Hints, "tcp"):
+		transHint = "tcp"
+		opts.TcpFlagsField = "tcp-flags"
+		opts.SeqField = "seq"
+	case stringutil.SliceContains(opts.Hints, "udp"):
+		transHint = "udp"
+	}
+	if transHint != "" {
+		opts.Keys = flowKeysTransport
+	}
+
+	if flowsOpts.isInner {
+		opts.Keys = wrapEncap(opts.Keys)
+		opts.TtlField = "inner-" + opts.TtlField
+		if opts.TcpFlagsField != "" {
+			opts.TcpFlagsField = "inner-" + opts.TcpFlagsField
+			opts.SeqField = "inner-" + opts.SeqField
+		}
+		if transHint != "" {
+			opts.Hints = append([]string{"inner-" + transHint}, opts.Hints...)
+		}
+	}
+}
+
+var FlowsCommand = &CommandProducer{
+	Base: &cobra.Command{
+		Use:     "flows -P PROBE... [--inner] [INTERVAL]",
+		Example: "flows -P recv -p tcp -i eth1 --inner 10s",
+		Short:   "Prints conntrack-like table of flows with packet and byte counters",
+		Args:    cobra.RangeArgs(0, 1),
+	},
+	TracerVisitor: &FlowsTracerCommand{},
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/yandex-cloud/skbtrace"
+	"github.com/yandex-cloud/skbtrace/pkg/proto"
 	"github.com/yandex-cloud/skbtrace/pkg/skb"
 	"github.com/yandex-cloud/skbtrace/pkg/stringutil"
 )
@@ -22,15 +23,23 @@ type forwardOptions struct {
 	itfNames        []string
 	underlayItfName string
 	direction       directionOptions
+	explain         bool
 }
 
 var (
-	forwardKeysRaw = []string{"id"}
-	forwardKeysUdp = []string{"sport", "dport"}
-	forwardKeysTcp = []string{"sport", "dport", "seq"}
+	forwardKeysRaw  = []string{"id"}
+	forwardKeysUdp  = []string{"sport", "dport"}
+	forwardKeysTcp  = []string{"sport", "dport", "seq"}
+	forwardKeysIcmp = []string{"icmp-id", "icmp-seq"}
+
+	forwardExplainFields = []string{proto.RouteTableAlias, proto.RouteErrAlias,
+		proto.RouteTypeAlias, proto.RouteDevAlias, proto.RouteGwAlias}
+	forwardExplainMissFilters = []string{"route-err != 0", "route-err != EAGAIN"}
+	forwardExplainMissRows    = []string{"route-flow", "route-lookup"}
 
 	forwardUsageError = errors.New("exactly two interfaces or" +
 		" one interface with ingress/egress flag is expected")
+	forwardExplainError = errors.New("--explain is only supported by outliers subcommand")
 )
 
 var ForwardCommand = &CommandProducer{
@@ -44,13 +53,21 @@ var ForwardCommand = &CommandProducer{
 		registerForwardOptions(cmd.PersistentFlags(), &fwdOpts)
 
 		ctx.AddPreRun(cmd, func(cmd *cobra.Command, args []string) error {
+			if fwdOpts.explain && cmd.Name() != TimeOutlierDumpSubcommand.Base.Name() {
+				return forwardExplainError
+			}
+
 			err := handleForwardFiltersInterface(ctx, &opts.FromSpec, skb.ProbeRecv,
 				&opts.CommonOptions, &fwdOpts)
 			if err != nil {
 				return err
 			}
-			return handleForwardFiltersInterface(ctx, &opts.ToSpec, skb.ProbeXmit,
+			err = handleForwardFiltersInterface(ctx, &opts.ToSpec, skb.ProbeXmit,
 				&opts.CommonOptions, &fwdOpts)
+			if err != nil {
+				return err
+			}
+			return buildForwardExplainOptions(&opts.Explain, &fwdOpts)
 		})
 
 		cmd.Run = NewDefaultAggregateRun(ctx, opts)
@@ -120,6 +137,41 @@ func handleForwardFiltersInterface(
 	return nil
 }
 
+// buildForwardExplainOptions annotates outliers with route lookups of
+// the packet. Lookup is done for the decapsulated packet, so inner
+// addresses are used on the encapsulated side.
+func buildForwardExplainOptions(opts *skbtrace.TimeExplainOptions, fwdOpts *forwardOptions) error {
+	if !fwdOpts.explain {
+		return nil
+	}
+
+	ipKeys := newIpForwardKeys(fwdOpts.direction)
+	routeKeys := make([]string, len(ipKeys))
+	for i, key := range ipKeys {
+		switch key {
+		case "src":
+			routeKeys[i] = proto.RouteSrcAlias
+		case "dst":
+			routeKeys[i] = proto.RouteDstAlias
+		default:
+			return fmt.Errorf("unexpected forward key '%s' for explain", key)
+		}
+	}
+
+	opts.Spec = skbtrace.TimeSpec{Probe: "fib-lookup", Keys: routeKeys}
+	opts.FromKeys, opts.ToKeys = ipKeys, ipKeys
+	if fwdOpts.direction.isInbound {
+		opts.FromKeys = wrapEncap(ipKeys)
+	} else if fwdOpts.direction.isOutbound {
+		opts.ToKeys = wrapEncap(ipKeys)
+	}
+
+	opts.Fields = forwardExplainFields
+	opts.MissFilterOptions = skbtrace.FilterOptions{RawFilters: forwardExplainMissFilters}
+	opts.MissRows = forwardExplainMissRows
+	return nil
+}
+
 func newIpForwardKeys(opts directionOptions) []string {
 	if opts.isNat {
 		// Assume DNAT on inbound packets, and SNAT on outbound, ignore corresponding addresses
@@ -148,6 +200,16 @@ func buildForwardKeys(
 		if encapOpt {
 			hints = []string{"inner-udp"}
 		}
+	} else if stringutil.SliceContains(commonOpts.Hints, "icmp") {
+		keys = append(keys, forwardKeysIcmp...)
+		if encapOpt {
+			hints = []string{"inner-icmp"}
+		}
+	} else if stringutil.SliceContains(commonOpts.Hints, "icmpv6") {
+		keys = append(keys, forwardKeysIcmp...)
+		if encapOpt {
+			hints = []string{"inner-icmpv6"}
+		}
 	} else {
 		keys = append(keys, forwardKeysRaw...)
 	}
@@ -175,4 +237,7 @@ func registerForwardOptions(flags *pflag.FlagSet, opts *forwardOptions) {
 		`Default underlay device used if it cannot be guessed`)
 	registerDirectionFlags(flags, &opts.direction)
 	RegisterFilterOptions(flags, &opts.filterOptions)
+	flags.BoolVar(&opts.explain, "explain", false,
+		"Annotate outliers with route lookups done for the packet and dump failed lookups."+
+			" Only supported by outliers subcommand.")
 }
@@ -0,0 +1,90 @@
+package cli
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/yandex-cloud/skbtrace"
+)
+
+// Softirq inherits thread id of the interrupted task, so cpu is needed to
+// distinguish calls. Calls of tasks which migrate to another cpu are not
+// matched and their start times are expired by periodic cleanup
+var funcKeys = []string{"tid", "cpu"}
+
+var FuncCommand = &CommandProducer{
+	Base: &cobra.Command{
+		Use:     "func -P PROBE [OPTIONS]",
+		Example: "func -P ip_rcv -i eth0 -F 'retval != 0' evcount",
+		Short:   "Measures time spent in kernel function per call",
+		Long: `Measures time between entry to a kernel function and return from it
+keyed by thread id and cpu. Filters are applied on the entry side where packet fields are
+accessible except for filters on retval (or its aliases) which are applied on return. Probe name
+without prefix is treated as kprobe.`,
+	},
+	TimeVisitor: func(ctx *VisitorContext, cmd *cobra.Command, opts *skbtrace.TimeCommonOptions) {
+		var spec skbtrace.TimeSpec
+
+		flags := cmd.PersistentFlags()
+		RegisterFilterOptions(flags, &spec.FilterOptions)
+		RegisterInterfaceOptions(ctx, cmd, &spec.FilterOptions)
+		RegisterNetnsOptions(ctx, cmd, &spec.FilterOptions)
+		RegisterCgroupOptions(ctx, cmd, &spec.FilterOptions)
+		flags.StringVarP(&spec.Probe, "probe", "P", "",
+			`Probe of the function entry. Use 'probes' subcommand to list available probes.`)
+
+		ctx.AddPreRun(cmd, func(cmd *cobra.Command, args []string) error {
+			return buildFuncOptions(ctx.Builder, opts, &spec)
+		})
+
+		cmd.Run = NewDefaultAggregateRun(ctx, opts)
+	},
+	Children: DefaultTimeSubcommands,
+}
+
+func buildFuncOptions(
+	builder *skbtrace.Builder, opts *skbtrace.TimeCommonOptions, spec *skbtrace.TimeSpec,
+) error {
+	if spec.Probe == "" {
+		return errors.New("probe of the function is not specified")
+	}
+
+	probe := spec.Probe
+	if !strings.Contains(probe, ":") {
+		probe = "kprobe:" + probe
+	}
+
+	var entryFilters, retFilters []string
+	for _, rawFilter := range spec.RawFilters {
+		filter, err := skbtrace.ParseFilter(rawFilter)
+		if err != nil {
+			return err
+		}
+
+		isRetval, err := builder.IsReturnValueFilter(filter)
+		if err != nil {
+			return err
+		}
+		if isRetval {
+			retFilters = append(retFilters, rawFilter)
+		} else {
+			entryFilters = append(entryFilters, rawFilter)
+		}
+	}
+
+	opts.FromSpec = skbtrace.TimeSpec{
+		Probe: probe,
+		Keys:  funcKeys,
+		FilterOptions: skbtrace.FilterOptions{
+			RawFilters: entryFilters,
+			Filters:    spec.Filters,
+		},
+	}
+	opts.ToSpec = skbtrace.TimeSpec{
+		Probe:         probe,
+		Return:        true,
+		FilterOptions: skbtrace.FilterOptions{RawFilters: retFilters},
+	}
+	return nil
+}
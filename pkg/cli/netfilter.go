@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yandex-cloud/skbtrace"
+	"github.com/yandex-cloud/skbtrace/pkg/proto"
+)
+
+type netfilterOptions struct {
+	allHooks bool
+
+	// Whether rules are loaded by legacy iptables rather than by nft or
+	// iptables-nft, which is a choice of userspace rather than of kernel
+	legacy bool
+}
+
+var (
+	nfRowsHook     = []string{"nf-hook", "nf-verdict"}
+	nfRowsIptables = []string{"nf-hook", "nf-table", "nf-verdict"}
+	nfRowsNftables = []string{"nf-hook", "nft-chain", "nf-verdict"}
+	nfKeysHook     = []string{proto.NfPfAlias, proto.NfHookAlias}
+	nfKeysIptables = []string{proto.NfTableAlias, proto.NfHookAlias}
+	nfKeysNftables = []string{proto.NftTableAlias, proto.NftChainAlias, proto.NfHookAlias}
+)
+
+var NetfilterCommand = &CommandProducer{
+	Base: &cobra.Command{
+		Use:     "netfilter [--all-hooks|--legacy] [-o ROWS] [--aggregate [-k KEYS]] [INTERVAL]",
+		Example: "netfilter -F 'nf-verdict == DROP' -o ip",
+		Short:   "Traces netfilter verdicts of the packets",
+		Long: `Traces verdicts returned by nftables chains, which also evaluate rules of
+iptables-nft, or by tables of legacy iptables with --legacy. With --all-hooks
+reports summary verdict of all rules in the hook instead. Verdicts are dumped
+per packet, or counted per table, chain and hook with --aggregate.
+
+Individual rules which issued the verdict are not identified: the rule is only
+known to nft_do_chain() and ipt_do_table() as a local variable which is not
+accessible from their return probes. Use 'nft monitor trace' with nftrace set
+by a rule to find the rule.`,
+		Args: cobra.RangeArgs(0, 1),
+	},
+	CommonVisitor: func(ctx *VisitorContext, cmd *cobra.Command, commonOpts *skbtrace.CommonOptions) {
+		opts := skbtrace.NetfilterOptions{
+			AggregateCommonOptions: skbtrace.AggregateCommonOptions{
+				Interval: time.Second,
+			},
+		}
+		var nfOpts netfilterOptions
+		PassCommonOptions(ctx, cmd, &opts.CommonOptions, commonOpts)
+
+		flags := cmd.Flags()
+		RegisterFilterOptions(flags, &opts.FilterOptions)
+		RegisterInterfaceOptions(ctx, cmd, &opts.FilterOptions)
+		RegisterNetnsOptions(ctx, cmd, &opts.FilterOptions)
+		RegisterCgroupOptions(ctx, cmd, &opts.FilterOptions)
+		RegisterCommonDumpOptions(flags, &opts.CommonDumpOptions)
+		RegisterAggregateCommonOptions(flags, &opts.AggregateCommonOptions)
+		RegisterTimeIntervalArg(ctx, cmd, &opts.Interval)
+		flags.BoolVar(&nfOpts.allHooks, "all-hooks", false,
+			"Trace summary verdict of the hook instead of per-table verdicts.")
+		flags.BoolVar(&nfOpts.legacy, "legacy", false,
+			"Trace tables of legacy iptables instead of nftables chains.")
+		flags.BoolVar(&opts.Aggregate, "aggregate", false,
+			"Aggregate verdicts instead of dumping them.")
+		flags.StringSliceVarP(&opts.Keys, "key", "k", nil,
+			"Keys verdicts are aggregated by. Default is table, chain and hook.")
+
+		ctx.AddPreRun(cmd, func(cmd *cobra.Command, args []string) error {
+			buildNetfilterOptions(&opts, &nfOpts)
+			return nil
+		})
+		cmd.Run = NewRun(ctx, func() (*skbtrace.Program, error) {
+			return ctx.Builder.BuildNetfilter(opts)
+		})
+	},
+}
+
+func buildNetfilterOptions(opts *skbtrace.NetfilterOptions, nfOpts *netfilterOptions) {
+	var rows, keys []string
+	switch {
+	case nfOpts.allHooks:
+		opts.ProbeNames = []string{proto.ProbeNfHookSlow}
+		rows, keys = nfRowsHook, nfKeysHook
+	case nfOpts.legacy:
+		opts.ProbeNames = []string{proto.ProbeIptDoTable}
+		rows, keys = nfRowsIptables, nfKeysIptables
+	default:
+		opts.ProbeNames = []string{proto.ProbeNftDoChain}
+		rows, keys = nfRowsNftables, nfKeysNftables
+	}
+
+	opts.VerdictField = proto.NfVerdictAlias
+	opts.FieldGroupRows = append(append([]string{}, rows...), opts.FieldGroupRows...)
+	if len(opts.Keys) == 0 {
+		opts.Keys = keys
+	}
+}
@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/yandex-cloud/skbtrace"
+	"github.com/yandex-cloud/skbtrace/pkg/skb"
+)
+
+const defaultOffloadInterval = 5 * time.Second
+
+var offloadKeysDefault = []string{"dev", "src", "dst", "sport", "dport"}
+
+var OffloadCommand = &CommandProducer{
+	Base: &cobra.Command{
+		Use:     "offload [-k KEYS] [INTERVAL]",
+		Example: "offload -i eth0 -p tcp 10s",
+		Short:   "Reports GRO merge and GSO split ratios per device and flow",
+		Long: `Counts skbs received and transmitted per device and flow along with number of
+wire segments they represent. SEGS/SKB column shows how many segments were
+merged by GRO in rx direction or will be split by GSO/TSO in tx direction.
+NO OFFLOAD column counts skbs which carry a single segment, SW GSO column counts
+skbs which were segmented in software instead of the device. GSO skbs whose
+number of segments is not yet computed by kernel, such as ones received from
+virtio or tap devices, are not counted.`,
+		Args: cobra.RangeArgs(0, 1),
+	},
+	CommonVisitor: func(ctx *VisitorContext, cmd *cobra.Command, commonOpts *skbtrace.CommonOptions) {
+		opts := skbtrace.OffloadOptions{Interval: defaultOffloadInterval}
+		PassCommonOptions(ctx, cmd, &opts.CommonOptions, commonOpts)
+
+		RegisterFilterOptions(cmd.Flags(), &opts.FilterOptions)
+		RegisterInterfaceOptions(ctx, cmd, &opts.FilterOptions)
+		RegisterNetnsOptions(ctx, cmd, &opts.FilterOptions)
+		RegisterCgroupOptions(ctx, cmd, &opts.FilterOptions)
+		RegisterTimeIntervalArg(ctx, cmd, &opts.Interval)
+		cmd.Flags().StringSliceVarP(&opts.Keys, "key", "k", offloadKeysDefault,
+			`Keys identifying the device and the flow. Use 'fields' subcommand to list available fields.`)
+
+		ctx.AddPreRun(cmd, func(cmd *cobra.Command, args []string) error {
+			buildOffloadOptions(&opts)
+			return nil
+		})
+
+		cmd.Run = NewRun(ctx, func() (*skbtrace.Program, error) {
+			return ctx.Builder.BuildOffload(opts)
+		})
+	},
+}
+
+func buildOffloadOptions(opts *skbtrace.OffloadOptions) {
+	opts.RxProbe = skb.ProbeRecvAlias
+	opts.TxProbe = skb.ProbeXmitAlias
+	opts.SwGsoProbe = skb.ProbeGsoSegmentAlias
+
+	opts.GsoSizeField = "$skbsi->gso_size"
+	opts.GsoSegsField = "$skbsi->gso_segs"
+}
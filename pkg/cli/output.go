@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slices"
+
+	"github.com/yandex-cloud/skbtrace"
+	"github.com/yandex-cloud/skbtrace/pkg/metrics"
+	"github.com/yandex-cloud/skbtrace/pkg/tui"
+)
+
+// AggregateOutputOptions select how aggregations printed by program on
+// interval are shown: as is, in a live view or exported as metrics if
+// command is run by serve command
+type AggregateOutputOptions struct {
+	TUI bool
+
+	ctx *VisitorContext
+}
+
+// aggregateInfo describes aggregation for output processors
+type aggregateInfo struct {
+	// Name of the metric the aggregation is exported as
+	Name string
+
+	Keys []string
+	Func skbtrace.AggrFunc
+
+	// Keys of auxiliary maps printed along with aggregation
+	MapKeys map[string][]string
+}
+
+func RegisterAggregateOutputOptions(
+	ctx *VisitorContext, cmd *cobra.Command, opts *skbtrace.AggregateCommonOptions,
+) *AggregateOutputOptions {
+	outOpts := &AggregateOutputOptions{ctx: ctx}
+	cmd.Flags().BoolVar(&outOpts.TUI, "tui", false,
+		`Show aggregation as a live table which can be paused ('p'), sorted ('s', 'r') `+
+			`and filtered by keys ('/'). Press 'q' to quit.`)
+
+	ctx.AddPreRun(cmd, func(cmd *cobra.Command, args []string) error {
+		switch {
+		case outOpts.TUI && ctx.exporter != nil:
+			return errors.New("live view cannot be used by serve command")
+		case outOpts.TUI:
+			opts.EndMarker = tui.EndMarker
+		case ctx.exporter != nil:
+			opts.EndMarker = metrics.EndMarker
+		}
+		return nil
+	})
+	return outOpts
+}
+
+// Wrap returns builder which sets output processor of the program if
+// live view or metrics are enabled. Aggregation info is evaluated after
+// options are parsed.
+func (outOpts *AggregateOutputOptions) Wrap(
+	builder CommandBuilder, getInfo func() aggregateInfo,
+) CommandBuilder {
+	return func() (*skbtrace.Program, error) {
+		prog, err := builder()
+		if err != nil {
+			return prog, err
+		}
+
+		info := getInfo()
+		switch {
+		case outOpts.TUI:
+			headers := make([]string, 0, len(info.Keys))
+			for _, key := range info.Keys {
+				headers = append(headers, strings.ToUpper(key))
+			}
+			prog.OutputProcessor = &tui.LiveView{KeyHeaders: headers}
+		case outOpts.ctx.exporter != nil:
+			name := info.Name
+			if outOpts.ctx.metricName != "" {
+				name = outOpts.ctx.metricName
+			}
+
+			isGauge := slices.Contains([]skbtrace.AggrFunc{
+				skbtrace.AFMin, skbtrace.AFMax, skbtrace.AFAvg}, info.Func)
+			outOpts.ctx.exporter.SetMetric(name, info.Keys, isGauge)
+			outOpts.ctx.exporter.SetMapLabels(info.MapKeys)
+			prog.OutputProcessor = outOpts.ctx.exporter
+		}
+		return prog, nil
+	}
+}
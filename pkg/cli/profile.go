@@ -0,0 +1,251 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/exp/slices"
+
+	"github.com/yandex-cloud/skbtrace"
+	"github.com/yandex-cloud/skbtrace/pkg/profile"
+)
+
+const (
+	profileCommandName    = "profile"
+	runProfileCommandName = "run"
+)
+
+// profileParamsValue is a repeated PARAM=VALUE flag. Unlike string-to-string
+// flags it doesn't split values on commas, so values may contain lists of keys
+type profileParamsValue struct {
+	params *map[string]string
+}
+
+func newProfileParamsValue(p *map[string]string) *profileParamsValue {
+	return &profileParamsValue{params: p}
+}
+
+func (v *profileParamsValue) Type() string { return "PARAM=VALUE" }
+func (v *profileParamsValue) String() string {
+	params := make([]string, 0, len(*v.params))
+	for param, value := range *v.params {
+		params = append(params, fmt.Sprintf("%s=%s", param, value))
+	}
+	slices.Sort(params)
+	return strings.Join(params, " ")
+}
+func (v *profileParamsValue) Set(s string) error {
+	param, value, ok := strings.Cut(s, "=")
+	if !ok || param == "" {
+		return fmt.Errorf("%s should be in PARAM=VALUE form", s)
+	}
+
+	if *v.params == nil {
+		*v.params = make(map[string]string)
+	}
+	(*v.params)[param] = value
+	return nil
+}
+
+var ProfileCommand = &CommandProducer{
+	Base: &cobra.Command{
+		Use:   profileCommandName,
+		Short: "Manages named profiles of skbtrace command lines",
+		Long: `Profiles are command lines of skbtrace saved under a name in a profile directory
+as YAML files. Arguments of the profile may contain placeholders in ${param} form
+which are substituted by 'run' command. Profile may provide default values for them.`,
+	},
+	CommonVisitor: func(ctx *VisitorContext, cmd *cobra.Command, opts *skbtrace.CommonOptions) {
+		// Profiles are processed without builder, so root setup is not needed
+		cmd.PreRunE = nil
+		RegisterProfileDirOptions(ctx, cmd.PersistentFlags())
+	},
+	Children: []*CommandProducer{
+		profileSaveCommand,
+		profileListCommand,
+	},
+}
+
+var profileSaveCommand = &CommandProducer{
+	Base: &cobra.Command{
+		Use: "save NAME [-d DESCRIPTION] [--default PARAM=VALUE]... -- ARGS...",
+		Example: "profile save syn -d 'Outgoing SYNs' --default itf=eth1 -- " +
+			"dump -P xmit -i '${itf}' -F 'tcp-flags == S' -F 'dst == ${dst}' -o ip -o tcp",
+		Short: "Saves command line as a named profile",
+		Args:  cobra.MinimumNArgs(2),
+	},
+	InfoVisitor: func(ctx *VisitorContext, cmd *cobra.Command) {
+		var p profile.Profile
+
+		flags := cmd.Flags()
+		flags.StringVarP(&p.Description, "description", "d", "",
+			"Description of the profile shown by 'profile list'")
+		flags.Var(newProfileParamsValue(&p.Defaults), "default",
+			"Default value of the profile parameter. Can be specified multiple times")
+
+		cmd.RunE = func(cmd *cobra.Command, args []string) error {
+			if cmd.ArgsLenAtDash() != 1 {
+				return errors.New("profile name should be followed by '--' and command line")
+			}
+
+			p.Name, p.Args = args[0], args[1:]
+			if err := ctx.validateProfileArgs(p.Args); err != nil {
+				return err
+			}
+			for param := range p.Defaults {
+				if !slices.Contains(p.Params(), param) {
+					return fmt.Errorf("default is set for unknown parameter '%s'", param)
+				}
+			}
+
+			return profile.Save(ctx.ProfileDir, &p)
+		}
+	},
+}
+
+var profileListCommand = &CommandProducer{
+	Base: &cobra.Command{
+		Use:   "list",
+		Short: "Shows list of saved profiles",
+		Args:  cobra.NoArgs,
+	},
+	InfoVisitor: func(ctx *VisitorContext, cmd *cobra.Command) {
+		cmd.RunE = func(cmd *cobra.Command, args []string) error {
+			profiles, err := profile.List(ctx.ProfileDir)
+			if err != nil {
+				return err
+			}
+
+			tw := tablewriter.NewWriter(ctx.Dependencies.Output())
+			tw.SetHeader([]string{"PROFILE", "PARAMS", "ARGS", "DESCRIPTION"})
+			tw.SetColWidth(80)
+			for _, p := range profiles {
+				var params []string
+				for _, param := range p.Params() {
+					if value, ok := p.Defaults[param]; ok {
+						param = fmt.Sprintf("%s=%s", param, value)
+					}
+					params = append(params, param)
+				}
+
+				tw.Append([]string{
+					p.Name, strings.Join(params, ", "), formatProfileArgs(p.Args), p.Description,
+				})
+			}
+			tw.Render()
+			return nil
+		}
+	},
+}
+
+var RunProfileCommand = &CommandProducer{
+	Base: &cobra.Command{
+		Use:     runProfileCommandName + " NAME [--set PARAM=VALUE]... [-- ARGS...]",
+		Example: "run syn --set dst=10.0.0.1",
+		Short:   "Runs command line saved in the named profile",
+		Long: `Runs command line saved in the named profile substituting its parameters with values
+set by --set or defaults of the profile. Extra arguments specified after '--' are
+appended to the command line, i.e. '-- --help' shows help of the profile command.
+Global options such as -D are passed to the profile command too.`,
+		Args: cobra.MinimumNArgs(1),
+	},
+	InfoVisitor: func(ctx *VisitorContext, cmd *cobra.Command) {
+		var values map[string]string
+
+		// Root setup is performed by the profile command
+		cmd.PreRunE = nil
+
+		flags := cmd.Flags()
+		RegisterProfileDirOptions(ctx, flags)
+		flags.Var(newProfileParamsValue(&values), "set",
+			"Value of the profile parameter. Can be specified multiple times")
+
+		cmd.RunE = func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 && cmd.ArgsLenAtDash() != 1 {
+				return errors.New("extra arguments should follow '--'")
+			}
+
+			p, err := profile.Load(ctx.ProfileDir, args[0])
+			if err != nil {
+				return err
+			}
+			profileArgs, err := p.Expand(values)
+			if err != nil {
+				return err
+			}
+
+			// Errors of the profile command are reported by the command itself
+			cmd.SilenceUsage = true
+			rootCmd := ctx.root.newRootCommand(ctx.Dependencies, ctx.inheritServe)
+			rootCmd.SilenceErrors = true
+			rootCmd.SetArgs(append(getRootFlagArgs(cmd), append(profileArgs, args[1:]...)...))
+			return rootCmd.Execute()
+		}
+	},
+}
+
+func RegisterProfileDirOptions(ctx *VisitorContext, flags *pflag.FlagSet) {
+	flags.StringVar(&ctx.ProfileDir, "profile-dir", profile.DefaultDir(),
+		"Directory where profiles are stored")
+}
+
+// validateProfileArgs checks that profile arguments refer to a command
+// which can be run from a profile
+func (ctx *VisitorContext) validateProfileArgs(args []string) error {
+	rootCmd := ctx.root.NewRootCommand(ctx.Dependencies)
+	cmd, _, err := rootCmd.Find(args)
+	if err != nil {
+		return err
+	}
+	if cmd == rootCmd || !cmd.Runnable() {
+		return errors.New("profile should specify a command to run")
+	}
+
+	for ; cmd.Parent() != rootCmd; cmd = cmd.Parent() {
+	}
+	if cmd.Name() == profileCommandName || cmd.Name() == runProfileCommandName {
+		return fmt.Errorf("command '%s' cannot be saved in a profile", cmd.Name())
+	}
+	return nil
+}
+
+// getRootFlagArgs returns global options which were set explicitly, so
+// they can be passed to the profile command
+func getRootFlagArgs(cmd *cobra.Command) []string {
+	return getFlagArgs(cmd.Root().PersistentFlags())
+}
+
+// getFlagArgs returns arguments for the options which were set explicitly
+// except for the skipped ones
+func getFlagArgs(flags *pflag.FlagSet, skipNames ...string) []string {
+	var args []string
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if !flag.Changed || slices.Contains(skipNames, flag.Name) {
+			return
+		}
+		if sliceValue, ok := flag.Value.(pflag.SliceValue); ok {
+			for _, value := range sliceValue.GetSlice() {
+				args = append(args, fmt.Sprintf("--%s=%s", flag.Name, value))
+			}
+			return
+		}
+		args = append(args, fmt.Sprintf("--%s=%s", flag.Name, flag.Value.String()))
+	})
+	return args
+}
+
+// formatProfileArgs joins arguments quoting ones which contain spaces
+func formatProfileArgs(args []string) string {
+	quotedArgs := make([]string, 0, len(args))
+	for _, arg := range args {
+		if strings.ContainsAny(arg, " '\"") {
+			arg = fmt.Sprintf("'%s'", strings.ReplaceAll(arg, "'", `'\''`))
+		}
+		quotedArgs = append(quotedArgs, arg)
+	}
+	return strings.Join(quotedArgs, " ")
+}
@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/yandex-cloud/skbtrace"
+	"github.com/yandex-cloud/skbtrace/pkg/skb"
+)
+
+const (
+	qdiscUntilDequeue = "dequeue"
+	qdiscUntilXmit    = "xmit"
+)
+
+type qdiscOptions struct {
+	filterOptions skbtrace.FilterOptions
+	until         string
+}
+
+var (
+	qdiscKeys           = []string{skb.SkbAddrAlias}
+	qdiscAggrKeysDeq    = []string{skb.DevNameAlias, skb.QdiscKindAlias, skb.QdiscHandleAlias}
+	qdiscAggrKeysXmit   = []string{skb.DevNameAlias}
+	qdiscDropKeys       = []string{skb.DevNameAlias, skb.QdiscKindAlias, skb.QdiscHandleAlias, skb.QdiscParentAlias}
+	qdiscDropFilters    = []string{"qdisc-verdict != SUCCESS"}
+	qdiscRequeueKeys    = []string{skb.DevNameAlias, skb.TxQueueAlias}
+	qdiscRequeueFilters = []string{fmt.Sprintf("xmit-rc == %d", skb.NetdevTxBusy)}
+
+	// __dev_queue_xmit() also returns negative errors and driver codes for
+	// devices without qdisc, so only qdisc drops are matched
+	qdiscDropKeysLegacy    = []string{skb.DevNameAlias}
+	qdiscDropFiltersLegacy = []string{"qdisc-verdict == DROP|CN"}
+)
+
+var QdiscCommand = &CommandProducer{
+	Base: &cobra.Command{
+		Use:     "qdisc [-i ITF] [--until dequeue|xmit] [OPTIONS]",
+		Example: "qdisc -i eth0 aggr -f avg 5s",
+		Short:   "Measures time packets spend in queueing discipline and counts its drops",
+		Long: `Measures sojourn time of packets from enqueue to qdisc until they are dequeued
+(or passed to the driver with --until xmit) keyed by sk buff address. Time is
+aggregated per device and qdisc. Packets dropped by qdisc are counted per
+device, qdisc and its parent class in @qdisc_drops, packets requeued because
+driver is busy are counted per device and transmit queue (which is a class of
+mq qdisc) in @qdisc_requeues. Counters are only reported when aggregating.
+
+On kernels before 5.16 which lack qdisc_enqueue tracepoint enqueue time is
+taken in __dev_queue_xmit() and drops are counted per device only. On kernels
+before 4.16 packets are traced until they are passed to the driver.`,
+	},
+	TimeVisitor: func(ctx *VisitorContext, cmd *cobra.Command, opts *skbtrace.TimeCommonOptions) {
+		var qOpts qdiscOptions
+
+		flags := cmd.PersistentFlags()
+		RegisterFilterOptions(flags, &qOpts.filterOptions)
+		RegisterInterfaceOptions(ctx, cmd, &qOpts.filterOptions)
+		RegisterNetnsOptions(ctx, cmd, &qOpts.filterOptions)
+		flags.StringVar(&qOpts.until, "until", "",
+			"Event which ends measurement: 'dequeue' from qdisc or 'xmit' by driver. "+
+				"Default is dequeue if kernel supports qdisc_dequeue feature.")
+
+		ctx.AddPreRun(cmd, func(runCmd *cobra.Command, args []string) error {
+			// Counters are printed only along with aggregations
+			withCounters := runCmd == cmd || runCmd.Name() == TimeAggregateCommand.Base.Name()
+
+			kernelFeatureMask := ctx.FeatureFlagMasks[skbtrace.FeatureComponentKernel]
+			return buildQdiscOptions(opts, &qOpts, kernelFeatureMask, withCounters)
+		})
+
+		cmd.Run = NewDefaultAggregateRun(ctx, opts)
+	},
+	Children: DefaultTimeSubcommands,
+}
+
+func buildQdiscOptions(
+	opts *skbtrace.TimeCommonOptions, qOpts *qdiscOptions,
+	kernelFeatureMask skbtrace.FeatureFlagMask, withCounters bool,
+) error {
+	until := qOpts.until
+	if until == "" {
+		until = qdiscUntilXmit
+		if kernelFeatureMask.Supports(skb.QdiscDequeueFeature) {
+			until = qdiscUntilDequeue
+		}
+	}
+
+	newSpec := func(probe string, keys []string, rawFilters ...string) skbtrace.TimeSpec {
+		return skbtrace.TimeSpec{
+			Probe: probe,
+			Keys:  keys,
+			FilterOptions: skbtrace.FilterOptions{
+				RawFilters: append(rawFilters, qOpts.filterOptions.RawFilters...),
+				Filters:    qOpts.filterOptions.Filters,
+			},
+		}
+	}
+
+	opts.FromSpec = newSpec(skb.ProbeQdiscEnqueue, qdiscKeys)
+	if !kernelFeatureMask.Supports(skb.QdiscEnqueueFeature) {
+		opts.FromSpec.Probe = skb.ProbeQdiscEnqueueLegacy
+	}
+
+	switch until {
+	case qdiscUntilDequeue:
+		if !kernelFeatureMask.Supports(skb.QdiscDequeueFeature) {
+			return fmt.Errorf("kernel doesn't support %s feature", skb.QdiscDequeueFeature.Name)
+		}
+		opts.ToSpec = newSpec(skb.ProbeQdiscDequeue, qdiscKeys)
+		opts.AggrKeys = qdiscAggrKeysDeq
+	case qdiscUntilXmit:
+		opts.ToSpec = newSpec(skb.ProbeDevStartXmit, qdiscKeys)
+		opts.AggrKeys = qdiscAggrKeysXmit
+	default:
+		return fmt.Errorf("unknown event '%s', expected dequeue or xmit", until)
+	}
+
+	if !withCounters {
+		return nil
+	}
+
+	dropSpec := newSpec(skb.ProbeQdiscEnqueueRet, qdiscDropKeys, qdiscDropFilters...)
+	if !kernelFeatureMask.Supports(skb.QdiscEnqueueFeature) {
+		dropSpec = newSpec(skb.ProbeQdiscEnqueueRetLegacy, qdiscDropKeysLegacy, qdiscDropFiltersLegacy...)
+	}
+	// Requeued skbs are not freed by driver, so their device is accessible
+	opts.Counters = []skbtrace.TimeCounterSpec{
+		{Name: "qdisc_requeues",
+			TimeSpec: newSpec(skb.ProbeDevXmit, qdiscRequeueKeys, qdiscRequeueFilters...)},
+		{Name: "qdisc_drops", TimeSpec: dropSpec},
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package cli
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/yandex-cloud/skbtrace"
+	"github.com/yandex-cloud/skbtrace/pkg/metrics"
+)
+
+const recordFlagName = "record"
+
+var ReplayCommand = &CommandProducer{
+	Base: &cobra.Command{
+		Use:     "replay FILE [--metrics]",
+		Example: "replay qdisc.rec --metrics",
+		Short:   "Processes output of bpftrace saved by --record option",
+		Long: `Runs command line saved in the recording, but instead of running bpftrace passes
+its recorded output to the post-processing such as tables or live view. Versions
+of bpftrace and kernel are taken from the recording, so it can be replayed on
+any host. Global options override the recorded ones, i.e. -D shows the script.`,
+		Args: cobra.ExactArgs(1),
+	},
+	InfoVisitor: func(ctx *VisitorContext, cmd *cobra.Command) {
+		var printMetrics bool
+
+		// Root setup is performed by the replayed command
+		cmd.PreRunE = nil
+
+		cmd.Flags().BoolVar(&printMetrics, "metrics", false,
+			"Print aggregations as metrics after replaying them as they would be served by 'serve' command")
+
+		cmd.RunE = func(cmd *cobra.Command, args []string) error {
+			rec, err := skbtrace.ReadRecording(args[0])
+			if err != nil {
+				return err
+			}
+
+			var exporter *metrics.Exporter
+			if printMetrics {
+				exporter = &metrics.Exporter{Namespace: metricsNamespace}
+			}
+
+			// Replayed command exits after processing, so metrics are printed
+			// when it exits
+			deps := &replayDependencies{Dependencies: ctx.Dependencies}
+			cmd.SilenceUsage = true
+			rootCmd := ctx.root.newRootCommand(deps, func(replayCtx *VisitorContext) {
+				ctx.inheritServe(replayCtx)
+				if exporter != nil {
+					replayCtx.exporter = exporter
+				}
+				replayCtx.RunnerOptions.Replay = bytes.NewReader(rec.Output)
+			})
+			rootCmd.SilenceErrors = true
+			rootCmd.SetArgs(append(append(getReplayVersionArgs(rec), rec.Args...), getRootFlagArgs(cmd)...))
+			if err := rootCmd.Execute(); err != nil {
+				return err
+			}
+
+			if exporter != nil && deps.exitCode != 2 {
+				exporter.Write(ctx.Dependencies.Output())
+			}
+			if deps.exitCode != 0 {
+				ctx.Dependencies.Exit(deps.exitCode)
+			}
+			return nil
+		}
+	},
+}
+
+// replayDependencies defers exit of the replayed command
+type replayDependencies struct {
+	Dependencies
+
+	exitCode int
+}
+
+func (deps *replayDependencies) Exit(code int) {
+	deps.exitCode = code
+}
+
+// getReplayVersionArgs returns options which set versions of the feature
+// components detected when recording unless they're set explicitly
+func getReplayVersionArgs(rec *skbtrace.Recording) []string {
+	var args []string
+	for name, version := range rec.Versions {
+		versionFlag := fmt.Sprintf("--%s-version", name)
+		hasFlag := false
+		for _, arg := range rec.Args {
+			if arg == versionFlag || strings.HasPrefix(arg, versionFlag+"=") {
+				hasFlag = true
+			}
+		}
+		if !hasFlag {
+			args = append(args, fmt.Sprintf("%s=%s", versionFlag, version))
+		}
+	}
+	return args
+}
+
+// newRecording creates recording for the command which is being run
+func (ctx *VisitorContext) newRecording(cmd *cobra.Command, args []string) *skbtrace.Recording {
+	rec := &skbtrace.Recording{
+		Versions: make(map[string]string),
+		Options:  make(map[string]string),
+	}
+
+	for name, spec := range ctx.Dependencies.FeatureComponents() {
+		version := ctx.featureVerArgs[spec.Component]
+		if version == "" {
+			out, err := spec.Provider.Get()
+			if err != nil {
+				continue
+			}
+			version = strings.TrimSpace(string(out))
+		}
+		rec.Versions[name] = version
+	}
+
+	// Command line is rebuilt from the options set explicitly, as cobra
+	// doesn't keep original arguments
+	var path []*cobra.Command
+	for c := cmd; c.HasParent(); c = c.Parent() {
+		path = append([]*cobra.Command{c}, path...)
+	}
+
+	rootFlags := cmd.Root().PersistentFlags()
+	rec.Args = getFlagArgs(rootFlags, recordFlagName)
+	for _, c := range path {
+		rec.Args = append(rec.Args, c.Name())
+		rec.Args = append(rec.Args, getFlagArgs(c.LocalFlags())...)
+	}
+	rec.Args = append(rec.Args, args...)
+
+	for _, flags := range append([]*pflag.FlagSet{rootFlags}, getLocalFlagSets(path)...) {
+		flags.VisitAll(func(flag *pflag.Flag) {
+			if flag.Changed && flag.Name != recordFlagName {
+				rec.Options[flag.Name] = flag.Value.String()
+			}
+		})
+	}
+	if unitFlag := rootFlags.Lookup("unit"); unitFlag != nil {
+		rec.Options[unitFlag.Name] = unitFlag.Value.String()
+	}
+	return rec
+}
+
+func getLocalFlagSets(cmds []*cobra.Command) []*pflag.FlagSet {
+	flagSets := make([]*pflag.FlagSet, 0, len(cmds))
+	for _, cmd := range cmds {
+		flagSets = append(flagSets, cmd.LocalFlags())
+	}
+	return flagSets
+}
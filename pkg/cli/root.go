@@ -1,7 +1,7 @@
 package cli
 
 import (
-	"os"
+	"fmt"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/cobra/doc"
@@ -15,9 +15,11 @@ Two most common probes in skbtrace are 'recv' and 'xmit' which fire on receiving
 the sk buffer respectively. Probes are specified using option -P, but some commands do so implicitly.
 List of available probes can be printed with 'probes' command. 
 
-skbtrace doesn't determine which protocols are encapsulated in the packet and instead requires 
-hints to be specified, so only one type of the packet can be traced at a time. Hints include 
-overlay encapsulation type (-e), IP version (-6) and transport protocol type (such as -p tcp).
+skbtrace determines overlay encapsulation type from outer IP protocol at runtime. Weak field aliases 
+which are accessible from multiple headers, such as 'sport', are resolved from dump rows, other filters 
+and hints, or picked at runtime based on protocol number if that's not possible. Hints allow to override 
+that and include overlay encapsulation type (-e), IP version (-6) and transport protocol type (such 
+as -p tcp). Note that IP version is never detected automatically.
 
 Probe firings can be limited using filters in format '-F 'field == value'' with field being either 
 an alias, fields without object such as global variable 'comm' or a full $obj->field notation. 
@@ -33,7 +35,7 @@ List of available dump rows and field meanings can be printed with 'fields' comm
 
 Time commands which map one event to another require lists of keys using in such mapping. Keys use same
 syntax for fields as in filters. For example, '-k src,dst,sport,dport' will map packets having same five
-tuple assuming that there is '-p tcp' or '-p udp' is supplied to determine the protocol.
+tuple of either TCP or UDP packets unless '-p tcp' or '-p udp' is supplied to pick the protocol.
 `
 
 var RootCommand = CommandProducer{
@@ -45,22 +47,45 @@ var RootCommand = CommandProducer{
 	Children: []*CommandProducer{
 		CommonDumpTracerCommand,
 		CommonAggregateCommand,
+		FlowsCommand,
+		OffloadCommand,
+		NetfilterCommand,
 		CommonTimeItFromCommand,
 		CommonDuplicateCommand,
+		TcpCommand,
 		ProbesCommand,
 		FieldsCommand,
 		FeaturesCommand,
+		ProfileCommand,
+		RunProfileCommand,
+		ServeCommand,
+		ReplayCommand,
 	},
 }
 
-func addHiddenCommands(rootCmd *cobra.Command) {
+func addHiddenCommands(rootCmd *cobra.Command, deps Dependencies) {
 	completionCommand := &cobra.Command{
-		Use:    "completion",
-		Short:  "Generates bash completion scripts",
-		Hidden: true,
+		Use:       "completion [bash|zsh|fish]",
+		Short:     "Generates shell completion scripts",
+		Hidden:    true,
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: []string{"bash", "zsh", "fish"},
 
-		Run: func(cmd *cobra.Command, args []string) {
-			rootCmd.GenBashCompletion(os.Stdout)
+		RunE: func(cmd *cobra.Command, args []string) error {
+			shell := "bash"
+			if len(args) > 0 {
+				shell = args[0]
+			}
+
+			switch shell {
+			case "bash":
+				return rootCmd.GenBashCompletion(deps.Output())
+			case "zsh":
+				return rootCmd.GenZshCompletion(deps.Output())
+			case "fish":
+				return rootCmd.GenFishCompletion(deps.Output(), true)
+			}
+			return fmt.Errorf("unsupported shell '%s'", shell)
 		},
 	}
 
@@ -35,8 +35,18 @@ func NewRun(ctx *VisitorContext, builder CommandBuilder) CommandRunFunc {
 			ctx.Dependencies.Exit(2)
 			return
 		}
+		if ctx.exporter != nil && prog.OutputProcessor != ctx.exporter {
+			fmt.Fprintln(ctx.Dependencies.ErrorOutput(), errServeNotSupported)
+			ctx.Dependencies.Exit(2)
+			return
+		}
+
+		runnerOpts := ctx.RunnerOptions
+		if runnerOpts.RecordPath != "" {
+			runnerOpts.Recording = ctx.newRecording(cmd, args)
+		}
 
-		err = skbtrace.Run(ctx.Dependencies.Output(), prog, ctx.RunnerOptions)
+		err = skbtrace.Run(ctx.Dependencies.Output(), prog, runnerOpts)
 		if err != nil {
 			fmt.Fprintln(ctx.Dependencies.ErrorOutput(), err)
 		}
@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yandex-cloud/skbtrace/pkg/metrics"
+)
+
+const (
+	defaultListenAddr = ":9464"
+	metricsNamespace  = "skbtrace"
+	metricsPath       = "/metrics"
+)
+
+var errServeNotSupported = errors.New(
+	"command cannot be served, use 'aggregate' or 'timeit ... aggregate' commands")
+
+var ServeCommand = &CommandProducer{
+	Base: &cobra.Command{
+		Use:     "serve [--listen ADDR] [--metric NAME] -- COMMAND...",
+		Example: "serve --listen :9464 -- timeit qdisc -i eth0 aggr",
+		Short:   "Exports aggregations of the command as Prometheus metrics",
+		Long: `Runs aggregate or timeit aggregate command continuously and serves aggregations
+printed on each interval as metrics on /metrics endpoint. Counts and sums are
+accumulated into counters, histograms are exported as histograms and other
+functions such as avg are exported as gauges. Labels are named after keys of the
+aggregation. Auxiliary counters, such as drops counted by 'timeit qdisc', are
+exported as counters. Command may also be a profile started by 'run' command.
+
+Unless --timeout is specified, command runs until skbtrace is interrupted.`,
+		Args: cobra.MinimumNArgs(1),
+	},
+	InfoVisitor: func(ctx *VisitorContext, cmd *cobra.Command) {
+		var listenAddr, metricName string
+
+		// Root setup is performed by the served command
+		cmd.PreRunE = nil
+
+		flags := cmd.Flags()
+		flags.StringVar(&listenAddr, "listen", defaultListenAddr,
+			"Address metrics endpoint listens on")
+		flags.StringVar(&metricName, "metric", "",
+			"Name of the metric of the aggregation, derived from the command by default")
+
+		cmd.RunE = func(cmd *cobra.Command, args []string) error {
+			if cmd.ArgsLenAtDash() != 0 {
+				return errors.New("served command should follow '--'")
+			}
+
+			listener, err := net.Listen("tcp", listenAddr)
+			if err != nil {
+				return err
+			}
+
+			exporter := &metrics.Exporter{Namespace: metricsNamespace}
+			mux := http.NewServeMux()
+			mux.Handle(metricsPath, exporter)
+			server := &http.Server{Handler: mux}
+			go server.Serve(listener)
+			defer server.Close()
+
+			if !ctx.RunnerOptions.DumpScript {
+				fmt.Fprintf(ctx.Dependencies.ErrorOutput(), "Serving metrics on http://%s%s\n",
+					listener.Addr(), metricsPath)
+			}
+
+			rootArgs := getRootFlagArgs(cmd)
+			if !cmd.Root().PersistentFlags().Changed("timeout") {
+				rootArgs = append(rootArgs, "--timeout=0")
+			}
+
+			// Errors of the served command are reported by the command itself
+			cmd.SilenceUsage = true
+			rootCmd := ctx.root.newRootCommand(ctx.Dependencies, func(servedCtx *VisitorContext) {
+				servedCtx.exporter = exporter
+				servedCtx.metricName = metricName
+			})
+			rootCmd.SilenceErrors = true
+			rootCmd.SetArgs(append(rootArgs, args...))
+			return rootCmd.Execute()
+		}
+	},
+}
+
+// inheritServe passes exporter to the command nested into the command which
+// is run by serve command, such as profile command
+func (ctx *VisitorContext) inheritServe(nestedCtx *VisitorContext) {
+	nestedCtx.exporter = ctx.exporter
+	nestedCtx.metricName = ctx.metricName
+}
@@ -2,11 +2,15 @@ package cli
 
 import (
 	"errors"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/yandex-cloud/skbtrace"
+	"github.com/yandex-cloud/skbtrace/pkg/proto"
 	"github.com/yandex-cloud/skbtrace/pkg/skb"
+	"golang.org/x/exp/slices"
 )
 
 type tcpOptions struct {
@@ -18,8 +22,21 @@ type tcpOptions struct {
 var (
 	tcpKeysBase            = []string{"sport", "dport"}
 	tcpExtraKeysRetransmit = []string{"seq", "ack"}
+
+	tcpStateKeys = []string{proto.SockSrcAlias, proto.SockDstAlias, proto.SockSportAlias, proto.SockDportAlias}
 )
 
+// Packet aliases which are rewritten to socket aliases in socket-level commands
+var sockAliases = map[string]string{
+	"src":   proto.SockSrcAlias,
+	"dst":   proto.SockDstAlias,
+	"sport": proto.SockSportAlias,
+	"dport": proto.SockDportAlias,
+}
+
+// Row printed by tcp states dump as it contains 4-tuple of the connection
+const tcpStatesRow = "sock"
+
 var BaseTcpCommand = &CommandProducer{
 	Base: &cobra.Command{
 		Use:   "tcp",
@@ -28,11 +45,13 @@ var BaseTcpCommand = &CommandProducer{
 	TimeVisitor: func(ctx *VisitorContext, cmd *cobra.Command, commonOpts *skbtrace.TimeCommonOptions) {
 		var opts tcpOptions
 		RegisterInterfaceOptions(ctx, cmd, &opts.filterOpts)
+		RegisterNetnsOptions(ctx, cmd, &opts.filterOpts)
+		RegisterCgroupOptions(ctx, cmd, &opts.filterOpts)
 		registerTcpOptions(cmd.PersistentFlags(), &opts)
 
 		ctx.AddPreRun(cmd, func(cmd *cobra.Command, args []string) error {
 			var extraKeys []string
-			if cmd.Use == "retransmit" {
+			if cmd.Name() == "retransmit" {
 				extraKeys = tcpExtraKeysRetransmit
 			}
 			return buildTcpTimeOptions(&opts, commonOpts, extraKeys)
@@ -45,6 +64,56 @@ var BaseTcpCommand = &CommandProducer{
 	},
 }
 
+var TcpCommand = &CommandProducer{
+	Base: &cobra.Command{
+		Use:   "tcp",
+		Short: "Traces TCP socket events",
+	},
+	Children: []*CommandProducer{
+		TcpStatesCommand,
+	},
+}
+
+var TcpStatesCommand = &CommandProducer{
+	Base: &cobra.Command{
+		Use:     "states [-o ROWS] [--aggregate [-f FUNC]] [INTERVAL]",
+		Example: "states -F 'dport == 443' --aggregate -f hist",
+		Short:   "Traces TCP state transitions along with time spent in the previous state",
+		Long: `Traces TCP state transitions of the connections identified by 4-tuple.
+Packet aliases src, dst, sport and dport in filters refer to the corresponding
+socket addresses and ports. By default transitions are dumped along with sock
+row containing 4-tuple, but they can be aggregated by the pair of old and new
+states using --aggregate.`,
+		Args: cobra.RangeArgs(0, 1),
+	},
+	CommonVisitor: func(ctx *VisitorContext, cmd *cobra.Command, commonOpts *skbtrace.CommonOptions) {
+		opts := skbtrace.StateTransitionOptions{
+			AggregateCommonOptions: skbtrace.AggregateCommonOptions{
+				Interval: time.Second,
+			},
+			Func: skbtrace.AFCount,
+		}
+		PassCommonOptions(ctx, cmd, &opts.CommonOptions, commonOpts)
+
+		flags := cmd.Flags()
+		RegisterFilterOptions(flags, &opts.Spec.FilterOptions)
+		RegisterCommonDumpOptions(flags, &opts.CommonDumpOptions)
+		RegisterAggregateCommonOptions(flags, &opts.AggregateCommonOptions)
+		RegisterTimeIntervalArg(ctx, cmd, &opts.Interval)
+		flags.BoolVar(&opts.Aggregate, "aggregate", false,
+			"Aggregate transitions instead of dumping them.")
+		flags.VarPF(&aggrFuncValue{&opts.Func}, "func", "f",
+			"Aggregation function applied to time spent in the old state. Default is count.")
+
+		ctx.AddPreRun(cmd, func(cmd *cobra.Command, args []string) error {
+			return buildTcpStatesOptions(&opts)
+		})
+		cmd.Run = NewRun(ctx, func() (*skbtrace.Program, error) {
+			return ctx.Builder.BuildStateTransitions(opts)
+		})
+	},
+}
+
 var TcpHandshakeCommand = &CommandProducer{
 	Base: &cobra.Command{
 		Use:     "handshake {--inbound|--outbound} [--underlay] -i ITF",
@@ -81,12 +150,15 @@ var TcpLifetimeCommand = &CommandProducer{
 
 var TcpRetransmitsCommand = &CommandProducer{
 	Base: &cobra.Command{
-		Use:   "retransmit",
+		Use:   "retransmit [--window WINDOW] [--aggregate] [INTERVAL]",
 		Short: "Detects duplicate packets",
+		Args:  cobra.RangeArgs(0, 1),
 	},
 	TimeVisitor: func(ctx *VisitorContext, cmd *cobra.Command, commonOpts *skbtrace.TimeCommonOptions) {
-		var opts skbtrace.DuplicateEventOptions
+		opts := newDuplicateEventOptions()
 		RegisterCommonDumpOptions(cmd.Flags(), &opts.CommonDumpOptions)
+		RegisterPreTriggerOptions(cmd.Flags(), &opts.PreTriggerOptions)
+		RegisterDuplicateOptions(ctx, cmd, &opts)
 
 		ctx.AddPreRun(cmd, func(cmd *cobra.Command, args []string) error {
 			opts.CommonOptions = commonOpts.CommonOptions
@@ -147,3 +219,46 @@ func registerTcpOptions(flags *pflag.FlagSet, opts *tcpOptions) {
 		"Capture TCP in underlay interface.")
 	registerDirectionFlags(flags, &opts.direction)
 }
+
+func buildTcpStatesOptions(opts *skbtrace.StateTransitionOptions) error {
+	opts.Spec.Probe = proto.ProbeTcpSetState
+	opts.Spec.Keys = tcpStateKeys
+	if err := wrapSock(&opts.Spec.FilterOptions); err != nil {
+		return err
+	}
+
+	opts.OldState = proto.SockStateAlias
+	opts.NewState = proto.TcpNewStateField
+	opts.StateNames = proto.TcpStates
+	opts.FinalFilterOptions.RawFilters = []string{proto.TcpNewStateField + " == CLOSE"}
+
+	if !opts.Aggregate && !slices.Contains(opts.FieldGroupRows, tcpStatesRow) {
+		opts.FieldGroupRows = append([]string{tcpStatesRow}, opts.FieldGroupRows...)
+	}
+	return nil
+}
+
+// wrapSock parses raw filters and replaces packet aliases in them with
+// the socket aliases
+func wrapSock(opts *skbtrace.FilterOptions) error {
+	for _, rawFilter := range opts.RawFilters {
+		filter, err := skbtrace.ParseFilter(rawFilter)
+		if err != nil {
+			return err
+		}
+
+		if filter.Field == "" {
+			aliases := strings.Split(filter.Object, "|")
+			for i, alias := range aliases {
+				if sockAlias, ok := sockAliases[alias]; ok {
+					aliases[i] = sockAlias
+				}
+			}
+			filter.Object = strings.Join(aliases, "|")
+		}
+		opts.Filters = append(opts.Filters, filter)
+	}
+
+	opts.RawFilters = nil
+	return nil
+}
@@ -0,0 +1,18 @@
+package clitesting
+
+import (
+	"testing"
+)
+
+func TestCompletionTest(t *testing.T) {
+	for _, args := range [][]string{
+		{"__complete", "dump", "-P", "tcp-"},
+		{"__complete", "dump", "-o", "inner-"},
+		{"__complete", "aggr", "-P", "xmit", "-k", "src,sk-d"},
+
+		// Object fields depend on the IP version
+		{"__complete", "dump", "-6", "-F", "$ipv6h->"},
+	} {
+		RunCommandTest(t, args)
+	}
+}
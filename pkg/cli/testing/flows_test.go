@@ -0,0 +1,17 @@
+package clitesting
+
+import (
+	"testing"
+)
+
+func TestFlowsTest(t *testing.T) {
+	for _, args := range [][]string{
+		// Outer TCP flows
+		{"flows", "-P", "recv", "-p", "tcp", "10s"},
+
+		// Inner UDP flows over IPv6
+		{"flows", "-P", "xmit", "-6", "-p", "udp", "--inner"},
+	} {
+		RunCommandTest(t, args)
+	}
+}
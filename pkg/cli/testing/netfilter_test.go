@@ -0,0 +1,23 @@
+package clitesting
+
+import (
+	"testing"
+)
+
+func TestNetfilterTest(t *testing.T) {
+	for _, args := range [][]string{
+		// Dropped packets along with their IP headers
+		{"netfilter", "-F", "nf-verdict == DROP", "-o", "ip"},
+
+		// Verdicts aggregated by chain on a single interface
+		{"netfilter", "--aggregate", "-i", "eth0", "5s"},
+
+		// Dropped packets in tables of legacy iptables
+		{"netfilter", "--legacy", "-F", "nf-verdict == DROP"},
+
+		// Summary verdicts of hooks
+		{"netfilter", "--all-hooks", "-p", "tcp"},
+	} {
+		RunCommandTest(t, args)
+	}
+}
@@ -0,0 +1,17 @@
+package clitesting
+
+import (
+	"testing"
+)
+
+func TestOffloadTest(t *testing.T) {
+	for _, args := range [][]string{
+		// Default keys on a single interface
+		{"offload", "-i", "eth0", "-p", "tcp", "10s"},
+
+		// Per-device counters only
+		{"offload", "-k", "dev"},
+	} {
+		RunCommandTest(t, args)
+	}
+}
@@ -0,0 +1,23 @@
+package clitesting
+
+import (
+	"testing"
+)
+
+func TestProfileTest(t *testing.T) {
+	for _, args := range [][]string{
+		{"profile", "list", "--profile-dir", "testdata/profiles"},
+
+		// Parameter substitution with defaults
+		{"run", "--profile-dir", "testdata/profiles", "syn", "--set", "dst=10.0.0.1"},
+
+		// Extra arguments are passed to the profile command
+		{"run", "--profile-dir", "testdata/profiles", "syn", "--set", "dst=10.0.0.1", "--set", "itf=eth0",
+			"--", "--max-events", "10"},
+
+		// Values may contain commas
+		{"run", "--profile-dir", "testdata/profiles", "aggr", "--set", "keys=src,dst,id"},
+	} {
+		RunCommandTest(t, args)
+	}
+}
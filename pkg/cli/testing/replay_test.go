@@ -0,0 +1,45 @@
+package clitesting
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yandex-cloud/skbtrace"
+)
+
+func TestReplayTest(t *testing.T) {
+	for _, args := range [][]string{
+		// Script is regenerated for versions passed on command line
+		{"replay", "testdata/recordings/qdisc.rec"},
+		{"replay", "testdata/recordings/aggr.rec"},
+	} {
+		RunCommandTest(t, args)
+	}
+}
+
+func TestReplayOutputTest(t *testing.T) {
+	t.Run("Output", func(t *testing.T) {
+		rec, err := skbtrace.ReadRecording("testdata/recordings/aggr.rec")
+		require.NoError(t, err)
+
+		buf, err := executeCommand([]string{"replay", "testdata/recordings/aggr.rec"})
+		require.NoError(t, err)
+		assert.Equal(t, string(rec.Output), buf.String())
+	})
+
+	t.Run("Metrics", func(t *testing.T) {
+		buf, err := executeCommand([]string{"replay", "testdata/recordings/qdisc.rec", "--metrics"})
+		require.NoError(t, err)
+		assert.Contains(t, buf.String(), "Attaching 6 probes...\n")
+		assert.Contains(t, buf.String(), `skbtrace_qdisc_requeues_total{dev="eth0",txq="0"} 5`)
+		assert.Contains(t, buf.String(), `skbtrace_timeit_us_bucket{dev="eth0",le="3"} 63`)
+		assert.Contains(t, buf.String(), `skbtrace_timeit_us_count{dev="eth0"} 103`)
+	})
+
+	t.Run("NotRecording", func(t *testing.T) {
+		_, err := executeCommand([]string{"replay", "testdata/profiles/syn.yaml"})
+		assert.ErrorContains(t, err, "is not a skbtrace recording")
+	})
+}
@@ -0,0 +1,19 @@
+package clitesting
+
+import (
+	"testing"
+)
+
+func TestServeTest(t *testing.T) {
+	for _, args := range [][]string{
+		// Served commands print end marker and run without timeout
+		{"serve", "--listen", "127.0.0.1:0", "--", "timeit", "qdisc", "-i", "eth0", "aggr"},
+		{"serve", "--listen", "127.0.0.1:0", "--", "aggr", "-P", "xmit", "-k", "src", "-f", "min", "-a", "$iph->ttl"},
+
+		// Only aggregations can be served, profile with dump is rejected
+		{"serve", "--listen", "127.0.0.1:0", "--",
+			"run", "--profile-dir", "testdata/profiles", "syn", "--set", "dst=10.0.0.1"},
+	} {
+		RunCommandTest(t, args)
+	}
+}
@@ -0,0 +1,157 @@
+package clitesting
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/yandex-cloud/skbtrace/pkg/bpfsim"
+	"github.com/yandex-cloud/skbtrace/pkg/skb"
+)
+
+// Delay between receiving packet and transmitting it, so timeit reports
+// it in [2K, 4K) us bucket
+const simulateXmitDelay = 3 * time.Millisecond
+
+type simulateTest struct {
+	name string
+	args []string
+
+	// pcap is the file in testdata/pcap with simulated packets, packets.pcap
+	// is used by default
+	pcap string
+
+	// events produces events from packets for the test group as newer
+	// kernels pass sk_buff** to recv probe
+	events func(g *testGroup, packets []*bpfsim.Packet) []bpfsim.Event
+
+	contains    []string
+	notContains []string
+
+	// count maps lines to the number of times they are expected
+	count map[string]int
+}
+
+func xmitEvents(g *testGroup, packets []*bpfsim.Packet) []bpfsim.Event {
+	return bpfsim.PacketEvents(skb.ProbeXmit,
+		map[string]bpfsim.Arg{"arg0": bpfsim.SkbArg}, 0, packets)
+}
+
+func forwardEvents(g *testGroup, packets []*bpfsim.Packet) []bpfsim.Event {
+	recvArg := bpfsim.SkbRefArg
+	if g.name == "base" {
+		recvArg = bpfsim.SkbArg
+	}
+
+	events := bpfsim.PacketEvents(skb.ProbeRecv,
+		map[string]bpfsim.Arg{"arg0": recvArg}, 0, packets)
+	return append(events, bpfsim.PacketEvents(skb.ProbeXmit,
+		map[string]bpfsim.Arg{"arg0": bpfsim.SkbArg}, simulateXmitDelay, packets)...)
+}
+
+func TestSimulateTest(t *testing.T) {
+	for _, test := range []simulateTest{
+		{
+			name:   "DumpFilter",
+			args:   []string{"dump", "-P", "xmit", "-o", "ip", "-o", "tcp", "-F", "src == 10.0.0.1"},
+			events: xmitEvents,
+			contains: []string{
+				"12:00:00.100000000 - kprobe:dev_queue_xmit\n",
+				"IP: id 1 ttl 64 protocol 6 saddr 10.0.0.1 daddr 10.0.0.2\n",
+				"IP: id 4 ttl 64 protocol 1 saddr 10.0.0.1 daddr 10.0.0.2\n",
+				"@hits[xmit:filtered]: 3\n",
+			},
+			notContains: []string{"saddr 10.0.0.2", "saddr 10.0.0.3"},
+			count:       map[string]int{"TCP: flags S----": 1},
+		},
+		{
+			// Inner headers are at the same offset in both packets, but only
+			// the one sent to MPLSoUDP port is encapsulated
+			name:        "DumpInnerAutoEncap",
+			args:        []string{"dump", "-P", "xmit", "-o", "inner-ip", "-e", "auto"},
+			pcap:        "encap.pcap",
+			events:      xmitEvents,
+			contains:    []string{"INNER-IP: id 12 ttl 64 protocol 6 saddr 10.1.0.1 daddr 10.1.0.2\n"},
+			notContains: []string{"INNER-IP: id 11"},
+			count:       map[string]int{"kprobe:dev_queue_xmit": 1},
+		},
+		{
+			name:   "DumpNetdev",
+			args:   []string{"dump", "-P", "xmit", "-o", "netdev", "-F", "$iph->protocol == 1"},
+			events: xmitEvents,
+			contains: []string{
+				"NETDEV: name eth0 mtu 1500 state 0 features 0\n",
+				"NETDEV: netns 4026531840\n",
+			},
+			count: map[string]int{"NETDEV: netns": 1},
+		},
+		{
+			name:   "Aggregate",
+			args:   []string{"aggr", "-P", "xmit", "-k", "src,dst"},
+			events: xmitEvents,
+			contains: []string{
+				"12:00:01\n@[10.0.0.2, 10.0.0.1]: 1\n@[10.0.0.1, 10.0.0.2]: 2\n",
+				"12:00:02\n@[10.0.0.3, 10.0.0.2]: 1\n",
+			},
+		},
+		{
+			name:     "AggregateTcpOptions",
+			args:     []string{"aggr", "-P", "xmit", "-k", "src,mss", "-F", "tcp-flags == S"},
+			events:   xmitEvents,
+			contains: []string{"@[10.0.0.1, 1460]: 1\n", "@hits[xmit:filtered]: 1\n"},
+		},
+		{
+			name: "TimeItAggregate",
+			args: []string{"timeit",
+				"from", "-P", "recv", "-k", "src,dst,id",
+				"to", "-P", "xmit", "aggr"},
+			events: forwardEvents,
+			contains: []string{
+				"12:00:01\n@:\n[2K, 4K)               3 |",
+				"12:00:02\n@:\n[2K, 4K)               1 |",
+			},
+		},
+		{
+			name: "TimeItOutliers",
+			args: []string{"timeit",
+				"from", "-P", "recv", "-k", "src,dst,id",
+				"to", "-P", "xmit", "outliers", "-t", "1ms", "-o", "ip"},
+			events: forwardEvents,
+			contains: []string{
+				"TIME: 3000 us\n12:00:00.103000000 - kprobe:dev_queue_xmit\n",
+			},
+			count: map[string]int{"TIME: 3000 us": 5},
+		},
+	} {
+		pcap := test.pcap
+		if pcap == "" {
+			pcap = "packets.pcap"
+		}
+		packets, err := bpfsim.ReadPcapFile("testdata/pcap/" + pcap)
+		require.NoError(t, err)
+
+		for _, g := range testGroups {
+			g := g
+			t.Run(g.name+"/"+test.name, func(t *testing.T) {
+				buf, err := simulateCommand(g.versionArgs(test.args),
+					&bpfsim.Simulator{Events: test.events(&g, packets)})
+				require.NoError(t, err)
+
+				output := buf.String()
+				require.NotContains(t, output, "bpfsim:")
+				for _, s := range test.contains {
+					assert.Contains(t, output, s)
+				}
+				for _, s := range test.notContains {
+					assert.NotContains(t, output, s)
+				}
+				for s, count := range test.count {
+					assert.Equal(t, count, strings.Count(output, s), s)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package clitesting
+
+import (
+	"testing"
+)
+
+func TestTcpStatesTest(t *testing.T) {
+	for _, args := range [][]string{
+		// Dump transitions with socket rows
+		{"tcp", "states", "-F", "dport == 443", "-o", "sock-mem"},
+
+		// Dump transitions of the connections with the address on either side
+		{"tcp", "states", "-F", "src|dst == 10.0.0.1"},
+
+		// Aggregate time spent in states
+		{"tcp", "states", "-6", "--aggregate", "-f", "hist", "-F", "src == fc00::1", "5s"},
+	} {
+		RunCommandTest(t, args)
+	}
+}
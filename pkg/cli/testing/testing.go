@@ -13,6 +13,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/yandex-cloud/skbtrace"
+	"github.com/yandex-cloud/skbtrace/pkg/bpfsim"
 	"github.com/yandex-cloud/skbtrace/pkg/cli"
 )
 
@@ -25,6 +26,26 @@ type testGroup struct {
 	kernelVer   string
 }
 
+var testGroups = []testGroup{
+	{
+		name:        "base",
+		bpftraceVer: "bpftrace v0.9.0",
+		kernelVer:   "4.14.0",
+	},
+	{
+		name:        "current",
+		bpftraceVer: "bpftrace v0.18.0",
+		kernelVer:   "5.15.0",
+	},
+}
+
+func (g *testGroup) versionArgs(args []string) []string {
+	return append([]string{
+		"--bpftrace-version=" + g.bpftraceVer,
+		"--kernel-version=" + g.kernelVer,
+	}, args...)
+}
+
 type (
 	TestBPFVersionProvider struct {
 		skbtrace.BPFTraceVersionProvider
@@ -39,14 +60,21 @@ func (TestKernelVersionProvider) Get() ([]byte, error) { panic("tests should use
 
 type testDeps struct {
 	output io.Writer
+
+	// scriptRunner runs scripts in place of bpftrace if set
+	scriptRunner skbtrace.ScriptRunner
 }
 
 func (d *testDeps) AddFlags(flags *pflag.FlagSet) {}
-func (d *testDeps) Setup(ctx *cli.VisitorContext) {}
 func (d *testDeps) Output() io.Writer             { return d.output }
 func (d *testDeps) ErrorOutput() io.Writer        { return d.output }
 func (d *testDeps) Exit(code int)                 {}
 
+func (d *testDeps) Setup(ctx *cli.VisitorContext) {
+	ctx.SysRoot = "testdata/sys"
+	ctx.RunnerOptions.ScriptRunner = d.scriptRunner
+}
+
 func (d *testDeps) PreprocessInterface(itfName string) (string, error) {
 	return itfName, nil
 }
@@ -75,23 +103,8 @@ func RunCommandTest(t *testing.T, args []string) {
 		cmdlineStr = strings.ReplaceAll(cmdlineStr, punct, "_")
 	}
 
-	for _, g := range []testGroup{
-		{
-			name:        "base",
-			bpftraceVer: "bpftrace v0.9.0",
-			kernelVer:   "4.14.0",
-		},
-		{
-			name:        "current",
-			bpftraceVer: "bpftrace v0.18.0",
-			kernelVer:   "5.15.0",
-		},
-	} {
-		testArgs := append([]string{
-			"--dump",
-			"--bpftrace-version=" + g.bpftraceVer,
-			"--kernel-version=" + g.kernelVer,
-		}, args...)
+	for _, g := range testGroups {
+		testArgs := append([]string{"--dump"}, g.versionArgs(args)...)
 
 		t.Run(g.name+"/"+cmdlineStr, func(t *testing.T) {
 			buf, err := executeCommand(testArgs)
@@ -113,8 +126,18 @@ func RunCommandTest(t *testing.T, args []string) {
 }
 
 func executeCommand(args []string) (output *bytes.Buffer, err error) {
+	return executeCommandWithDeps(args, &testDeps{})
+}
+
+// simulateCommand executes command running its script by simulator
+func simulateCommand(args []string, sim *bpfsim.Simulator) (output *bytes.Buffer, err error) {
+	return executeCommandWithDeps(args, &testDeps{scriptRunner: sim})
+}
+
+func executeCommandWithDeps(args []string, deps *testDeps) (output *bytes.Buffer, err error) {
 	buf := bytes.NewBuffer(nil)
-	rootCmd := cli.RootCommand.NewRootCommand(&testDeps{output: buf})
+	deps.output = buf
+	rootCmd := cli.RootCommand.NewRootCommand(deps)
 
 	rootCmd.SetArgs(args)
 	_, err = rootCmd.ExecuteC()
@@ -1,7 +1,10 @@
 package clitesting
 
 import (
+	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/require"
 )
 
 func TestTimeTest(t *testing.T) {
@@ -16,11 +19,62 @@ func TestTimeTest(t *testing.T) {
 
 		// Forwarding test
 		{"timeit", "forward", "--inbound", "-i", "tapxx-1", "-p", "tcp"},
+		{"timeit", "forward", "--outbound", "-i", "tapxx-1", "-p", "icmp"},
+
+		// Forwarding outliers explained by route lookups
+		{"timeit", "forward", "--inbound", "-i", "tapxx-1", "-p", "tcp",
+			"--explain", "outliers", "-t", "10ms", "-o", "ip"},
+
+		// Qdisc tests
+		{"timeit", "qdisc", "-i", "eth0"},
+		{"timeit", "qdisc", "--until", "xmit", "outliers", "-t", "5ms", "-o", "netdev"},
+		{"timeit", "qdisc", "-i", "eth0", "aggr", "--tui"},
+
+		// Function latency tests
+		{"timeit", "func", "-P", "ip_rcv", "-i", "eth0"},
+		{"timeit", "func", "-P", "ip_rcv", "-F", "retval != 0", "evcount"},
 
 		// TCP test
 		{"timeit", "tcp", "handshake", "--inbound", "-i", "tapxx-1"},
 		{"timeit", "tcp", "lifetime", "--outbound"},
+
+		// Outliers test with rate limiting
+		{"timeit",
+			"from", "-P", "recv", "-k", "src,dst",
+			"to", "-P", "xmit", "outliers", "-t", "10ms", "-o", "ip", "--rate", "100/s"},
+
+		// Pre-trigger history tests
+		{"timeit",
+			"from", "-P", "recv", "-k", "src,dst",
+			"to", "-P", "xmit", "outliers", "-t", "10ms", "-o", "ip",
+			"--pre-trigger", "3", "--pre-trigger-fields", "$iph->tot_len"},
+		{"timeit", "tcp", "retransmit", "--outbound", "-o", "tcp", "--pre-trigger", "2"},
+		{"timeit",
+			"from", "-P", "recv", "-k", "src,dst",
+			"to", "-P", "xmit", "outliers", "-t", "10ms", "-o", "ip",
+			"--pre-trigger", "3", "--pre-trigger-probe", "free"},
+
+		// Duplicate tests
+		{"duplicate", "-P", "recv", "-k", "src,dst,id,$iph->check",
+			"--window", "200ms", "--count", "3", "-o", "ip"},
+		{"duplicate", "-P", "recv", "-k", "src,dst,id", "--aggregate", "2s"},
+		{"timeit", "tcp", "retransmit", "--inbound", "--window", "1s", "--aggregate"},
 	} {
 		RunCommandTest(t, args)
 	}
 }
+
+func TestForwardExplainError(t *testing.T) {
+	for _, args := range [][]string{
+		{"timeit", "forward", "-i", "eth0", "-i", "eth1", "--explain"},
+		{"timeit", "forward", "-i", "eth0", "-i", "eth1", "--explain", "aggr"},
+		{"timeit", "forward", "-i", "eth0", "-i", "eth1", "--explain", "evcount"},
+	} {
+		for _, g := range testGroups {
+			t.Run(g.name+"/"+strings.Join(args, "_"), func(t *testing.T) {
+				_, err := executeCommand(append([]string{"--dump"}, g.versionArgs(args)...))
+				require.ErrorContains(t, err, "--explain is only supported by outliers subcommand")
+			})
+		}
+	}
+}
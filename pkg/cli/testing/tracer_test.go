@@ -21,12 +21,53 @@ func TestDumpTest(t *testing.T) {
 		// Inner IPv6/UDP test
 		{"dump", "-P", "recv", "-o", "inner-udp", "-6", "-F", "inner-dport == 53"},
 
+		// Weak alias picked at runtime without hints and explicit encapsulation
+		{"dump", "-P", "recv", "-o", "ip", "-F", "sport == 80"},
+		{"dump", "-P", "recv", "-o", "outer-mpls,inner-ip", "-e", "gre"},
+		{"dump", "-P", "recv", "-o", "outer-mpls,inner-ip", "-e", "auto"},
+		{"dump", "-P", "xmit", "-o", "inner-ip,inner-udp", "-e", "auto"},
+
+		// ICMP tests with type mnemonics
+		{"dump", "-P", "recv", "-o", "icmp", "-F", "icmp-type == echo-request"},
+		{"dump", "-P", "xmit", "-o", "icmpv6", "-6", "-F", "icmp-type == packet-too-big"},
+
+		// ARP and Neighbor Discovery tests
+		{"dump", "-P", "recv", "-o", "arp", "-F", "arp-op == request"},
+		{"dump", "-P", "recv", "-o", "nd", "-6", "-F", "nd-target == fe80::1"},
+
+		// TCP options test
+		{"dump", "-P", "recv", "-o", "tcp-options", "-F", "mss < 1400"},
+
+		// Network namespace filter resolved from the fake procfs
+		{"dump", "--proc-root", "testdata/proc", "-P", "xmit", "-o", "netdev", "-i", "eth0", "--netns", "42"},
+
+		// Socket tests: from skb and from probe argument
+		{"dump", "-P", "xmit", "-o", "sock", "-o", "sock-owner", "-F", "sk-dport == 443"},
+		{"dump", "-P", "tcp-set-state", "-o", "sock", "-o", "tcp-set-state", "-6", "-F", "newstate == CLOSE-WAIT"},
+
+		// Route lookup tests with error and route type mnemonics
+		{"dump", "-P", "fib-lookup", "-o", "route-flow,route-lookup,route", "-F", "route-err == ENETUNREACH"},
+		{"dump", "-P", "route-input", "-o", "rtable", "-F", "rt-type == UNREACHABLE"},
+
+		// Sampled and limited dump test
+		{"dump", "-P", "xmit", "-o", "ip", "--sample", "1/100", "--max-events", "1000"},
+
 		// Interface filter test
 		{"dump", "-P", "recv", "-o", "ip", "-i", "eth3"},
 
+		// Interface resolved to index from the fake sysfs unless name is requested
+		{"dump", "-P", "recv", "-o", "ifindex", "-i", "eth0"},
+		{"dump", "-P", "recv", "-o", "ifindex", "-i", "eth0", "--iface-by-name"},
+
 		// Test context probes
 		{"dump", "-C", "recv", "--context-filter", `dev == "eth0"`,
 			"-P", "xmit", "-F", `dev == "eth1"`, "-o", "ip"},
+
+		// Context probes keyed by socket with captured fields
+		{"dump", "-C", "tcp-sendmsg", "--context-key", "sk-src,sk-sport,sk-dst,sk-dport",
+			"--context-fields", "size,comm", "-P", "xmit", "-o", "ip"},
+		{"dump", "-C", "tcp-sendmsg", "--context-key", "sk-src,sk-sport,sk-dst,sk-dport",
+			"--context-fields", "size", "--context-field-filter", "size > 1000", "-P", "xmit", "-o", "ip"},
 	} {
 		RunCommandTest(t, args)
 	}
@@ -40,8 +81,29 @@ func TestAggrTest(t *testing.T) {
 		// Test with custom function
 		{"aggr", "-P", "recv", "-k", "src", "-f", "min", "-a", "$iph->ttl"},
 
+		// ARP aggregate test with address keys
+		{"aggr", "-P", "recv", "-k", "arp-sip,arp-sha", "-F", "arp-tha == 00:00:00:00:00:00"},
+
+		// TCP options aggregate test
+		{"aggr", "-P", "xmit", "-k", "src,mss", "-F", "tcp-flags == S"},
+
+		// Socket aggregate test
+		{"aggr", "-P", "tcp-sendmsg", "-k", "sk-dst,cgroup", "-f", "sum", "-a", "size"},
+
+		// Cgroup filter in softirq context
+		{"aggr", "-P", "recv", "-k", "cgroup,src", "-F", "cgroup == 4242"},
+
+		// Interface index aggregate test with names from lookup table
+		{"aggr", "-P", "recv", "-k", "ifindex,iif"},
+
+		// Weak alias keys picked at runtime
+		{"aggr", "-P", "recv", "-k", "inner-src,inner-sport,inner-dport"},
+
 		// Inner IPv6 aggregate test
 		{"aggr", "-6", "-P", "xmit", "-k", "outer-dst", "-F", "inner-src == fc00::1"},
+
+		// Live view prints end marker after aggregation
+		{"aggr", "-P", "xmit", "-k", "src,dst", "--tui"},
 	} {
 		RunCommandTest(t, args)
 	}
@@ -54,6 +116,14 @@ func TestErrorTest(t *testing.T) {
 		{"dump", "-P", "recv", "-F", "src ======= 127.0.0.1"},
 
 		{"dump", "-P", "recv", "-F", "src == a.b.c.d"},
+
+		{"dump", "-P", "recv", "-F", "retval != 0"},
+
+		{"dump", "-C", "recv", "--context-key", "unknown_field", "-P", "xmit", "-o", "ip"},
+
+		{"aggr", "-C", "tcp-sendmsg", "--context-fields", "size", "-P", "xmit", "-k", "src"},
+
+		{"dump", "-C", "tcp-sendmsg", "--context-field-filter", "size > 1000", "-P", "xmit", "-o", "ip"},
 	} {
 		RunCommandTest(t, args)
 	}
@@ -44,6 +44,8 @@ func CommonTimeItVisitor(
 ) {
 	RegisterFilterOptions(cmd.Flags(), &specPtr.FilterOptions)
 	RegisterInterfaceOptions(ctx, cmd, &specPtr.FilterOptions)
+	RegisterNetnsOptions(ctx, cmd, &specPtr.FilterOptions)
+	RegisterCgroupOptions(ctx, cmd, &specPtr.FilterOptions)
 
 	cmd.Flags().StringVarP(&specPtr.Probe, "probe", "P", "",
 		`Probe name to use. Use 'probes' subcommand to list available probes.`)
@@ -82,6 +84,8 @@ var CommonTimeItFromCommand = &CommandProducer{
 	Children: []*CommandProducer{
 		timeItFromCommand,
 		ForwardCommand,
+		QdiscCommand,
+		FuncCommand,
 		BaseTcpCommand,
 	},
 }
@@ -136,10 +140,22 @@ var TimeAggregateCommand = &CommandProducer{
 		RegisterTimeIntervalArg(ctx, cmd, &opts.Interval)
 		RegisterAggregateCommonOptions(flags, &opts.AggregateCommonOptions)
 		PassTimeCommonOptions(ctx, cmd, &opts.TimeCommonOptions, commonOpts)
+		outOpts := RegisterAggregateOutputOptions(ctx, cmd, &opts.AggregateCommonOptions)
 
-		cmd.Run = NewRun(ctx, func() (*skbtrace.Program, error) {
+		cmd.Run = NewRun(ctx, outOpts.Wrap(func() (*skbtrace.Program, error) {
 			return ctx.Builder.BuildTimeAggregate(opts)
-		})
+		}, func() aggregateInfo {
+			mapKeys := make(map[string][]string)
+			for _, counter := range opts.Counters {
+				mapKeys[counter.Name] = counter.Keys
+			}
+			return aggregateInfo{
+				Name:    "timeit_" + opts.TimeUnit,
+				Keys:    opts.AggrKeys,
+				Func:    opts.Func,
+				MapKeys: mapKeys,
+			}
+		}))
 	},
 }
 
@@ -154,6 +170,8 @@ var TimeOutlierDumpSubcommand = &CommandProducer{
 
 		flags := cmd.Flags()
 		RegisterCommonDumpOptions(flags, &opts.CommonDumpOptions)
+		RegisterDumpLimitOptions(flags, &opts.DumpLimitOptions)
+		RegisterPreTriggerOptions(flags, &opts.PreTriggerOptions)
 		flags.DurationVarP(&opts.OutlierThreshold, "threshold", "t",
 			100*time.Millisecond,
 			"Threshold for time delta. If hit, probe firing is considered an outlier.")
@@ -180,16 +198,21 @@ var TimeEventCountSubcommand = &CommandProducer{
 
 var CommonDuplicateCommand = &CommandProducer{
 	Base: &cobra.Command{
-		Use:     "duplicate",
+		Use:     "duplicate -P PROBE -k KEYS [--window WINDOW] [--aggregate] [INTERVAL]",
+		Example: "duplicate -P recv -k src,dst,id,$iph->check --window 200ms --count 3 --aggregate",
 		Short:   "Dumps objects when hit twice with the same set of keys",
 		Aliases: []string{"dup"},
+		Args:    cobra.RangeArgs(0, 1),
 	},
 	CommonVisitor: func(ctx *VisitorContext, cmd *cobra.Command, commonOpts *skbtrace.CommonOptions) {
-		var opts skbtrace.DuplicateEventOptions
+		opts := newDuplicateEventOptions()
 		PassCommonOptions(ctx, cmd, &opts.CommonOptions, commonOpts)
+		CommonTimeItVisitor(ctx, cmd, &opts.Spec)
 
 		flags := cmd.Flags()
 		RegisterCommonDumpOptions(flags, &opts.CommonDumpOptions)
+		RegisterPreTriggerOptions(flags, &opts.PreTriggerOptions)
+		RegisterDuplicateOptions(ctx, cmd, &opts)
 		flags.BoolVar(&opts.Exit, "exit", false,
 			"exit after dumping first outlier.")
 
@@ -198,3 +221,24 @@ var CommonDuplicateCommand = &CommandProducer{
 		})
 	},
 }
+
+func newDuplicateEventOptions() skbtrace.DuplicateEventOptions {
+	return skbtrace.DuplicateEventOptions{
+		AggregateCommonOptions: skbtrace.AggregateCommonOptions{
+			Interval: time.Second,
+		},
+	}
+}
+
+func RegisterDuplicateOptions(ctx *VisitorContext, cmd *cobra.Command, opts *skbtrace.DuplicateEventOptions) {
+	flags := cmd.Flags()
+	RegisterAggregateCommonOptions(flags, &opts.AggregateCommonOptions)
+	RegisterTimeIntervalArg(ctx, cmd, &opts.Interval)
+	flags.DurationVar(&opts.Window, "window", 0,
+		"Window in which repeated events are considered duplicates. By default keys are "+
+			"remembered until periodic cleanup.")
+	flags.IntVar(&opts.MinCount, "count", 2,
+		"Number of times the same set of keys should be seen within window to report duplicate.")
+	flags.BoolVar(&opts.Aggregate, "aggregate", false,
+		"Count duplicates per set of keys instead of dumping them.")
+}
@@ -22,6 +22,8 @@ func (producer *CommandProducer) addTracer(
 	RegisterTracerContextOptions(cmd.Flags(), &opts)
 	RegisterFilterOptions(cmd.Flags(), &opts.FilterOptions)
 	RegisterInterfaceOptions(ctx, cmd, &opts.FilterOptions)
+	RegisterNetnsOptions(ctx, cmd, &opts.FilterOptions)
+	RegisterCgroupOptions(ctx, cmd, &opts.FilterOptions)
 
 	producer.walkTracerTree(ctx, cmd, &opts)
 }
@@ -81,6 +83,7 @@ var CommonDumpTracerCommand = &CommandProducer{
 	TracerVisitor: &DumpTracerCommand{
 		Visitor: func(ctx *VisitorContext, cmd *cobra.Command, opts *skbtrace.TraceDumpOptions) {
 			RegisterCommonDumpOptions(cmd.Flags(), &opts.CommonDumpOptions)
+			RegisterDumpLimitOptions(cmd.Flags(), &opts.DumpLimitOptions)
 		},
 	},
 }
@@ -101,10 +104,13 @@ func (dumper *AggregateTracerCommand) Visit(
 	}
 	PassTraceCommonOptions(ctx, cmd, &opts.TraceCommonOptions, commonOpts)
 	dumper.Visitor(ctx, cmd, &opts)
+	outOpts := RegisterAggregateOutputOptions(ctx, cmd, &opts.AggregateCommonOptions)
 
-	cmd.Run = NewRun(ctx, func() (*skbtrace.Program, error) {
+	cmd.Run = NewRun(ctx, outOpts.Wrap(func() (*skbtrace.Program, error) {
 		return ctx.Builder.BuildAggregate(opts)
-	})
+	}, func() aggregateInfo {
+		return aggregateInfo{Name: "aggregate_" + string(opts.Func), Keys: opts.Keys, Func: opts.Func}
+	}))
 }
 
 func RegisterAggregateOptions(flags *pflag.FlagSet, opts *skbtrace.TraceAggregateOptions) {
@@ -9,8 +9,10 @@ import (
 	"github.com/spf13/pflag"
 
 	"github.com/yandex-cloud/skbtrace"
+	"github.com/yandex-cloud/skbtrace/pkg/metrics"
 	"github.com/yandex-cloud/skbtrace/pkg/proto"
 	"github.com/yandex-cloud/skbtrace/pkg/skb"
+	"github.com/yandex-cloud/skbtrace/pkg/sysinfo"
 )
 
 const (
@@ -86,6 +88,29 @@ type VisitorContext struct {
 	IsIPv6    bool
 	EncapType string
 
+	// Root of procfs used for resolving namespaces
+	ProcRoot string
+
+	// Root of sysfs used for resolving interfaces
+	SysRoot string
+
+	// Filter interfaces by name instead of resolving them to indices
+	IfaceByName bool
+
+	// Directory where named profiles are stored
+	ProfileDir string
+
+	// Root producer which is used for producing commands from profiles
+	root *CommandProducer
+
+	// Exporter of the aggregations and name of the exported metric if
+	// command is run by serve command
+	exporter   *metrics.Exporter
+	metricName string
+
+	// Set when builder is set up for completing flags
+	completionReady bool
+
 	featureMaskArgs  [skbtrace.FeatureComponentCount]string
 	featureVerArgs   [skbtrace.FeatureComponentCount]string
 	FeatureFlagMasks [skbtrace.FeatureComponentCount]skbtrace.FeatureFlagMask
@@ -109,6 +134,14 @@ func (base *CommandProducer) newCommand(parent *cobra.Command) *cobra.Command {
 // NewRootCommand creates a root command with all of its children
 // rendered into cobra.Command structures.
 func (root *CommandProducer) NewRootCommand(deps Dependencies) *cobra.Command {
+	return root.newRootCommand(deps, nil)
+}
+
+// newRootCommand creates a root command for a command nested into serve or
+// run commands which pass their state to its context using setup function
+func (root *CommandProducer) newRootCommand(
+	deps Dependencies, setup func(ctx *VisitorContext),
+) *cobra.Command {
 	var opts skbtrace.CommonOptions
 	ctx := &VisitorContext{
 		Builder:      skbtrace.NewBuilder(),
@@ -117,8 +150,13 @@ func (root *CommandProducer) NewRootCommand(deps Dependencies) *cobra.Command {
 			BPFTraceBinary: "bpftrace",
 		},
 	}
+	ctx.root = root
+	if setup != nil {
+		setup(ctx)
+	}
 
 	rootCmd := root.newCommand(nil)
+	rootCmd.SetOut(deps.Output())
 	root.registerRootFlags(rootCmd.PersistentFlags(), ctx, &opts)
 	deps.AddFlags(rootCmd.PersistentFlags())
 
@@ -129,6 +167,7 @@ func (root *CommandProducer) NewRootCommand(deps Dependencies) *cobra.Command {
 		}
 
 		deps.Setup(ctx)
+		root.setupLookupTables(ctx)
 		return nil
 	}
 	ctx.PreRunEChain = append(ctx.PreRunEChain, rootCmd.PreRunE)
@@ -140,7 +179,8 @@ func (root *CommandProducer) NewRootCommand(deps Dependencies) *cobra.Command {
 		rootCmd.AddCommand(childCmd)
 	}
 
-	addHiddenCommands(rootCmd)
+	addHiddenCommands(rootCmd, deps)
+	addCompletions(ctx, rootCmd, rootCmd.PreRunE)
 	return rootCmd
 }
 
@@ -151,16 +191,28 @@ func (root *CommandProducer) registerRootFlags(
 		`Dump bpftrace command instead of running it`)
 	flags.StringVar(&ctx.RunnerOptions.BPFTraceBinary, "bpftrace", "bpftrace",
 		`Path to bpftrace binary`)
+	flags.StringVar(&ctx.RunnerOptions.RecordPath, recordFlagName, "",
+		`Save output of bpftrace along with the script to the file which can be replayed by 'replay' command`)
 	flags.DurationVarP(&opts.Timeout, "timeout", "T", defaultTimeout,
-		`Execution timeout for resulting bpftrace script`)
-	flags.StringVarP(&ctx.EncapType, "encap", "e", proto.EncapProtoUdp,
-		`Type of encapsulation: 'gre' or 'udp'`)
+		`Execution timeout for resulting bpftrace script, 0 disables it`)
+	flags.StringVarP(&ctx.EncapType, "encap", "e", proto.EncapProtoAuto,
+		`Type of encapsulation: 'gre' or 'udp'. By default it is detected at runtime from outer IP protocol.`)
 	flags.BoolVarP(&ctx.IsIPv6, "inet6", "6", false,
 		`If specified, skbtrace assumes that inner header is IPv6.`)
 	flags.StringSliceVarP(&opts.Hints, "hint", "p", nil,
-		`Protocol hints for weak field aliases such as 'tcp' for 'sport'.`)
+		`Protocol hints for weak field aliases such as 'tcp' for 'sport'. `+
+			`If omitted, protocol is checked at runtime when possible.`)
 	flags.StringVar(&opts.TimeUnit, "unit", skbtrace.TUMicrosecond,
 		`Time unit using for measurements: 'sec', 'ms', 'us' - default or 'ns'`)
+	flags.StringVar(&ctx.ProcRoot, "proc-root", sysinfo.DefaultProcRoot,
+		`Path to procfs used for resolving namespaces`)
+	flags.MarkHidden("proc-root")
+	flags.StringVar(&ctx.SysRoot, "sys-root", sysinfo.DefaultSysRoot,
+		`Path to sysfs used for resolving interfaces`)
+	flags.MarkHidden("sys-root")
+	flags.BoolVar(&ctx.IfaceByName, "iface-by-name", false,
+		`Filter interfaces specified by -i by name instead of index resolved from sysfs. `+
+			`Useful for interfaces in other network namespaces or which will be renamed.`)
 
 	for name, spec := range ctx.Dependencies.FeatureComponents() {
 		flags.StringVar(&ctx.featureVerArgs[spec.Component], fmt.Sprintf("%s-version", name), "",
@@ -187,12 +239,20 @@ func (root *CommandProducer) setup(ctx *VisitorContext, opts *skbtrace.CommonOpt
 
 	skb.RegisterSkb(ctx.Builder, bpfTraceFeatureMask, kernelFeatureMask)
 	skb.RegisterTask(ctx.Builder)
+	skb.RegisterQdisc(ctx.Builder, kernelFeatureMask)
 
 	proto.RegisterEth(ctx.Builder, bpfTraceFeatureMask)
 	proto.RegisterEncap(ctx.Builder, ctx.EncapType, bpfTraceFeatureMask)
 	proto.RegisterIp(ctx.Builder, ctx.IsIPv6, bpfTraceFeatureMask)
 	proto.RegisterTransport(ctx.Builder, ctx.IsIPv6, bpfTraceFeatureMask)
-
+	proto.RegisterTcpOptions(ctx.Builder, kernelFeatureMask)
+	proto.RegisterIcmp(ctx.Builder, bpfTraceFeatureMask)
+	proto.RegisterNeigh(ctx.Builder, ctx.IsIPv6, bpfTraceFeatureMask)
+	proto.RegisterSock(ctx.Builder, ctx.IsIPv6, bpfTraceFeatureMask, kernelFeatureMask)
+	proto.RegisterNetfilter(ctx.Builder, kernelFeatureMask)
+	proto.RegisterRoute(ctx.Builder, ctx.IsIPv6, bpfTraceFeatureMask, kernelFeatureMask)
+
+	proto.RegisterEncapSanityFilters(ctx.Builder, ctx.EncapType, ctx.IsIPv6)
 	proto.RegisterOverlayLengthFunc(ctx.Builder, ctx.EncapType)
 	proto.RegisterInnerIpLengthFunc(ctx.Builder, ctx.IsIPv6)
 
@@ -202,6 +262,17 @@ func (root *CommandProducer) setup(ctx *VisitorContext, opts *skbtrace.CommonOpt
 	return nil
 }
 
+// setupLookupTables registers lookup tables which are collected from the host
+// when they are used. It is called after dependencies setup as they might
+// override sysfs root.
+func (root *CommandProducer) setupLookupTables(ctx *VisitorContext) {
+	ctx.Builder.AddLookupTableFunc(skb.IfnamesTable, func() skbtrace.LookupTable {
+		// Names are only printed along with indices, so unlisted interfaces are ok
+		interfaces, _ := sysinfo.ListInterfaces(ctx.SysRoot)
+		return interfaces
+	})
+}
+
 func (producer *CommandProducer) commonVisit(
 	ctx *VisitorContext, cmd *cobra.Command, commonOpts *skbtrace.CommonOptions,
 ) {
@@ -216,6 +287,9 @@ func (producer *CommandProducer) commonVisit(
 		producer.addTracer(ctx, cmd, commonOpts)
 	} else if producer.TimeVisitor != nil {
 		producer.addTimeIt(ctx, cmd, commonOpts)
+	} else if len(producer.Children) > 0 {
+		// Producers which only group children don't need a visitor
+		producer.walkCommonTree(ctx, cmd, commonOpts)
 	} else {
 		panic(fmt.Sprintf("unexpected Visit() on producer '%s' without visitors", cmd.Name()))
 	}
@@ -224,7 +298,9 @@ func (producer *CommandProducer) commonVisit(
 func (producer *CommandProducer) walkCommonTree(
 	ctx *VisitorContext, cmd *cobra.Command, opts *skbtrace.CommonOptions,
 ) {
-	producer.CommonVisitor(ctx, cmd, opts)
+	if producer.CommonVisitor != nil {
+		producer.CommonVisitor(ctx, cmd, opts)
+	}
 
 	for _, child := range producer.Children {
 		childCmd := child.newCommand(cmd)
@@ -246,9 +322,13 @@ func (ctx *VisitorContext) Restore(chain PreRunEChain) {
 }
 
 func (ctx *VisitorContext) AddPreRun(cmd *cobra.Command, impl PreRunEFunc) {
-	ctx.PreRunEChain = append(ctx.PreRunEChain, impl)
+	// Copy chain as appending in place may overwrite pre-run functions of
+	// sibling commands which share the same underlying array
+	chain := make(PreRunEChain, len(ctx.PreRunEChain), len(ctx.PreRunEChain)+1)
+	copy(chain, ctx.PreRunEChain)
+	chain = append(chain, impl)
+	ctx.PreRunEChain = chain
 
-	chain := ctx.PreRunEChain
 	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
 		for _, cb := range chain {
 			err := cb(cmd, args)
@@ -0,0 +1,359 @@
+// Package metrics exports aggregations printed by bpftrace on interval as
+// metrics in Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/yandex-cloud/skbtrace/pkg/bpfout"
+)
+
+// EndMarker should be printed by the script after all aggregations are
+// printed on interval, so exporter knows that snapshot is complete
+const EndMarker = "--- skbtrace metrics ---"
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	reTime             = regexp.MustCompile(`^\d{2}:\d{2}:\d{2}$`)
+	reInvalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+	labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// Exporter is an output processor which accumulates aggregations printed
+// on interval and serves them as metrics. As scripts clear maps after
+// printing, values of count() and sum() are added to counters, values of
+// min(), max() and avg() are exported as gauges and hist() is exported
+// as histogram. Entries of other maps are exported as counters.
+//
+// As hist() doesn't print sum of values, sum of histogram is estimated from
+// midpoints of the buckets, so it is only accurate enough for averages.
+type Exporter struct {
+	// Namespace is a prefix of names of all metrics
+	Namespace string
+
+	mu      sync.Mutex
+	metric  metricSpec
+	metrics map[string]*metric
+
+	// Entries of the snapshot which is being parsed
+	pending []*bpfout.MapEntry
+	hists   map[*bpfout.MapEntry][]bpfout.HistBucket
+}
+
+type metricSpec struct {
+	name   string
+	labels []string
+	gauge  bool
+
+	// Label names of other maps by map names
+	mapLabels map[string][]string
+}
+
+type metric struct {
+	name   string
+	kind   string
+	labels []string
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+
+	// Histogram buckets by their upper bound along with estimated sum
+	buckets map[float64]uint64
+	count   uint64
+	sum     float64
+}
+
+// SetMetric sets name of the metric and its label names which values are
+// taken from keys of the anonymous map. If gauge is set, values of the
+// anonymous map are not accumulated.
+func (exp *Exporter) SetMetric(name string, labels []string, gauge bool) {
+	exp.mu.Lock()
+	defer exp.mu.Unlock()
+
+	exp.metric.name, exp.metric.labels, exp.metric.gauge = name, labels, gauge
+}
+
+// SetMapLabels sets label names of the metrics produced from other maps
+func (exp *Exporter) SetMapLabels(mapLabels map[string][]string) {
+	exp.mu.Lock()
+	defer exp.mu.Unlock()
+
+	exp.metric.mapLabels = mapLabels
+}
+
+// Process implements skbtrace.OutputProcessor. Lines which are not
+// aggregations, such as bpftrace messages, are passed to w
+func (exp *Exporter) Process(r io.Reader, w io.Writer) error {
+	var histEntry *bpfout.MapEntry
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == EndMarker:
+			exp.commit()
+			histEntry = nil
+		case histEntry != nil && line != "":
+			if bucket, ok := bpfout.ParseHistBucket(line); ok {
+				exp.hists[histEntry] = append(exp.hists[histEntry], *bucket)
+			}
+		case line == "":
+			histEntry = nil
+		default:
+			if entry, ok := bpfout.ParseMapHeader(line); ok {
+				histEntry = exp.addEntry(entry)
+				exp.hists[histEntry] = []bpfout.HistBucket{}
+			} else if entry, ok := bpfout.ParseMapEntry(line); ok {
+				exp.addEntry(entry)
+			} else if !reTime.MatchString(line) {
+				fmt.Fprintln(w, line)
+			}
+		}
+	}
+
+	// Scripts which don't print end marker, such as recorded ones, print
+	// maps on exit, so remaining entries are merged too
+	exp.commit()
+	return scanner.Err()
+}
+
+func (exp *Exporter) addEntry(entry *bpfout.MapEntry) *bpfout.MapEntry {
+	if exp.hists == nil {
+		exp.hists = make(map[*bpfout.MapEntry][]bpfout.HistBucket)
+	}
+	exp.pending = append(exp.pending, entry)
+	return entry
+}
+
+// commit merges entries of the complete snapshot into metrics
+func (exp *Exporter) commit() {
+	exp.mu.Lock()
+	defer exp.mu.Unlock()
+
+	if exp.metrics == nil {
+		exp.metrics = make(map[string]*metric)
+	}
+	for _, entry := range exp.pending {
+		buckets, isHist := exp.hists[entry]
+		exp.mergeEntry(entry, buckets, isHist)
+	}
+	exp.pending, exp.hists = nil, nil
+}
+
+func (exp *Exporter) mergeEntry(entry *bpfout.MapEntry, buckets []bpfout.HistBucket, isHist bool) {
+	kind, name, labels := "counter", entry.Map, exp.metric.mapLabels[entry.Map]
+	if entry.Map == "" {
+		name, labels = exp.metric.name, exp.metric.labels
+		switch {
+		case isHist:
+			kind = "histogram"
+		case exp.metric.gauge:
+			kind = "gauge"
+		}
+	}
+	if len(labels) != len(entry.Keys) {
+		labels = make([]string, len(entry.Keys))
+		for i := range labels {
+			labels[i] = fmt.Sprintf("key%d", i+1)
+		}
+	}
+
+	fullName := exp.metricName(name, kind)
+	m, ok := exp.metrics[fullName]
+	if !ok {
+		m = &metric{name: fullName, kind: kind, labels: labels, series: make(map[string]*series)}
+		exp.metrics[fullName] = m
+	}
+
+	s, ok := m.series[entry.KeyString()]
+	if !ok {
+		s = &series{labelValues: entry.Keys, buckets: make(map[float64]uint64)}
+		m.series[entry.KeyString()] = s
+	}
+
+	switch kind {
+	case "histogram":
+		for _, bucket := range buckets {
+			s.buckets[bucketBound(&bucket)] += bucket.Count
+			s.count += bucket.Count
+			s.sum += bucketMidpoint(&bucket) * float64(bucket.Count)
+		}
+	case "gauge":
+		s.value, _ = strconv.ParseFloat(entry.Value, 64)
+	default:
+		value, _ := strconv.ParseFloat(entry.Value, 64)
+		s.value += value
+	}
+}
+
+func (exp *Exporter) metricName(name, kind string) string {
+	parts := []string{exp.Namespace, name}
+	if kind == "counter" {
+		parts = append(parts, "total")
+	}
+
+	var nonEmptyParts []string
+	for _, part := range parts {
+		if part != "" {
+			nonEmptyParts = append(nonEmptyParts, part)
+		}
+	}
+	return sanitizeName(strings.Join(nonEmptyParts, "_"))
+}
+
+// ServeHTTP implements http.Handler serving metrics
+func (exp *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	exp.Write(w)
+}
+
+// Write writes metrics in Prometheus text exposition format
+func (exp *Exporter) Write(w io.Writer) {
+	exp.mu.Lock()
+	defer exp.mu.Unlock()
+
+	names := make([]string, 0, len(exp.metrics))
+	for name := range exp.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		exp.metrics[name].write(w)
+	}
+}
+
+func (m *metric) write(w io.Writer) {
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		labels := formatLabels(m.labels, s.labelValues)
+		if m.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, labels, formatValue(s.value))
+			continue
+		}
+
+		bounds := make([]float64, 0, len(s.buckets))
+		for bound := range s.buckets {
+			bounds = append(bounds, bound)
+		}
+		sort.Float64s(bounds)
+
+		var count uint64
+		for _, bound := range bounds {
+			count += s.buckets[bound]
+			if math.IsInf(bound, 1) {
+				continue
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.formatBucketLabels(s, formatValue(bound)), count)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.formatBucketLabels(s, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, labels, formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, labels, s.count)
+	}
+}
+
+func (m *metric) formatBucketLabels(s *series, le string) string {
+	names := append(append([]string{}, m.labels...), "le")
+	values := append(append([]string{}, s.labelValues...), le)
+	return formatLabels(names, values)
+}
+
+// bucketBound returns inclusive upper bound of the bucket which is used as le
+// label. Buckets of hist() contain integer values less than their upper
+// bound, so the bound is decremented.
+func bucketBound(bucket *bpfout.HistBucket) float64 {
+	if bucket.High == "" {
+		low, _ := parseBound(bucket.Low)
+		return low
+	}
+
+	high, ok := parseBound(bucket.High)
+	if !ok {
+		return math.Inf(1)
+	}
+	return high - 1
+}
+
+// bucketMidpoint returns value which represents values of the bucket in the
+// estimated sum. Open buckets are represented by their finite bound.
+func bucketMidpoint(bucket *bpfout.HistBucket) float64 {
+	low, lowOk := parseBound(bucket.Low)
+	if bucket.High == "" {
+		return low
+	}
+
+	high, highOk := parseBound(bucket.High)
+	switch {
+	case !highOk:
+		return low
+	case !lowOk:
+		return high - 1
+	}
+	return (low + high - 1) / 2
+}
+
+// parseBound parses bucket bound as printed by bpftrace with optional suffix
+// such as "4K". Returns false for open bounds
+func parseBound(bound string) (float64, bool) {
+	multiplier := 1.0
+	for i, suffix := range []string{"K", "M", "G", "T", "P"} {
+		if strings.HasSuffix(bound, suffix) {
+			bound = strings.TrimSuffix(bound, suffix)
+			multiplier = math.Pow(1024, float64(i+1))
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(bound, 64)
+	if err != nil {
+		return 0, false
+	}
+	return value * multiplier, true
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(names))
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, sanitizeName(name), labelValueReplacer.Replace(values[i])))
+	}
+	return fmt.Sprintf("{%s}", strings.Join(pairs, ","))
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// sanitizeName replaces characters which are not allowed in metric and
+// label names such as '-' in field aliases
+func sanitizeName(name string) string {
+	name = strings.Trim(reInvalidNameChars.ReplaceAllString(name, "_"), "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExporterHist(t *testing.T) {
+	exp := &Exporter{Namespace: "skbtrace"}
+	exp.SetMetric("timeit_us", []string{"dev", "qdisc-kind"}, false)
+	exp.SetMapLabels(map[string][]string{"qdisc_drops": {"dev", "$qdisc->ops->id"}})
+
+	f, err := os.Open("testdata/qdisc.txt")
+	require.NoError(t, err)
+	defer f.Close()
+
+	out := bytes.NewBuffer(nil)
+	require.NoError(t, exp.Process(f, out))
+	assert.Equal(t, "Attaching 6 probes...\n", out.String())
+
+	server := httptest.NewServer(exp)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, contentType, resp.Header.Get("Content-Type"))
+
+	// Incomplete snapshot after the last marker is not exported
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		`# TYPE skbtrace_qdisc_drops_total counter`,
+		`skbtrace_qdisc_drops_total{dev="eth0",qdisc_ops_id="fq_codel"} 5`,
+		`skbtrace_qdisc_drops_total{dev="eth1",qdisc_ops_id="\"pfifo\""} 1`,
+		`# TYPE skbtrace_timeit_us histogram`,
+		`skbtrace_timeit_us_bucket{dev="eth0",qdisc_kind="fq_codel",le="1"} 3`,
+		`skbtrace_timeit_us_bucket{dev="eth0",qdisc_kind="fq_codel",le="3"} 1063`,
+		`skbtrace_timeit_us_bucket{dev="eth0",qdisc_kind="fq_codel",le="7"} 1103`,
+		`skbtrace_timeit_us_bucket{dev="eth0",qdisc_kind="fq_codel",le="2047"} 1104`,
+		`skbtrace_timeit_us_bucket{dev="eth0",qdisc_kind="fq_codel",le="+Inf"} 1104`,
+		`skbtrace_timeit_us_sum{dev="eth0",qdisc_kind="fq_codel"} 4408.5`,
+		`skbtrace_timeit_us_count{dev="eth0",qdisc_kind="fq_codel"} 1104`,
+		``,
+	}, "\n"), string(body))
+}
+
+func TestExporterValues(t *testing.T) {
+	input := strings.Join([]string{
+		"@[10.0.0.1]: 10",
+		"@[10.0.0.2]: 5",
+		EndMarker,
+		"@[10.0.0.1]: 7",
+		EndMarker,
+	}, "\n")
+
+	t.Run("Counter", func(t *testing.T) {
+		exp := &Exporter{Namespace: "skbtrace"}
+		exp.SetMetric("aggregate_count", []string{"src"}, false)
+		require.NoError(t, exp.Process(strings.NewReader(input), io.Discard))
+
+		out := bytes.NewBuffer(nil)
+		exp.Write(out)
+		assert.Equal(t, strings.Join([]string{
+			`# TYPE skbtrace_aggregate_count_total counter`,
+			`skbtrace_aggregate_count_total{src="10.0.0.1"} 17`,
+			`skbtrace_aggregate_count_total{src="10.0.0.2"} 5`,
+			``,
+		}, "\n"), out.String())
+	})
+
+	t.Run("Gauge", func(t *testing.T) {
+		exp := &Exporter{Namespace: "skbtrace"}
+		exp.SetMetric("aggregate_max", []string{"src"}, true)
+		require.NoError(t, exp.Process(strings.NewReader(input), io.Discard))
+
+		out := bytes.NewBuffer(nil)
+		exp.Write(out)
+		assert.Equal(t, strings.Join([]string{
+			`# TYPE skbtrace_aggregate_max gauge`,
+			`skbtrace_aggregate_max{src="10.0.0.1"} 7`,
+			`skbtrace_aggregate_max{src="10.0.0.2"} 5`,
+			``,
+		}, "\n"), out.String())
+	})
+}
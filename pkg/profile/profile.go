@@ -0,0 +1,164 @@
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const fileExt = ".yaml"
+
+var (
+	reName        = regexp.MustCompile(`^[\w.-]+$`)
+	rePlaceholder = regexp.MustCompile(`\$\{([\w-]+)\}`)
+)
+
+// Profile is a named command line of skbtrace which may contain parameter
+// placeholders in ${name} form substituted when profile is run
+type Profile struct {
+	Name        string            `yaml:"-"`
+	Description string            `yaml:"description,omitempty"`
+	Args        []string          `yaml:"args"`
+	Defaults    map[string]string `yaml:"defaults,omitempty"`
+}
+
+// DefaultDir returns directory where profiles are stored by default
+func DefaultDir() string {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(configDir, "skbtrace", "profiles")
+}
+
+func profilePath(dir, name string) (string, error) {
+	if dir == "" {
+		return "", errors.New("profile directory is not specified")
+	}
+	if !reName.MatchString(name) {
+		return "", fmt.Errorf("invalid profile name '%s'", name)
+	}
+	return filepath.Join(dir, name+fileExt), nil
+}
+
+// Load reads profile from the profile directory
+func Load(dir, name string) (*Profile, error) {
+	path, err := profilePath(dir, name)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load profile '%s': %w", name, err)
+	}
+
+	p := &Profile{Name: name}
+	if err := yaml.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("cannot parse profile '%s': %w", name, err)
+	}
+	return p, nil
+}
+
+// Save writes profile to the profile directory creating it if needed
+func Save(dir string, p *Profile) error {
+	path, err := profilePath(dir, p.Name)
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("cannot create profile directory: %w", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// List loads all profiles in the profile directory sorted by name.
+// Missing directory is treated as an empty one
+func List(dir string) ([]*Profile, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+fileExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	profiles := make([]*Profile, 0, len(paths))
+	for _, path := range paths {
+		p, err := Load(dir, strings.TrimSuffix(filepath.Base(path), fileExt))
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+// Params returns sorted names of the parameters used in profile arguments
+func (p *Profile) Params() []string {
+	seen := make(map[string]struct{})
+	var params []string
+	for _, arg := range p.Args {
+		for _, groups := range rePlaceholder.FindAllStringSubmatch(arg, -1) {
+			if _, ok := seen[groups[1]]; !ok {
+				seen[groups[1]] = struct{}{}
+				params = append(params, groups[1])
+			}
+		}
+	}
+	sort.Strings(params)
+	return params
+}
+
+// Expand substitutes parameter placeholders in arguments using values
+// or profile defaults. Returns an error if some parameter has no value
+func (p *Profile) Expand(values map[string]string) ([]string, error) {
+	for name := range values {
+		if !p.hasParam(name) {
+			return nil, fmt.Errorf("unknown parameter '%s' of profile '%s'", name, p.Name)
+		}
+	}
+
+	var missing []string
+	for _, name := range p.Params() {
+		_, ok := values[name]
+		if _, hasDefault := p.Defaults[name]; !ok && !hasDefault {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("parameters of profile '%s' are not set: %s",
+			p.Name, strings.Join(missing, ", "))
+	}
+
+	args := make([]string, len(p.Args))
+	for i, arg := range p.Args {
+		args[i] = rePlaceholder.ReplaceAllStringFunc(arg, func(placeholder string) string {
+			name := rePlaceholder.FindStringSubmatch(placeholder)[1]
+			if value, ok := values[name]; ok {
+				return value
+			}
+			return p.Defaults[name]
+		})
+	}
+	return args, nil
+}
+
+func (p *Profile) hasParam(name string) bool {
+	for _, param := range p.Params() {
+		if param == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package profile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileExpand(t *testing.T) {
+	p := &Profile{
+		Name:     "syn",
+		Args:     []string{"dump", "-P", "${probe}", "-F", "dst == ${dst} && $iph->ttl > 1"},
+		Defaults: map[string]string{"probe": "xmit"},
+	}
+	assert.Equal(t, []string{"dst", "probe"}, p.Params())
+
+	t.Run("Defaults", func(t *testing.T) {
+		args, err := p.Expand(map[string]string{"dst": "10.0.0.1"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"dump", "-P", "xmit", "-F", "dst == 10.0.0.1 && $iph->ttl > 1"}, args)
+	})
+
+	t.Run("Override", func(t *testing.T) {
+		args, err := p.Expand(map[string]string{"dst": "10.0.0.1", "probe": "recv"})
+		require.NoError(t, err)
+		assert.Equal(t, "recv", args[2])
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		_, err := p.Expand(nil)
+		assert.ErrorContains(t, err, "dst")
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		_, err := p.Expand(map[string]string{"dst": "10.0.0.1", "src": "10.0.0.2"})
+		assert.ErrorContains(t, err, "src")
+	})
+}
+
+func TestProfileSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	p := &Profile{Name: "syn", Description: "SYN packets", Args: []string{"dump", "-P", "xmit"}}
+	require.NoError(t, Save(dir, p))
+
+	loaded, err := Load(dir, "syn")
+	require.NoError(t, err)
+	assert.Equal(t, p, loaded)
+
+	profiles, err := List(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []*Profile{p}, profiles)
+
+	assert.Error(t, Save(dir, &Profile{Name: "../syn"}))
+}
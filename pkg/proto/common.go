@@ -1,5 +1,16 @@
 package proto
 
+import "github.com/yandex-cloud/skbtrace"
+
 var headerFiles = []string{
 	"linux/types.h",
 }
+
+// newNamesLookupTable creates lookup table from names indexed by their values
+func newNamesLookupTable(names []string) skbtrace.LookupTable {
+	table := make(skbtrace.LookupTable, len(names))
+	for i, name := range names {
+		table[int64(i)] = name
+	}
+	return table
+}
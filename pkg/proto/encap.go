@@ -11,6 +11,7 @@ import (
 )
 
 const (
+	EncapProtoAuto = "auto"
 	EncapProtoEth  = "eth"
 	EncapProtoIp   = "ip"
 	EncapProtoGre  = "gre"
@@ -18,6 +19,7 @@ const (
 	EncapProtoMpls = "mpls"
 
 	OverlayHeaderLengthFunc = "OverlayHeaderLength"
+	EncapHeaderLengthFunc   = "EncapHeaderLength"
 	BaseEncapHdrLength      = EthHdrLength + IpHdrMinLength
 	UdpHdrLength            = 8
 	GreHdrLength            = 4
@@ -25,6 +27,12 @@ const (
 
 	GreProtocolNumber = 47
 	MplsOverUdpPort   = 6635
+
+	// Offset of protocol field in IPv4 header
+	ipProtocolOffset = 9
+
+	// Offset of destination port in UDP header
+	udpDestOffset = 2
 )
 
 const (
@@ -39,6 +47,14 @@ var encapFieldGroups = []*skbtrace.FieldGroup{
 			Converter: convMplsLabel, FilterOperator: filtopMplsLabel}}},
 }
 
+// encapAutoFieldGroups provide a field which checks encapsulation of MPLS
+// in auto mode, so MPLS header is only read from UDP with MPLSoUDP port
+var encapAutoFieldGroups = []*skbtrace.FieldGroup{
+	{Row: "outer-encap", Object: ObjIpHdrOuter, Fields: []*skbtrace.Field{
+		{Name: "protocol", FmtKey: "encap", FilterOperator: filtopEncapAuto,
+			Help: "Protocol preceding MPLS header, filter value is MPLSoUDP port"}}},
+}
+
 var encapObj = []*skbtrace.Object{
 	{Variable: ObjIpHdrOuter, HeaderFiles: headerFiles, StructDefs: []string{"iphdr"},
 		Casts: map[string]string{
@@ -55,12 +71,16 @@ var encapGreObj = []*skbtrace.Object{
 		}},
 }
 
+var encapUdpHdrObj = &skbtrace.Object{
+	Variable: ObjUdpHdrOuter, HeaderFiles: headerFiles, StructDefs: []string{"udphdr"},
+	SanityFilter: NewTransportSanityFilter(ObjIpHdrOuter, UdpProtocolNumber),
+	Casts: map[string]string{
+		"$skb": skb.NewDataCastBuilder("udphdr", "head").SetOuterOffset(EthHdrLength + IpHdrMinLength).Build(),
+	},
+}
+
 var encapUdpObj = []*skbtrace.Object{
-	{Variable: ObjUdpHdrOuter, HeaderFiles: headerFiles, StructDefs: []string{"udphdr"},
-		SanityFilter: NewTransportSanityFilter(ObjIpHdrOuter, UdpProtocolNumber),
-		Casts: map[string]string{
-			"$skb": skb.NewDataCastBuilder("udphdr", "head").SetOuterOffset(EthHdrLength + IpHdrMinLength).Build(),
-		}},
+	encapUdpHdrObj,
 	{Variable: ObjMplsHdrOuter, HeaderFiles: headerFiles, StructDefs: []string{"mplshdr"},
 		SanityFilter: skbtrace.Filter{Object: ObjUdpHdrOuter, Field: "dest",
 			Op: "==", Value: strconv.Itoa(MplsOverUdpPort)},
@@ -70,6 +90,19 @@ var encapUdpObj = []*skbtrace.Object{
 		}},
 }
 
+// In auto mode MPLS header is preceded either by GRE or by UDP header which
+// is determined at runtime from outer IP protocol
+var encapAutoObj = []*skbtrace.Object{
+	encapUdpHdrObj,
+	{Variable: ObjMplsHdrOuter, HeaderFiles: headerFiles, StructDefs: []string{"mplshdr"},
+		SanityFilter: skbtrace.Filter{Object: ObjIpHdrOuter, Field: "encap",
+			Op: "==", Value: strconv.Itoa(MplsOverUdpPort)},
+		Casts: map[string]string{
+			"$skb": skb.NewDataCastBuilder("mplshdr", "head").SetInnerHelpers(
+				EncapHeaderLengthFunc).Build(),
+		}},
+}
+
 //go:embed headers/mplshdr.h
 var mplsHdrDef string
 
@@ -96,6 +129,19 @@ func convMplsLabel(obj, field string) ([]skbtrace.Statement, skbtrace.Expression
 	return stmts, skbtrace.Expr("$mpls_label")
 }
 
+// filtopEncapAuto checks that outer IP header is followed either by GRE
+// header or by UDP header with the specified destination port. UDP header
+// is read without cast same way as outer IP protocol in EncapHeaderLength
+func filtopEncapAuto(expr skbtrace.Expression, op, value string) (skbtrace.Expression, error) {
+	port, err := skbtrace.FppNtohs(op, value)
+	if err != nil {
+		return skbtrace.NilExpr, err
+	}
+
+	return skbtrace.Exprf("(%[1]s == %[2]d || (%[1]s == %[3]d && *(uint16*)($skb->head + $skb->mac_header + %[4]d) %[5]s %[6]s))",
+		expr, GreProtocolNumber, UdpProtocolNumber, BaseEncapHdrLength+udpDestOffset, op, port), nil
+}
+
 func filtopMplsLabel(expr skbtrace.Expression, op, value string) (skbtrace.Expression, error) {
 	if op != "==" {
 		return skbtrace.NilExpr, errors.New("MPLS labels only support equality filters")
@@ -117,19 +163,58 @@ func filtopMplsLabel(expr skbtrace.Expression, op, value string) (skbtrace.Expre
 // Basically, this is a shortcut for longer overlay protochain option:
 //   - gre (MPLSoGRE) - a single 4-byte gre header followed by a single MPLS label.
 //   - udp (MPLSoUDP) - same as gre, but with 8-byte udp header.
+//   - auto - either of above depending on outer IP protocol read at runtime.
+//
+// Also registers a function for the length of headers preceding MPLS label.
 func RegisterOverlayLengthFunc(b *skbtrace.Builder, encap string) {
 	b.AddCastFunction(OverlayHeaderLengthFunc,
 		func() (string, error) {
-			switch encap {
-			case EncapProtoUdp:
-				return fmt.Sprint(BaseEncapHdrLength + UdpHdrLength + MplsHdrLength), nil
-			case EncapProtoGre:
-				return fmt.Sprint(BaseEncapHdrLength + GreHdrLength + MplsHdrLength), nil
-			}
-			return "", fmt.Errorf("invalid encapsulation type '%s'", encap)
+			return newEncapLength(encap, MplsHdrLength)
+		})
+	b.AddCastFunction(EncapHeaderLengthFunc,
+		func() (string, error) {
+			return newEncapLength(encap, 0)
 		})
 }
 
+func newEncapLength(encap string, extraLength int) (string, error) {
+	var (
+		udpLength = BaseEncapHdrLength + UdpHdrLength + extraLength
+		greLength = BaseEncapHdrLength + GreHdrLength + extraLength
+	)
+
+	switch encap {
+	case EncapProtoUdp:
+		return fmt.Sprint(udpLength), nil
+	case EncapProtoGre:
+		return fmt.Sprint(greLength), nil
+	case EncapProtoAuto:
+		// Casts are always made from skb object, and outer IP header is read
+		// without cast as struct definition might not be available
+		return fmt.Sprintf("(*(uint8*)($skb->head + $skb->mac_header + %d) == %d ? %d : %d)",
+			EthHdrLength+ipProtocolOffset, GreProtocolNumber, greLength, udpLength), nil
+	}
+	return "", fmt.Errorf("invalid encapsulation type '%s'", encap)
+}
+
+// RegisterEncapSanityFilters ensures that in auto mode inner headers are only
+// read from packets which are encapsulated, i.e. outer IP header is followed
+// by GRE header or by UDP header with MPLSoUDP port. Inner transport headers
+// are checked through sanity filters referring inner IP header.
+// Should be called after inner IP objects are registered.
+func RegisterEncapSanityFilters(b *skbtrace.Builder, encap string, isIPv6 bool) {
+	if encap != EncapProtoAuto {
+		return
+	}
+
+	innerObj := ObjIpHdrInner
+	if isIPv6 {
+		innerObj = ObjIpv6HdrInner
+	}
+	b.SetObjectSanityFilter(innerObj, skbtrace.Filter{Object: ObjIpHdrOuter, Field: "encap",
+		Op: "==", Value: strconv.Itoa(MplsOverUdpPort)})
+}
+
 // An alternative for RegisterOverlayLengthFunc() which builds protochain internally.
 // Can be used for mpls label stacking.
 // NOTE: we should read at ($out_iph->ihl * 4), but do not want to impose extra dependency,
@@ -174,5 +259,8 @@ func RegisterEncap(b *skbtrace.Builder, encap string, featureMask skbtrace.Featu
 		b.AddObjects(encapGreObj)
 	case EncapProtoUdp:
 		b.AddObjects(encapUdpObj)
+	case EncapProtoAuto:
+		b.AddFieldGroups(encapAutoFieldGroups)
+		b.AddObjects(encapAutoObj)
 	}
 }
@@ -2,6 +2,8 @@ package proto
 
 import (
 	_ "embed"
+	"fmt"
+	"net"
 
 	"github.com/yandex-cloud/skbtrace"
 	"github.com/yandex-cloud/skbtrace/pkg/skb"
@@ -44,6 +46,25 @@ func convEthMac(obj, field string) ([]skbtrace.Statement, skbtrace.Expression) {
 	return nil, skbtrace.ExprJoin(byteExprs)
 }
 
+// FiltopMac compares byte array containing MAC address with
+// address specified in colon-separated notation
+func FiltopMac(expr skbtrace.Expression, op, value string) (skbtrace.Expression, error) {
+	if op != "==" {
+		return skbtrace.NilExpr, fmt.Errorf("MAC addresses can be compared only with equals")
+	}
+
+	mac, err := net.ParseMAC(value)
+	if err != nil || len(mac) != 6 {
+		return skbtrace.NilExpr, fmt.Errorf("invalid MAC address '%s'", value)
+	}
+
+	var exprs []skbtrace.Expression
+	for i, b := range mac {
+		exprs = append(exprs, skbtrace.Exprf("%s[%d] == 0x%02x", expr, i, b))
+	}
+	return skbtrace.ExprJoinOp(exprs, "&&"), nil
+}
+
 func RegisterEth(b *skbtrace.Builder, featureMask skbtrace.FeatureFlagMask) {
 	b.AddFieldGroups(newEthFieldGroups(featureMask))
 	b.AddStructDef("machdr", macHdrDef)
@@ -0,0 +1,12 @@
+struct {
+    uint8_t type;
+    uint8_t code;
+    uint16_t checksum;
+    union {
+        struct {
+            uint16_t id;
+            uint16_t sequence;
+        } echo;
+        uint32_t mtu;
+    };
+} __attribute__((packed));
@@ -0,0 +1,15 @@
+struct {
+    uint8_t type;
+    uint8_t code;
+    uint16_t checksum;
+    union {
+        struct {
+            uint16_t id;
+            uint16_t sequence;
+        } echo;
+        struct {
+            uint16_t unused;
+            uint16_t mtu;
+        } frag;
+    };
+} __attribute__((packed));
@@ -0,0 +1,15 @@
+struct {
+    uint8_t type;
+    uint8_t code;
+    uint16_t checksum;
+    uint8_t flags;
+    uint8_t reserved[3];
+    union {
+        uint8_t  target8[16];
+        uint16_t target16[8];
+        uint32_t target32[4];
+    };
+    uint8_t opt_type;
+    uint8_t opt_len;
+    uint8_t opt_lladdr[6];
+} __attribute__((packed));
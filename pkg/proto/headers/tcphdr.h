@@ -10,5 +10,5 @@ struct {
     uint16_t check;
     uint16_t urg_ptr;
 
-    uint8_t options[8];
+    uint8_t options[40];
 } __attribute__((packed));
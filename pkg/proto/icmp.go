@@ -0,0 +1,175 @@
+package proto
+
+import (
+	_ "embed"
+	"fmt"
+	"strconv"
+
+	"github.com/yandex-cloud/skbtrace"
+	"github.com/yandex-cloud/skbtrace/pkg/skb"
+)
+
+const (
+	ObjIcmpHdr       = "$icmph"
+	ObjIcmpHdrInner  = "$in_icmph"
+	ObjIcmp6Hdr      = "$icmp6h"
+	ObjIcmp6HdrInner = "$in_icmp6h"
+
+	IcmpProtocolNumber  = 1
+	Icmp6ProtocolNumber = 58
+)
+
+// icmpMnemonic maps human-readable name of ICMP type or code
+// used in filters to its numeric value
+type icmpMnemonic struct {
+	name  string
+	value uint8
+}
+
+var icmpTypes = []icmpMnemonic{
+	{"echo-reply", 0},
+	{"dest-unreachable", 3},
+	{"source-quench", 4},
+	{"redirect", 5},
+	{"echo-request", 8},
+	{"router-advertisement", 9},
+	{"router-solicitation", 10},
+	{"time-exceeded", 11},
+	{"parameter-problem", 12},
+	{"timestamp-request", 13},
+	{"timestamp-reply", 14},
+}
+
+var icmpCodes = []icmpMnemonic{
+	{"net-unreachable", 0},
+	{"host-unreachable", 1},
+	{"protocol-unreachable", 2},
+	{"port-unreachable", 3},
+	{"frag-needed", 4},
+	{"source-route-failed", 5},
+	{"admin-prohibited", 13},
+}
+
+var icmp6Types = []icmpMnemonic{
+	{"dest-unreachable", 1},
+	{"packet-too-big", 2},
+	{"time-exceeded", 3},
+	{"parameter-problem", 4},
+	{"echo-request", 128},
+	{"echo-reply", 129},
+	{"router-solicitation", 133},
+	{"router-advertisement", 134},
+	{"neighbor-solicitation", 135},
+	{"neighbor-advertisement", 136},
+	{"redirect", 137},
+}
+
+var icmp6Codes = []icmpMnemonic{
+	{"no-route", 0},
+	{"admin-prohibited", 1},
+	{"beyond-scope", 2},
+	{"address-unreachable", 3},
+	{"port-unreachable", 4},
+}
+
+func newIcmpRows(
+	featureMask skbtrace.FeatureFlagMask, types, codes []icmpMnemonic, mtuField string, mtuBitSize uint,
+) [][]*skbtrace.Field {
+	ntohs := skbtrace.NewBSwapConv(featureMask, 16)
+
+	var icmpFieldsRow1 = []*skbtrace.Field{
+		{Name: "type", Alias: "icmp-type", WeakAlias: true, Preprocessor: newFppIcmpMnemonic("type", types),
+			Help: "ICMP message type. Filters also accept mnemonics such as " + types[0].name},
+		{Name: "code", Alias: "icmp-code", WeakAlias: true, Preprocessor: newFppIcmpMnemonic("code", codes),
+			Help: "ICMP message code. Filters also accept mnemonics such as " + codes[0].name},
+		{Name: "checksum", FmtKey: "check", FmtSpec: "%x", Converter: ntohs,
+			Help: "Checksum of ICMP message, which also covers IPv6 pseudo-header for ICMPv6"},
+	}
+	var icmpFieldsRow2 = []*skbtrace.Field{
+		{Name: "echo.id", FmtKey: "id", Alias: "icmp-id", WeakAlias: true,
+			Converter: ntohs, Preprocessor: skbtrace.FppNtohs,
+			Help: "Identifier of echo request or reply"},
+		{Name: "echo.sequence", FmtKey: "seq", Alias: "icmp-seq", WeakAlias: true,
+			Converter: ntohs, Preprocessor: skbtrace.FppNtohs,
+			Help: "Sequence number of echo request or reply"},
+		{Name: mtuField, FmtKey: "mtu", Converter: skbtrace.NewBSwapConv(featureMask, mtuBitSize),
+			Help: "Next-hop MTU reported by fragmentation needed or packet too big message"},
+	}
+
+	return [][]*skbtrace.Field{icmpFieldsRow1, icmpFieldsRow2}
+}
+
+var icmpFieldGroup = skbtrace.FieldGroup{Object: ObjIcmpHdr, Row: "icmp"}
+var icmp6FieldGroup = skbtrace.FieldGroup{Object: ObjIcmp6Hdr, Row: "icmpv6"}
+
+//go:embed headers/icmphdr.h
+var icmpHdrDef string
+
+//go:embed headers/icmp6hdr.h
+var icmp6HdrDef string
+
+var objIcmp = []*skbtrace.Object{
+	{Variable: ObjIcmpHdr, HeaderFiles: headerFiles, StructDefs: []string{"icmphdr"},
+		SanityFilter: NewTransportSanityFilter(ObjIpHdr, IcmpProtocolNumber),
+		Casts: map[string]string{
+			"$skb": skb.NewDataCastBuilder("icmphdr", "head").SetField("network_header").SetInnerHelpers(
+				InnerIpHeaderLengthFunc).Build(),
+		}},
+	{Variable: ObjIcmpHdrInner, HeaderFiles: headerFiles, StructDefs: []string{"icmphdr"},
+		SanityFilter: NewTransportSanityFilter(ObjIpHdrInner, IcmpProtocolNumber),
+		Casts: map[string]string{
+			"$skb": skb.NewDataCastBuilder("icmphdr", "head").SetInnerHelpers(headerFuncsTrans...).Build(),
+		}},
+}
+
+var objIcmp6 = []*skbtrace.Object{
+	{Variable: ObjIcmp6Hdr, HeaderFiles: headerFiles, StructDefs: []string{"icmp6hdr"},
+		SanityFilter: newIpv6NextHeaderSanityFilter(ObjIpv6Hdr, Icmp6ProtocolNumber),
+		Casts: map[string]string{
+			"$skb": skb.NewDataCastBuilder("icmp6hdr", "head").SetField("network_header").SetInnerHelpers(
+				InnerIpHeaderLengthFunc).Build(),
+		}},
+	{Variable: ObjIcmp6HdrInner, HeaderFiles: headerFiles, StructDefs: []string{"icmp6hdr"},
+		SanityFilter: newIpv6NextHeaderSanityFilter(ObjIpv6HdrInner, Icmp6ProtocolNumber),
+		Casts: map[string]string{
+			"$skb": skb.NewDataCastBuilder("icmp6hdr", "head").SetInnerHelpers(headerFuncsTrans...).Build(),
+		}},
+}
+
+func newIpv6NextHeaderSanityFilter(obj string, protoNum int) skbtrace.Filter {
+	return skbtrace.Filter{Object: obj, Field: "nexthdr", Op: "==", Value: strconv.Itoa(protoNum)}
+}
+
+// newFppIcmpMnemonic creates a preprocessor which accepts either numeric
+// values or mnemonics from the table
+func newFppIcmpMnemonic(kind string, mnemonics []icmpMnemonic) skbtrace.FieldPreprocessor {
+	return func(op, value string) (string, error) {
+		if _, err := strconv.ParseUint(value, 0, 8); err == nil {
+			return value, nil
+		}
+
+		for _, mnemonic := range mnemonics {
+			if mnemonic.name == value {
+				return strconv.Itoa(int(mnemonic.value)), nil
+			}
+		}
+		return "", fmt.Errorf("unknown ICMP %s mnemonic '%s'", kind, value)
+	}
+}
+
+// RegisterIcmp registers both ICMP and ICMPv6 headers regardless of IPv6 mode,
+// so rows of both families are listed. Weak aliases such as icmp-type are
+// resolved to the family of IP headers in use.
+func RegisterIcmp(b *skbtrace.Builder, featureMask skbtrace.FeatureFlagMask) {
+	icmpRows := newIcmpRows(featureMask, icmpTypes, icmpCodes, "frag.mtu", 16)
+	b.AddFieldGroupTemplate(icmpFieldGroup, icmpRows)
+	b.AddFieldGroupTemplate(icmpFieldGroup.Wrap(ObjIcmpHdrInner, "inner"), icmpRows)
+	b.AddObjects(objIcmp)
+	b.AddStructDef("icmphdr", icmpHdrDef)
+
+	icmp6Rows := newIcmpRows(featureMask, icmp6Types, icmp6Codes, "mtu", 32)
+	b.AddFieldGroupTemplate(icmp6FieldGroup, icmp6Rows)
+	b.AddFieldGroupTemplate(icmp6FieldGroup.Wrap(ObjIcmp6HdrInner, "inner"), icmp6Rows)
+	b.AddObjects(objIcmp6)
+	b.AddStructDef("icmp6hdr", icmp6HdrDef)
+}
@@ -40,7 +40,7 @@ func newIpRows(featureMask skbtrace.FeatureFlagMask) [][]*skbtrace.Field {
 	}
 	var ipFieldsRow2 = []*skbtrace.Field{
 		{Name: "id", Alias: "id", FmtSpec: "%d", Converter: ntohs},
-		{Name: "ttl",
+		{Name: "ttl", Alias: "ttl", WeakAlias: true,
 			Help: "IP Time To Live"},
 		{Name: "protocol",
 			Help: "IP Protocol Number as decimal (6 - TCP, 17 - UDP, 1 - ICMP)"},
@@ -65,7 +65,8 @@ func newIpv6Rows(featureMask skbtrace.FeatureFlagMask) [][]*skbtrace.Field {
 	}
 	var ipv6FieldRow2 = []*skbtrace.Field{
 		{Name: "nexthdr"},
-		{Name: "hop_limit"},
+		{Name: "hop_limit", Alias: "ttl", WeakAlias: true,
+			Help: "IPv6 Hop Limit"},
 		{Name: "saddr8", Alias: "src", WeakAlias: true, FmtKey: "src", FmtSpec: "%s",
 			Converter: ConvNtopInet6, ConverterMask: skbtrace.ConverterDump | skbtrace.ConverterHiddenKey,
 			FilterOperator: FiltopPtonInet6, Help: "Source IP Address. " + ipAddressNote},
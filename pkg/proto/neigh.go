@@ -0,0 +1,126 @@
+package proto
+
+import (
+	_ "embed"
+	"strconv"
+
+	"github.com/yandex-cloud/skbtrace"
+	"github.com/yandex-cloud/skbtrace/pkg/skb"
+)
+
+const (
+	ObjArpHdr = "$arph"
+	ObjNdMsg  = "$ndh"
+
+	EthProtocolArp = "0x0806"
+)
+
+var arpOps = map[string]int{
+	"request": 1,
+	"reply":   2,
+}
+
+// Neighbor Advertisement flags
+var ndFlags = []tcpFlag{
+	{'R', 0x80},
+	{'S', 0x40},
+	{'O', 0x20},
+}
+
+func newArpFieldGroups(featureMask skbtrace.FeatureFlagMask) []*skbtrace.FieldGroup {
+	ntohs := skbtrace.NewBSwapConv(featureMask, 16)
+
+	return []*skbtrace.FieldGroup{
+		{Row: "arp", Object: ObjArpHdr, Fields: []*skbtrace.Field{
+			{Name: "htype", Converter: ntohs, Preprocessor: skbtrace.FppNtohs,
+				Help: "ARP hardware type (1 - Ethernet)"},
+			{Name: "ptype", FmtSpec: "0x%04x", Converter: ntohs, Preprocessor: skbtrace.FppNtohs,
+				Help: "ARP protocol type (0x0800 - IPv4)"},
+			{Name: "oper", FmtKey: "op", Alias: "arp-op", Converter: ntohs, Preprocessor: fppArpOp,
+				Help: "ARP operation: 1 - request, 2 - reply. Filters also accept mnemonics."}}},
+		{Row: "arp", Object: ObjArpHdr, Fields: []*skbtrace.Field{
+			{Name: "sha", Alias: "arp-sha", FmtSpec: ethMacFmtSpec, Converter: convEthMac,
+				FilterOperator: FiltopMac, Help: "Sender hardware (MAC) address"},
+			{Name: "spa", Alias: "arp-sip", FmtSpec: "%s", Converter: ConvNtopInet, Preprocessor: FppPtonInet,
+				Help: "Sender protocol (IP) address. " + ipAddressNote}}},
+		{Row: "arp", Object: ObjArpHdr, Fields: []*skbtrace.Field{
+			{Name: "tha", Alias: "arp-tha", FmtSpec: ethMacFmtSpec, Converter: convEthMac,
+				FilterOperator: FiltopMac, Help: "Target hardware (MAC) address"},
+			{Name: "tpa", Alias: "arp-tip", FmtSpec: "%s", Converter: ConvNtopInet, Preprocessor: FppPtonInet,
+				Help: "Target protocol (IP) address. " + ipAddressNote}}},
+	}
+}
+
+var ndFieldGroups = []*skbtrace.FieldGroup{
+	{Row: "nd", Object: ObjNdMsg, Fields: []*skbtrace.Field{
+		{Name: "target8", Alias: "nd-target", FmtKey: "target", FmtSpec: "%s",
+			Converter: ConvNtopInet6, ConverterMask: skbtrace.ConverterDump | skbtrace.ConverterHiddenKey,
+			FilterOperator: FiltopPtonInet6, Help: "Target address of Neighbor Solicitation/Advertisement. " +
+				ipAddressNote},
+		{Name: "flags", FmtSpec: "%s%s%s", Converter: convNdFlags,
+			Help: "Neighbor Advertisement flags: R - router, S - solicited, O - override"}}},
+	{Row: "nd", Object: ObjNdMsg, Fields: []*skbtrace.Field{
+		{Name: "opt_type", FmtKey: "opt",
+			Help: "Type of the first ND option: 1 - source link-layer address, 2 - target link-layer address"},
+		{Name: "opt_len", FmtKey: "optlen",
+			Help: "Length of the first ND option in 8-byte units"}}},
+	{Row: "nd", Object: ObjNdMsg, Fields: []*skbtrace.Field{
+		{Name: "opt_lladdr", Alias: "nd-lladdr", FmtKey: "lladdr", FmtSpec: ethMacFmtSpec,
+			Converter: convEthMac, FilterOperator: FiltopMac,
+			Help: "Link-layer address carried in the first ND option"}}},
+}
+
+//go:embed headers/arphdr.h
+var arpHdrDef string
+
+//go:embed headers/ndmsg.h
+var ndMsgDef string
+
+var objArp = []*skbtrace.Object{
+	{Variable: ObjArpHdr, HeaderFiles: headerFiles, StructDefs: []string{"arphdr"},
+		SanityFilter: skbtrace.Filter{Object: "$eth_hdr", Field: "protocol", Op: "==", Value: EthProtocolArp},
+		Casts: map[string]string{
+			"$skb": skb.NewDataCastBuilder("arphdr", "head").SetOuterOffset(EthHdrLength).Build(),
+		}},
+}
+
+var objNd = []*skbtrace.Object{
+	{Variable: ObjNdMsg, HeaderFiles: headerFiles, StructDefs: []string{"ndmsg"},
+		SanityFilter: skbtrace.Filter{Object: ObjIcmp6Hdr, Field: "type", Op: "==",
+			Value: "neighbor-solicitation|neighbor-advertisement"},
+		Casts: map[string]string{
+			"$skb": skb.NewDataCastBuilder("ndmsg", "head").SetField("network_header").SetInnerHelpers(
+				InnerIpHeaderLengthFunc).Build(),
+		}},
+}
+
+func fppArpOp(op, value string) (string, error) {
+	if opValue, ok := arpOps[value]; ok {
+		value = strconv.Itoa(opValue)
+	}
+	return skbtrace.FppNtohs(op, value)
+}
+
+func convNdFlags(obj, field string) ([]skbtrace.Statement, skbtrace.Expression) {
+	var flagExprs []skbtrace.Expression
+	for _, flag := range ndFlags {
+		flagExprs = append(flagExprs,
+			skbtrace.Exprf(`(%s & 0x%x) ? "%s" : "-"`, skbtrace.ExprField(obj, field), flag.val, []byte{flag.chr}))
+	}
+	return nil, skbtrace.ExprJoin(flagExprs)
+}
+
+// RegisterNeigh registers neighbor resolution protocols: ARP is always available
+// as it is carried directly over Ethernet, while Neighbor Discovery requires
+// ICMPv6 objects, and hence IPv6 mode.
+func RegisterNeigh(b *skbtrace.Builder, isIPv6 bool, featureMask skbtrace.FeatureFlagMask) {
+	b.AddFieldGroups(newArpFieldGroups(featureMask))
+	b.AddObjects(objArp)
+	b.AddStructDef("arphdr", arpHdrDef)
+
+	if isIPv6 {
+		b.AddFieldGroups(ndFieldGroups)
+		b.AddObjects(objNd)
+		b.AddStructDef("ndmsg", ndMsgDef)
+	}
+}
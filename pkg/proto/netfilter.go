@@ -0,0 +1,193 @@
+package proto
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/yandex-cloud/skbtrace"
+)
+
+const (
+	ObjNfHookState = "$nf_state"
+	ObjXtTable     = "$xt_table"
+	ObjNftPktInfo  = "$nft_pkt"
+	ObjNftChain    = "$nft_chain"
+
+	NfHookAlias     = "nf-hook"
+	NfPfAlias       = "nf-pf"
+	NfTableAlias    = "nf-table"
+	NftTableAlias   = "nft-table"
+	NftChainAlias   = "nft-chain"
+	NfVerdictAlias  = "nf-verdict"
+	NfVerdictsTable = "nf_verdicts"
+	NfHooksTable    = "nf_hooks"
+
+	ProbeNfHookSlow = "kretprobe:nf_hook_slow"
+	ProbeIptDoTable = "kretprobe:ipt_do_table"
+	ProbeNftDoChain = "kretprobe:nft_do_chain"
+)
+
+var netfilterHeaderFiles = []string{"linux/netfilter.h"}
+
+var nftPktInfoStateFeature = &skbtrace.Feature{
+	Component: skbtrace.FeatureComponentKernel,
+	Name:      "nft_pktinfo:state",
+	Help:      "nft_pktinfo keeps hook state directly rather than in embedded xt_action_param",
+
+	Commit:     "897389de4828",
+	MinVersion: skbtrace.Version{Major: 5, Submajor: 15, Minor: 0},
+}
+
+var iptDoTablePrivFeature = &skbtrace.Feature{
+	Component: skbtrace.FeatureComponentKernel,
+	Name:      "ipt_do_table:priv",
+	Help:      "ipt_do_table is used as a hook function and receives table as its first argument",
+
+	Commit:     "e8d225b60030",
+	MinVersion: skbtrace.Version{Major: 5, Submajor: 16, Minor: 0},
+}
+
+// NfVerdicts are names of netfilter verdicts as defined in
+// include/uapi/linux/netfilter.h indexed by their values
+var NfVerdicts = []string{"DROP", "ACCEPT", "STOLEN", "QUEUE", "REPEAT", "STOP"}
+
+// NfHooks are names of netfilter inet hooks indexed by their numbers
+var NfHooks = []string{"PRE_ROUTING", "LOCAL_IN", "FORWARD", "LOCAL_OUT", "POST_ROUTING"}
+
+// nfVerdictMask is NF_VERDICT_MASK which separates verdict from the error
+// code or queue number kept in its upper bits
+const nfVerdictMask = 0xff
+
+const nfVerdictNote = "Filters also accept mnemonics such as DROP or ACCEPT."
+
+func newFppNames(kind string, names []string) skbtrace.FieldPreprocessor {
+	return func(op, value string) (string, error) {
+		if _, err := strconv.ParseInt(value, 0, 32); err == nil {
+			return value, nil
+		}
+
+		for i, name := range names {
+			if name == value {
+				return strconv.Itoa(i), nil
+			}
+		}
+		return "", fmt.Errorf("unknown netfilter %s mnemonic '%s'", kind, value)
+	}
+}
+
+// convNfVerdict prints verdict returned by the probe along with its name
+func convNfVerdict(obj, field string) ([]skbtrace.Statement, skbtrace.Expression) {
+	return nil, skbtrace.Exprf("%[1]s & %#[3]x, @%[2]s[%[1]s & %#[3]x]", field, NfVerdictsTable, nfVerdictMask)
+}
+
+// filtopNfVerdict compares verdict without error code or queue number
+func filtopNfVerdict(expr skbtrace.Expression, op, value string) (skbtrace.Expression, error) {
+	return skbtrace.Exprf("(%s & %#x) %s %s", expr, nfVerdictMask, op, value), nil
+}
+
+func newNetfilterFieldGroups() []*skbtrace.FieldGroup {
+	strConv := func(field string) skbtrace.FieldConverter {
+		return skbtrace.NewObjectConvExpr("str(%[1]s->" + field + ")")
+	}
+	strConvMask := skbtrace.ConverterDump | skbtrace.ConverterHiddenKey | skbtrace.ConverterFilter
+
+	return []*skbtrace.FieldGroup{
+		{Row: "nf-hook", Object: ObjNfHookState, Fields: []*skbtrace.Field{
+			{Name: "hook", Alias: NfHookAlias, FmtSpec: "%d (%s)",
+				Converter: skbtrace.NewLookupConv(NfHooksTable), LookupTable: NfHooksTable,
+				Preprocessor: newFppNames("hook", NfHooks),
+				Help:         "Netfilter hook number: 0 - PRE_ROUTING, 1 - LOCAL_IN, ..., 4 - POST_ROUTING"},
+			{Name: "pf", Alias: NfPfAlias,
+				Help: "Protocol family of the hook: 2 - IPv4, 10 - IPv6, 7 - bridge"}}},
+		{Row: "nf-table", Object: ObjXtTable, Fields: []*skbtrace.Field{
+			{Name: "name", Alias: NfTableAlias, FmtKey: "table", FmtSpec: "%s",
+				Help: "Name of iptables table such as filter or nat"}}},
+		{Row: "nft-chain", Object: ObjNftChain, Fields: []*skbtrace.Field{
+			{Name: "table->name", Alias: NftTableAlias, FmtKey: "table", FmtSpec: "%s",
+				Converter: strConv("table->name"), ConverterMask: strConvMask,
+				Help: "Name of nftables table"},
+			{Name: "name", Alias: NftChainAlias, FmtKey: "chain", FmtSpec: "%s",
+				Converter: strConv("name"), ConverterMask: strConvMask,
+				Help: "Name of nftables chain"}}},
+
+		// Return value of netfilter probes
+		{Row: "nf-verdict", Fields: []*skbtrace.Field{
+			{Name: "retval", Alias: NfVerdictAlias, FmtKey: "verdict", FmtSpec: "%d (%s)",
+				Converter: convNfVerdict, LookupTable: NfVerdictsTable,
+				Preprocessor: newFppNames("verdict", NfVerdicts), FilterOperator: filtopNfVerdict,
+				Help: "Verdict without error code or queue number: 0 - DROP, 1 - ACCEPT, 2 - STOLEN," +
+					" 3 - QUEUE. For nf_hook_slow it is 1 if packet passed all hooks, 0 if it was stolen" +
+					" and 255 (lower byte of negative error) if dropped. " + nfVerdictNote}}},
+	}
+}
+
+func newNetfilterObjects(kernelFeatureMask skbtrace.FeatureFlagMask) []*skbtrace.Object {
+	nftStateCast := `{{ .Dst }} = {{ .Src }}->xt.state`
+	if kernelFeatureMask.Supports(nftPktInfoStateFeature) {
+		nftStateCast = `{{ .Dst }} = {{ .Src }}->state`
+	}
+
+	return []*skbtrace.Object{
+		{Variable: "state"},
+		{Variable: ObjNfHookState, HeaderFiles: netfilterHeaderFiles,
+			Casts: map[string]string{
+				"state":       `{{ .Dst }} = ({{ StructKeyword }}nf_hook_state*) {{ .Src }}`,
+				ObjNftPktInfo: nftStateCast,
+			}},
+		{Variable: "table"},
+		{Variable: ObjXtTable, HeaderFiles: []string{"linux/netfilter/x_tables.h"},
+			Casts: map[string]string{
+				"table": `{{ .Dst }} = ({{ StructKeyword }}xt_table*) {{ .Src }}`,
+			}},
+		{Variable: "pkt"},
+		{Variable: ObjNftPktInfo, HeaderFiles: []string{"net/netfilter/nf_tables.h"},
+			Casts: map[string]string{
+				"pkt": `{{ .Dst }} = ({{ StructKeyword }}nft_pktinfo*) {{ .Src }}`,
+			}},
+		{Variable: "chain"},
+		{Variable: ObjNftChain, HeaderFiles: []string{"net/netfilter/nf_tables.h"},
+			Casts: map[string]string{
+				"chain": `{{ .Dst }} = ({{ StructKeyword }}nft_chain*) {{ .Src }}`,
+			}},
+	}
+}
+
+var netfilterSkbCasts = []*skbtrace.Object{
+	{Variable: "$skb", Casts: map[string]string{
+		ObjNftPktInfo: `{{ .Dst }} = {{ .Src }}->skb`,
+	}},
+}
+
+func newNetfilterProbes(kernelFeatureMask skbtrace.FeatureFlagMask) []*skbtrace.Probe {
+	iptArgs := map[string]string{"skb": "arg0", "state": "arg1", "table": "arg2"}
+	if kernelFeatureMask.Supports(iptDoTablePrivFeature) {
+		iptArgs = map[string]string{"table": "arg0", "skb": "arg1", "state": "arg2"}
+	}
+
+	return []*skbtrace.Probe{
+		{Name: ProbeNfHookSlow, Aliases: []string{"nf-hook"},
+			Args: map[string]string{"skb": "arg0", "state": "arg1"}, StashArgs: true,
+			Help: "nf_hook_slow() returns when packet traversed netfilter hook which has rules"},
+		{Name: ProbeIptDoTable, Aliases: []string{"ipt-do-table"}, Args: iptArgs, StashArgs: true,
+			Help: "ipt_do_table() returns when packet was evaluated by iptables table"},
+		{Name: ProbeNftDoChain, Aliases: []string{"nft-do-chain"},
+			Args: map[string]string{"pkt": "arg0", "chain": "arg1"}, StashArgs: true,
+			Help: "nft_do_chain() returns when packet was evaluated by nftables base chain"},
+	}
+}
+
+// RegisterNetfilter registers netfilter hook state and table objects along
+// with probes of hook and table traversal
+func RegisterNetfilter(b *skbtrace.Builder, kernelFeatureMask skbtrace.FeatureFlagMask) {
+	b.AddFieldGroups(newNetfilterFieldGroups())
+	b.AddObjects(newNetfilterObjects(kernelFeatureMask))
+	b.AddObjectCasts(netfilterSkbCasts)
+	b.AddProbes(newNetfilterProbes(kernelFeatureMask))
+
+	b.AddLookupTable(NfVerdictsTable, newNamesLookupTable(NfVerdicts))
+	b.AddLookupTable(NfHooksTable, newNamesLookupTable(NfHooks))
+}
+
+func init() {
+	skbtrace.RegisterFeatures(nftPktInfoStateFeature, iptDoTablePrivFeature)
+}
@@ -0,0 +1,300 @@
+package proto
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/yandex-cloud/skbtrace"
+	"github.com/yandex-cloud/skbtrace/pkg/skb"
+)
+
+const (
+	ObjFibTable  = "$fib_tb"
+	ObjFibResult = "$fib_res"
+	ObjFibNh     = "$fib_nh"
+	ObjFlow      = "$fl"
+	ObjRtable    = "$rt"
+
+	RouteTableAlias = "route-table"
+	RouteErrAlias   = "route-err"
+	RouteTypeAlias  = "route-type"
+	RouteDevAlias   = "route-dev"
+	RouteGwAlias    = "route-gw"
+	RouteSrcAlias   = "route-src"
+	RouteDstAlias   = "route-dst"
+
+	RouteTypesTable = "route_types"
+
+	ProbeFibTableLookup  = "kretprobe:fib_table_lookup"
+	ProbeFib6TableLookup = "kretprobe:fib6_table_lookup"
+	ProbeRouteInput      = "kretprobe:ip_route_input_noref"
+)
+
+var fibHeaderFiles = []string{"net/ip_fib.h"}
+var fib6HeaderFiles = []string{"net/ip6_fib.h"}
+
+var fibNhCommonFeature = &skbtrace.Feature{
+	Component: skbtrace.FeatureComponentKernel,
+	Name:      "fib_result:nhc",
+	Help:      "fib_result refers to common nexthop structure and rtable keeps gateway in rt_gw4",
+
+	MinVersion: skbtrace.Version{Major: 5, Submajor: 2, Minor: 0},
+}
+
+var fib6ResultFeature = &skbtrace.Feature{
+	Component: skbtrace.FeatureComponentKernel,
+	Name:      "fib6_result",
+	Help:      "fib6_table_lookup fills fib6_result rather than returns route",
+
+	MinVersion: skbtrace.Version{Major: 5, Submajor: 2, Minor: 0},
+}
+
+// RouteTypes are names of route types as defined in include/uapi/linux/rtnetlink.h
+// indexed by their values
+var RouteTypes = []string{
+	"UNSPEC", "UNICAST", "LOCAL", "BROADCAST", "ANYCAST", "MULTICAST",
+	"BLACKHOLE", "UNREACHABLE", "PROHIBIT", "THROW", "NAT", "XRESOLVE",
+}
+
+// RouteErrors are errors returned by fib lookup. EAGAIN means that the table
+// doesn't have matching route and the next table is looked up. Values are
+// Linux errno numbers as they are returned by the kernel, and not of the host
+// skbtrace is built for.
+var RouteErrors = map[string]int{
+	"EAGAIN":       11,
+	"EINVAL":       22,
+	"EACCES":       13,
+	"ENETUNREACH":  101,
+	"EHOSTUNREACH": 113,
+}
+
+const routeNote = "Filters also accept mnemonics such as UNICAST or BLACKHOLE."
+
+func fppRouteType(op, value string) (string, error) {
+	if _, err := strconv.ParseUint(value, 0, 8); err == nil {
+		return value, nil
+	}
+
+	for i, routeType := range RouteTypes {
+		if routeType == value {
+			return strconv.Itoa(i), nil
+		}
+	}
+	return "", fmt.Errorf("unknown route type mnemonic '%s'", value)
+}
+
+func fppRouteErr(op, value string) (string, error) {
+	if _, err := strconv.ParseInt(value, 0, 32); err == nil {
+		return value, nil
+	}
+
+	if errno, ok := RouteErrors[value]; ok {
+		return strconv.Itoa(-errno), nil
+	}
+	return "", fmt.Errorf("unknown route error mnemonic '%s'", value)
+}
+
+// newConvRetvalInt returns converter which interprets return value
+// as a signed int as upper half of register is not set
+func newConvRetvalInt(mask skbtrace.FeatureFlagMask) skbtrace.FieldConverter {
+	return func(obj, field string) ([]skbtrace.Statement, skbtrace.Expression) {
+		if mask.Supports(skbtrace.FeatureBuiltinTypes) {
+			return nil, skbtrace.Exprf("(%s)%s", skbtrace.TInt32, field)
+		}
+		return nil, skbtrace.Expr(field)
+	}
+}
+
+func newRouteFieldGroups(
+	isIPv6 bool, bpfTraceFeatureMask, kernelFeatureMask skbtrace.FeatureFlagMask,
+) []*skbtrace.FieldGroup {
+	// Route addresses use same hidden key representation as ip addresses, so
+	// they can be matched against packet keys. Gateway is always converted to
+	// be printable from the maps.
+	addrConvMask := skbtrace.ConverterDump | skbtrace.ConverterHiddenKey
+
+	var tableField, typeField, devField, gwField, srcField, dstField *skbtrace.Field
+	if isIPv6 {
+		tableField = &skbtrace.Field{Name: "tb6_id"}
+		typeField = &skbtrace.Field{Name: "f6i->fib6_type"}
+		devField = &skbtrace.Field{Name: "nh_common.nhc_dev->ifindex"}
+		gwField = &skbtrace.Field{Name: "nh_common.nhc_gw.ipv6.in6_u.u6_addr8",
+			Converter: ConvNtopInet6, FilterOperator: FiltopPtonInet6}
+		srcField = &skbtrace.Field{Name: "saddr.in6_u.u6_addr8",
+			Converter: ConvNtopInet6, FilterOperator: FiltopPtonInet6, ConverterMask: addrConvMask}
+		dstField = &skbtrace.Field{Name: "daddr.in6_u.u6_addr8",
+			Converter: ConvNtopInet6, FilterOperator: FiltopPtonInet6, ConverterMask: addrConvMask}
+	} else {
+		tableField = &skbtrace.Field{Name: "tb_id"}
+		typeField = &skbtrace.Field{Name: "type"}
+		devField = &skbtrace.Field{Name: "fi->fib_nh[0].nh_dev->ifindex"}
+		gwField = &skbtrace.Field{Name: "fi->fib_nh[0].nh_gw",
+			Converter: ConvNtopInet, Preprocessor: FppPtonInet}
+		if kernelFeatureMask.Supports(fibNhCommonFeature) {
+			devField.Name = "nhc_dev->ifindex"
+			gwField.Name = "nhc_gw.ipv4"
+		}
+		srcField = &skbtrace.Field{Name: "saddr", Converter: ConvNtopInet, Preprocessor: FppPtonInet}
+		dstField = &skbtrace.Field{Name: "daddr", Converter: ConvNtopInet, Preprocessor: FppPtonInet}
+	}
+
+	tableField.Alias, tableField.FmtKey = RouteTableAlias, "table"
+	tableField.Help = "Id of routing table: 254 - main, 255 - local"
+
+	typeField.Alias, typeField.FmtKey, typeField.FmtSpec = RouteTypeAlias, "type", "%d (%s)"
+	typeField.Converter, typeField.LookupTable = skbtrace.NewLookupConv(RouteTypesTable), RouteTypesTable
+	typeField.Preprocessor = fppRouteType
+	typeField.Help = "Type of the route found by lookup, valid only if route-err is 0: 1 - UNICAST, 2 - LOCAL, 5 - MULTICAST. " + routeNote
+
+	devField.Alias, devField.FmtKey, devField.FmtSpec = RouteDevAlias, "dev", "%d (%s)"
+	devField.Converter, devField.LookupTable = skbtrace.NewLookupConv(skb.IfnamesTable), skb.IfnamesTable
+	devField.Help = "Index of the output device of the route"
+
+	gwField.Alias, gwField.FmtKey, gwField.FmtSpec = RouteGwAlias, "gw", "%s"
+	gwField.ConverterMask = addrConvMask
+	gwField.Help = "Gateway of the route, zero address if destination is directly connected. " + ipAddressNote
+
+	srcField.Alias, srcField.FmtKey, srcField.FmtSpec = RouteSrcAlias, "src", "%s"
+	srcField.Help = "Source IP Address route is looked up for. " + ipAddressNote
+
+	dstField.Alias, dstField.FmtKey, dstField.FmtSpec = RouteDstAlias, "dst", "%s"
+	dstField.Help = "Destination IP Address route is looked up for. " + ipAddressNote
+
+	return []*skbtrace.FieldGroup{
+		{Row: "route-lookup", Object: ObjFibTable, Fields: []*skbtrace.Field{tableField}},
+		{Row: "route-lookup", Fields: []*skbtrace.Field{
+			{Name: "retval", Alias: RouteErrAlias, FmtKey: "err",
+				Converter: newConvRetvalInt(bpfTraceFeatureMask),
+				ConverterMask: skbtrace.ConverterDump | skbtrace.ConverterHiddenKey |
+					skbtrace.ConverterFilter,
+				Preprocessor: fppRouteErr,
+				Help: "Result of the lookup in the table: 0 if route is found, -EAGAIN (-11)" +
+					" if table doesn't contain route. Filters also accept mnemonics such as EHOSTUNREACH."}}},
+		{Row: "route", Object: ObjFibResult, Fields: []*skbtrace.Field{typeField}},
+		{Row: "route", Object: ObjFibNh, Fields: []*skbtrace.Field{devField, gwField}},
+		{Row: "route-flow", Object: ObjFlow, Fields: []*skbtrace.Field{srcField, dstField}},
+	}
+}
+
+func newRouteObjects(isIPv6 bool, kernelFeatureMask skbtrace.FeatureFlagMask) []*skbtrace.Object {
+	if isIPv6 {
+		return []*skbtrace.Object{
+			{Variable: "tb6"},
+			{Variable: ObjFibTable, HeaderFiles: fib6HeaderFiles,
+				Casts: map[string]string{
+					"tb6": `{{ .Dst }} = ({{ StructKeyword }}fib6_table*) {{ .Src }}`,
+				}},
+			{Variable: "res6"},
+			{Variable: ObjFibResult, HeaderFiles: fib6HeaderFiles,
+				Casts: map[string]string{
+					"res6": `{{ .Dst }} = ({{ StructKeyword }}fib6_result*) {{ .Src }}`,
+				}},
+			{Variable: ObjFibNh, HeaderFiles: fib6HeaderFiles,
+				Casts: map[string]string{
+					ObjFibResult: `{{ .Dst }} = {{ .Src }}->nh`,
+				}},
+			{Variable: "fl6"},
+			{Variable: ObjFlow, HeaderFiles: []string{"net/flow.h"},
+				Casts: map[string]string{
+					"fl6": `{{ .Dst }} = ({{ StructKeyword }}flowi6*) {{ .Src }}`,
+				}},
+		}
+	}
+
+	nhCast := `{{ .Dst }} = {{ .Src }}`
+	if kernelFeatureMask.Supports(fibNhCommonFeature) {
+		nhCast = `{{ .Dst }} = {{ .Src }}->nhc`
+	}
+
+	return []*skbtrace.Object{
+		{Variable: "tb"},
+		{Variable: ObjFibTable, HeaderFiles: fibHeaderFiles,
+			Casts: map[string]string{
+				"tb": `{{ .Dst }} = ({{ StructKeyword }}fib_table*) {{ .Src }}`,
+			}},
+		{Variable: "res"},
+		{Variable: ObjFibResult, HeaderFiles: fibHeaderFiles,
+			Casts: map[string]string{
+				"res": `{{ .Dst }} = ({{ StructKeyword }}fib_result*) {{ .Src }}`,
+			}},
+		{Variable: ObjFibNh, HeaderFiles: fibHeaderFiles,
+			Casts: map[string]string{
+				ObjFibResult: nhCast,
+			}},
+		{Variable: "flp"},
+		{Variable: ObjFlow, HeaderFiles: []string{"net/flow.h"},
+			Casts: map[string]string{
+				"flp": `{{ .Dst }} = ({{ StructKeyword }}flowi4*) {{ .Src }}`,
+			}},
+		{Variable: ObjRtable, HeaderFiles: []string{"net/route.h"},
+			Casts: map[string]string{
+				"$skb": `{{ .Dst }} = ({{ StructKeyword }}rtable*) ({{ .Src }}->_skb_refdst & ~1)`,
+			}},
+	}
+}
+
+func newRtableFieldGroups(kernelFeatureMask skbtrace.FeatureFlagMask) []*skbtrace.FieldGroup {
+	gwField := "rt_gateway"
+	if kernelFeatureMask.Supports(fibNhCommonFeature) {
+		gwField = "rt_gw4"
+	}
+
+	return []*skbtrace.FieldGroup{
+		{Row: "rtable", Object: ObjRtable, Fields: []*skbtrace.Field{
+			{Name: "rt_type", Alias: "rt-type", FmtKey: "type", FmtSpec: "%d (%s)",
+				Converter: skbtrace.NewLookupConv(RouteTypesTable), LookupTable: RouteTypesTable,
+				Preprocessor: fppRouteType,
+				Help:         "Type of the route attached to the packet"},
+			{Name: "dst.dev->ifindex", Alias: "rt-dev", FmtKey: "dev", FmtSpec: "%d (%s)",
+				Converter: skbtrace.NewLookupConv(skb.IfnamesTable), LookupTable: skb.IfnamesTable,
+				Help: "Index of the output device of the route attached to the packet"},
+			{Name: gwField, Alias: "rt-gw", FmtKey: "gw", FmtSpec: "%s",
+				Converter: ConvNtopInet, Preprocessor: FppPtonInet,
+				Help: "Gateway of the route attached to the packet. " + ipAddressNote}}},
+	}
+}
+
+func newRouteProbes(isIPv6 bool, kernelFeatureMask skbtrace.FeatureFlagMask) []*skbtrace.Probe {
+	if isIPv6 {
+		if !kernelFeatureMask.Supports(fib6ResultFeature) {
+			return nil
+		}
+
+		return []*skbtrace.Probe{
+			{Name: ProbeFib6TableLookup, Aliases: []string{"fib-lookup"},
+				Args:      map[string]string{"tb6": "arg1", "fl6": "arg3", "res6": "arg4"},
+				StashArgs: true,
+				Help:      "fib6_table_lookup() returns when route is looked up in a single routing table"},
+		}
+	}
+
+	return []*skbtrace.Probe{
+		{Name: ProbeFibTableLookup, Aliases: []string{"fib-lookup"},
+			Args:      map[string]string{"tb": "arg0", "flp": "arg1", "res": "arg2"},
+			StashArgs: true,
+			Help:      "fib_table_lookup() returns when route is looked up in a single routing table"},
+		{Name: ProbeRouteInput, Aliases: []string{"route-input"},
+			Args:      map[string]string{"skb": "arg0"},
+			StashArgs: true,
+			Help:      "ip_route_input_noref() returns when route is attached to the received packet"},
+	}
+}
+
+// RegisterRoute registers fib lookup objects and probes which explain
+// routing decisions
+func RegisterRoute(
+	b *skbtrace.Builder, isIPv6 bool, bpfTraceFeatureMask, kernelFeatureMask skbtrace.FeatureFlagMask,
+) {
+	b.AddFieldGroups(newRouteFieldGroups(isIPv6, bpfTraceFeatureMask, kernelFeatureMask))
+	b.AddObjects(newRouteObjects(isIPv6, kernelFeatureMask))
+	if !isIPv6 {
+		b.AddFieldGroups(newRtableFieldGroups(kernelFeatureMask))
+	}
+	b.AddProbes(newRouteProbes(isIPv6, kernelFeatureMask))
+
+	b.AddLookupTable(RouteTypesTable, newNamesLookupTable(RouteTypes))
+}
+
+func init() {
+	skbtrace.RegisterFeatures(fibNhCommonFeature, fib6ResultFeature)
+}
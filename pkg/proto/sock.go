@@ -0,0 +1,217 @@
+package proto
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/yandex-cloud/skbtrace"
+)
+
+const (
+	ObjSock         = "$sk"
+	ObjInetSock     = "$inet_sk"
+	ObjSockCgroupKn = "$sk_cgrp_kn"
+
+	SockSrcAlias   = "sk-src"
+	SockDstAlias   = "sk-dst"
+	SockSportAlias = "sk-sport"
+	SockDportAlias = "sk-dport"
+	SockStateAlias = "sk-state"
+	SockNetnsAlias = "sk-netns"
+	CgroupAlias    = "cgroup"
+
+	ProbeTcpSetState = "kprobe:tcp_set_state"
+	TcpNewStateField = "newstate"
+)
+
+var sockHeaderFiles = []string{"net/sock.h", "net/inet_sock.h"}
+
+var sockCgroupPtrFeature = &skbtrace.Feature{
+	Component: skbtrace.FeatureComponentKernel,
+	Name:      "sock_cgroup_data:cgroup",
+	Help:      "sock_cgroup_data keeps cgroup pointer as a separate field rather than in a tagged union",
+
+	Commit:     "8520e224f547cd070c7c8f97b1fc6d58cff7ccaa",
+	MinVersion: skbtrace.Version{Major: 5, Submajor: 15, Minor: 0},
+}
+
+var kernfsNodeIdFeature = &skbtrace.Feature{
+	Component: skbtrace.FeatureComponentKernel,
+	Name:      "kernfs_node:id",
+	Help:      "kernfs_node id is a 64-bit integer rather than union kernfs_node_id",
+
+	Commit:     "67c0496e87d193b8356d2af49ab95e8a1b954b3c",
+	MinVersion: skbtrace.Version{Major: 5, Submajor: 5, Minor: 0},
+}
+
+// TcpStates are names of TCP states as defined in include/net/tcp_states.h
+// indexed by their values
+var TcpStates = []string{
+	"",
+	"ESTABLISHED", "SYN_SENT", "SYN_RECV", "FIN_WAIT1", "FIN_WAIT2", "TIME_WAIT",
+	"CLOSE", "CLOSE_WAIT", "LAST_ACK", "LISTEN", "CLOSING", "NEW_SYN_RECV",
+}
+
+const tcpStateNote = "Filters also accept mnemonics such as ESTABLISHED or SYN-SENT."
+
+func newSockFieldGroups(
+	isIPv6 bool, bpfTraceFeatureMask, kernelFeatureMask skbtrace.FeatureFlagMask,
+) []*skbtrace.FieldGroup {
+	ntohs := skbtrace.NewBSwapConv(bpfTraceFeatureMask, 16)
+
+	var addrFields []*skbtrace.Field
+	if isIPv6 {
+		addrFields = []*skbtrace.Field{
+			{Name: "__sk_common.skc_v6_rcv_saddr.in6_u.u6_addr8", Alias: SockSrcAlias, FmtKey: "src", FmtSpec: "%s",
+				Converter: ConvNtopInet6, ConverterMask: skbtrace.ConverterDump | skbtrace.ConverterHiddenKey,
+				FilterOperator: FiltopPtonInet6, Help: "Local IP Address of the socket. " + ipAddressNote},
+			{Name: "__sk_common.skc_v6_daddr.in6_u.u6_addr8", Alias: SockDstAlias, FmtKey: "dst", FmtSpec: "%s",
+				Converter: ConvNtopInet6, ConverterMask: skbtrace.ConverterDump | skbtrace.ConverterHiddenKey,
+				FilterOperator: FiltopPtonInet6, Help: "Remote IP Address of the socket. " + ipAddressNote},
+		}
+	} else {
+		addrFields = []*skbtrace.Field{
+			{Name: "__sk_common.skc_rcv_saddr", Alias: SockSrcAlias, FmtKey: "src", FmtSpec: "%s",
+				Converter: ConvNtopInet, Preprocessor: FppPtonInet,
+				Help: "Local IP Address of the socket. " + ipAddressNote},
+			{Name: "__sk_common.skc_daddr", Alias: SockDstAlias, FmtKey: "dst", FmtSpec: "%s",
+				Converter: ConvNtopInet, Preprocessor: FppPtonInet,
+				Help: "Remote IP Address of the socket. " + ipAddressNote},
+		}
+	}
+
+	kernfsIdField := "id.ino"
+	if kernelFeatureMask.Supports(kernfsNodeIdFeature) {
+		kernfsIdField = "id"
+	}
+
+	return []*skbtrace.FieldGroup{
+		{Row: "sock", Object: ObjSock, Fields: []*skbtrace.Field{
+			{Name: "__sk_common.skc_family", FmtKey: "family", FilterOperator: filtopSockFamily,
+				SanityFilter: &skbtrace.Filter{Op: "!=", Value: "0"},
+				Help:         "Address family of the socket (2 - AF_INET, 10 - AF_INET6)"},
+			{Name: "__sk_common.skc_state", FmtKey: "state", Alias: SockStateAlias, Preprocessor: fppTcpState,
+				Help: "Socket state, for TCP sockets: 1 - ESTABLISHED, 2 - SYN_SENT, ..., 12 - NEW_SYN_RECV. " +
+					tcpStateNote}}},
+		{Row: "sock", Object: ObjSock, Fields: []*skbtrace.Field{
+			addrFields[0],
+			{Name: "__sk_common.skc_num", FmtKey: "sport", Alias: SockSportAlias,
+				Help: "Local port of the socket"},
+			addrFields[1],
+			{Name: "__sk_common.skc_dport", FmtKey: "dport", Alias: SockDportAlias,
+				Converter: ntohs, Preprocessor: skbtrace.FppNtohs,
+				Help: "Remote port of the socket"}}},
+		{Row: "sock-mem", Object: ObjSock, Fields: []*skbtrace.Field{
+			{Name: "sk_rcvbuf", FmtKey: "rcvbuf",
+				Help: "Size of receive buffer in bytes"},
+			{Name: "sk_sndbuf", FmtKey: "sndbuf",
+				Help: "Size of send buffer in bytes"},
+			{Name: "sk_wmem_queued", FmtKey: "wmem_queued",
+				Help: "Persistent queue size in bytes"},
+			{Name: "sk_drops.counter", FmtKey: "drops",
+				Help: "Number of packets dropped by the socket"}}},
+		{Row: "sock-owner", Object: ObjSock, Fields: []*skbtrace.Field{
+			{Name: "__sk_common.skc_net.net->ns.inum", FmtKey: "netns", Alias: SockNetnsAlias,
+				Help: "Inode number of network namespace the socket belongs to"}}},
+		{Row: "sock-owner", Object: ObjSockCgroupKn, Fields: []*skbtrace.Field{
+			{Name: kernfsIdField, FmtKey: "cgroup", Alias: CgroupAlias,
+				Help: "Id of cgroup v2 owning the socket (inode of cgroup directory)"}}},
+		{Row: "inet-sock", Object: ObjInetSock, Fields: []*skbtrace.Field{
+			{Name: "tos",
+				Help: "Type of Service set for outgoing packets"},
+			{Name: "uc_ttl", FmtKey: "ttl",
+				Help: "Unicast TTL, -1 for system default"},
+			{Name: "inet_id", FmtKey: "id",
+				Help: "Counter used for IP Id of outgoing packets"}}},
+
+		// Arguments of socket probes
+		{Row: "tcp-sendmsg", Fields: []*skbtrace.Field{
+			{Name: "size", Help: "Size of data sent by user"}}},
+		{Row: "tcp-set-state", Fields: []*skbtrace.Field{
+			{Name: TcpNewStateField, Preprocessor: fppTcpState,
+				Help: "New state of TCP socket. " + tcpStateNote}}},
+	}
+}
+
+func newSockObjects(featureMask skbtrace.FeatureFlagMask) []*skbtrace.Object {
+	// Older kernels keep classid and prioidx in the same field if its lowest
+	// bit is set, so cgroup is only read if it is a pointer
+	cgroupCast := `{{ .Dst }} = (({{ StructKeyword }}cgroup*) (({{ .Src }}->sk_cgrp_data.val & 1) == 0 ? ` +
+		`{{ .Src }}->sk_cgrp_data.val : 0))->kn`
+	if featureMask.Supports(sockCgroupPtrFeature) {
+		cgroupCast = `{{ .Dst }} = {{ .Src }}->sk_cgrp_data.cgroup->kn`
+	}
+
+	return []*skbtrace.Object{
+		{Variable: "sk"},
+		{Variable: ObjSock, HeaderFiles: sockHeaderFiles,
+			Casts: map[string]string{
+				"sk":   `{{ .Dst }} = ({{ StructKeyword }}sock*) {{ .Src }}`,
+				"$skb": `{{ .Dst }} = {{ .Src }}->sk`,
+			}},
+		{Variable: ObjInetSock, HeaderFiles: sockHeaderFiles,
+			Casts: map[string]string{
+				ObjSock: `{{ .Dst }} = ({{ StructKeyword }}inet_sock*) {{ .Src }}`,
+			}},
+		{Variable: ObjSockCgroupKn, HeaderFiles: []string{"linux/cgroup-defs.h"},
+			Casts: map[string]string{
+				ObjSock: cgroupCast,
+			}},
+	}
+}
+
+func newSockProbes(isIPv6 bool) []*skbtrace.Probe {
+	connectProbe := &skbtrace.Probe{
+		Name: "kprobe:tcp_v4_connect", Aliases: []string{"tcp-connect"}, Args: map[string]string{"sk": "arg0"},
+		Help: "tcp_v4_connect() is called when user initiates TCP connection"}
+	if isIPv6 {
+		connectProbe.Name = "kprobe:tcp_v6_connect"
+		connectProbe.Help = "tcp_v6_connect() is called when user initiates TCP connection"
+	}
+
+	return []*skbtrace.Probe{
+		{Name: "kprobe:tcp_sendmsg", Aliases: []string{"tcp-sendmsg"},
+			Args: map[string]string{"sk": "arg0", "size": "arg2"},
+			Help: "tcp_sendmsg() is called when user sends data over TCP socket"},
+		connectProbe,
+		{Name: ProbeTcpSetState, Aliases: []string{"tcp-set-state"},
+			Args: map[string]string{"sk": "arg0", "newstate": "arg1"},
+			Help: "tcp_set_state() is called when TCP socket changes its state"},
+	}
+}
+
+// filtopSockFamily also checks that socket pointer is not NULL, as family is
+// used as sanity filter of the socket which is not set for forwarded packets
+func filtopSockFamily(expr skbtrace.Expression, op, value string) (skbtrace.Expression, error) {
+	return skbtrace.Exprf("(%s != 0 && %s %s %s)", ObjSock, expr, op, value), nil
+}
+
+func fppTcpState(op, value string) (string, error) {
+	if _, err := strconv.ParseUint(value, 0, 8); err == nil {
+		return value, nil
+	}
+
+	// Filter values cannot contain underscores, so dashes are used instead
+	value = strings.ReplaceAll(value, "-", "_")
+	for i, state := range TcpStates {
+		if state != "" && state == value {
+			return strconv.Itoa(i), nil
+		}
+	}
+	return "", fmt.Errorf("unknown TCP state mnemonic '%s'", value)
+}
+
+// RegisterSock registers struct sock and inet_sock objects along with probes
+// which receive socket as an argument
+func RegisterSock(
+	b *skbtrace.Builder, isIPv6 bool, bpfTraceFeatureMask, kernelFeatureMask skbtrace.FeatureFlagMask,
+) {
+	b.AddFieldGroups(newSockFieldGroups(isIPv6, bpfTraceFeatureMask, kernelFeatureMask))
+	b.AddObjects(newSockObjects(kernelFeatureMask))
+	b.AddProbes(newSockProbes(isIPv6))
+}
+
+func init() {
+	skbtrace.RegisterFeatures(sockCgroupPtrFeature, kernfsNodeIdFeature)
+}
@@ -0,0 +1,229 @@
+package proto
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yandex-cloud/skbtrace"
+)
+
+const (
+	ObjTcpOpt      = "$tcpopt"
+	ObjTcpOptInner = "$in_tcpopt"
+
+	// Maximum size of options area as limited by 4-bit data offset
+	tcpOptMaxLength = 40
+	tcpHdrLength    = 20
+
+	// Size of options area which is walked if verifier limits program to
+	// 4096 instructions. Options of Linux SYN segments (MSS, SACK permitted,
+	// timestamps and window scale) fit into it.
+	tcpOptShortLength = 20
+)
+
+var bpfComplexityFeature = &skbtrace.Feature{
+	Component: skbtrace.FeatureComponentKernel,
+	Name:      "bpf_complexity:1m",
+	Help: "verifier accepts programs up to 1 million instructions rather than 4096, so" +
+		" tcp-options walk whole options area rather than its first 20 bytes",
+
+	Commit:     "c04c0d2b968ac45d6ef020316808ef6c82325a82",
+	MinVersion: skbtrace.Version{Major: 5, Submajor: 2, Minor: 0},
+}
+
+// TCP option kinds decoded by tcpopt object
+const (
+	tcpOptEnd       = 0
+	tcpOptNop       = 1
+	tcpOptMss       = 2
+	tcpOptWScale    = 3
+	tcpOptSackPerm  = 4
+	tcpOptSack      = 5
+	tcpOptTimestamp = 8
+)
+
+type tcpOptVar struct {
+	name string
+	init int
+}
+
+var tcpOptVars = []tcpOptVar{
+	{"mss", 0},
+	{"wscale", -1},
+	{"sackok", 0},
+	{"sack", 0},
+	{"tsval", 0},
+	{"tsecr", 0},
+}
+
+// tcpOptDecoder generates statements which decode a single option of the
+// specified kind starting at a constant offset in options array. Options of
+// variable length (such as SACK) only check that length is at least minimal.
+type tcpOptDecoder struct {
+	kind      int
+	length    int
+	varLength bool
+	decode    func(opt func(i int) string) []string
+}
+
+var tcpOptDecoders = []tcpOptDecoder{
+	{tcpOptMss, 4, false, func(opt func(i int) string) []string {
+		return []string{fmt.Sprintf("{{ .Dst }}_mss = %s", tcpOptBigEndian(opt, 2, 2))}
+	}},
+	{tcpOptWScale, 3, false, func(opt func(i int) string) []string {
+		return []string{fmt.Sprintf("{{ .Dst }}_wscale = %s", opt(2))}
+	}},
+	{tcpOptSackPerm, 2, false, func(opt func(i int) string) []string {
+		return []string{"{{ .Dst }}_sackok = 1"}
+	}},
+	{tcpOptSack, 2, true, func(opt func(i int) string) []string {
+		return []string{fmt.Sprintf("{{ .Dst }}_sack = (%s - 2) / 8", opt(1))}
+	}},
+	{tcpOptTimestamp, 10, false, func(opt func(i int) string) []string {
+		return []string{
+			fmt.Sprintf("{{ .Dst }}_tsval = %s", tcpOptBigEndian(opt, 2, 4)),
+			fmt.Sprintf("{{ .Dst }}_tsecr = %s", tcpOptBigEndian(opt, 6, 4)),
+		}
+	}},
+}
+
+func tcpOptBigEndian(opt func(i int) string, offset, size int) string {
+	bytes := make([]string, size)
+	for i := range bytes {
+		shift := 8 * (size - i - 1)
+		if shift > 0 {
+			bytes[i] = fmt.Sprintf("(%s << %d)", opt(offset+i), shift)
+		} else {
+			bytes[i] = opt(offset + i)
+		}
+	}
+	return strings.Join(bytes, " | ")
+}
+
+// newTcpOptCastTemplate generates a cast template for tcpopt pseudo-object
+// which walks TCP options up to doff*4 and saves decoded values into variables
+// prefixed with destination object name. As older bpftrace versions lack
+// loops and only allow constant array indices, the walk is unrolled: each
+// offset in options array up to maxLength is checked against offset of the
+// next option.
+func newTcpOptCastTemplate(maxLength int) string {
+	const indent = "    "
+	var lines []string
+	add := func(depth int, format string, args ...interface{}) {
+		lines = append(lines, strings.Repeat(indent, depth)+fmt.Sprintf(format, args...))
+	}
+
+	for _, v := range tcpOptVars {
+		add(0, "{{ .Dst }}_%s = %d;", v.name, v.init)
+	}
+	add(0, "{{ .Dst }}_kind = 0;")
+	add(0, "{{ .Dst }}_len = 0;")
+	add(0, "{{ .Dst }}_off = 0;")
+	add(0, "{{ .Dst }}_end = (({{ .Src }}->flags2_doff >> 4) * 4) - %d;", tcpHdrLength)
+
+	for i := 0; i < maxLength; i++ {
+		opt := func(j int) string {
+			return fmt.Sprintf("{{ .Src }}->options[%d]", i+j)
+		}
+
+		add(0, "if ({{ .Dst }}_off == %d && {{ .Dst }}_end > %d) {", i, i)
+		add(1, "{{ .Dst }}_kind = %s;", opt(0))
+		add(1, "{{ .Dst }}_off = %d;", i+1)
+		add(1, "if ({{ .Dst }}_kind == %d) {", tcpOptEnd)
+		add(2, "{{ .Dst }}_off = %d;", tcpOptMaxLength)
+		add(1, "}")
+		if i+1 < maxLength {
+			add(1, "if ({{ .Dst }}_kind > %d) {", tcpOptNop)
+			add(2, "{{ .Dst }}_len = %s;", opt(1))
+			add(2, "{{ .Dst }}_off = {{ .Dst }}_len < 2 ? %d : %d + {{ .Dst }}_len;", tcpOptMaxLength, i)
+			for _, decoder := range tcpOptDecoders {
+				if i+decoder.length > maxLength {
+					continue
+				}
+
+				lenOp := "=="
+				if decoder.varLength {
+					lenOp = ">="
+				}
+				add(2, "if ({{ .Dst }}_kind == %d && {{ .Dst }}_len %s %d) {", decoder.kind, lenOp, decoder.length)
+				for _, stmt := range decoder.decode(opt) {
+					add(3, "%s;", stmt)
+				}
+				add(2, "}")
+			}
+			add(1, "}")
+		}
+		add(0, "}")
+	}
+
+	add(0, "{{ .Dst }} = {{ .Src }}")
+	return strings.Join(lines, "\n")
+}
+
+func convTcpOptVar(obj, field string) ([]skbtrace.Statement, skbtrace.Expression) {
+	return nil, skbtrace.Exprf("%s_%s", obj, field)
+}
+
+const tcpOptConverterMask = skbtrace.ConverterDump | skbtrace.ConverterHiddenKey | skbtrace.ConverterFilter
+
+// newFiltopTcpOptPresent creates filter operator which only matches packets
+// carrying the option, so filters like 'mss < 1400' skip segments without MSS
+func newFiltopTcpOptPresent(absent int) func(expr skbtrace.Expression, op, value string) (skbtrace.Expression, error) {
+	return func(expr skbtrace.Expression, op, value string) (skbtrace.Expression, error) {
+		return skbtrace.Exprf("(%s != %d && %s %s %s)", expr, absent, expr, op, value), nil
+	}
+}
+
+var tcpOptRows = [][]*skbtrace.Field{
+	{
+		{Name: "mss", Alias: "mss", Converter: convTcpOptVar, ConverterMask: tcpOptConverterMask,
+			FilterOperator: newFiltopTcpOptPresent(0),
+			Help:           "Maximum segment size option, 0 if absent. Filters only match segments with the option"},
+		{Name: "wscale", Converter: convTcpOptVar, ConverterMask: tcpOptConverterMask,
+			FilterOperator: newFiltopTcpOptPresent(-1),
+			Help:           "Window scale option shift count, -1 if absent. Filters only match segments with the option"},
+		{Name: "sackok", Converter: convTcpOptVar, ConverterMask: tcpOptConverterMask,
+			Help: "SACK permitted option, 1 if present"},
+		{Name: "sack", Converter: convTcpOptVar, ConverterMask: tcpOptConverterMask,
+			Help: "Number of blocks in SACK option, 0 if absent"},
+	},
+	{
+		{Name: "tsval", Alias: "tsval", FmtSpec: "%lu", Converter: convTcpOptVar, ConverterMask: tcpOptConverterMask,
+			Help: "Timestamp value from timestamps option, 0 if absent"},
+		{Name: "tsecr", FmtSpec: "%lu", Converter: convTcpOptVar, ConverterMask: tcpOptConverterMask,
+			Help: "Timestamp echo reply from timestamps option, 0 if absent"},
+	},
+}
+
+var tcpOptFieldGroup = skbtrace.FieldGroup{Object: ObjTcpOpt, Row: "tcp-options"}
+
+// newTcpOptObjects creates tcpopt objects guarded by the same protocol check
+// as TCP headers they are decoded from
+func newTcpOptObjects(kernelFeatureMask skbtrace.FeatureFlagMask) []*skbtrace.Object {
+	maxLength := tcpOptShortLength
+	if kernelFeatureMask.Supports(bpfComplexityFeature) {
+		maxLength = tcpOptMaxLength
+	}
+
+	castTmpl := newTcpOptCastTemplate(maxLength)
+	return []*skbtrace.Object{
+		{Variable: ObjTcpOpt, HeaderFiles: headerFiles, StructDefs: []string{"tcphdr"},
+			SanityFilter: getTransportSanityFilter(ObjTcpHdr),
+			Casts:        map[string]string{ObjTcpHdr: castTmpl}},
+		{Variable: ObjTcpOptInner, HeaderFiles: headerFiles, StructDefs: []string{"tcphdr"},
+			SanityFilter: getTransportSanityFilter(ObjTcpHdrInner),
+			Casts:        map[string]string{ObjTcpHdrInner: castTmpl}},
+	}
+}
+
+// RegisterTcpOptions registers tcp-options rows which decode MSS, window scale,
+// SACK and timestamps options. Requires transport objects to be registered.
+func RegisterTcpOptions(b *skbtrace.Builder, kernelFeatureMask skbtrace.FeatureFlagMask) {
+	b.AddFieldGroupTemplate(tcpOptFieldGroup, tcpOptRows)
+	b.AddFieldGroupTemplate(tcpOptFieldGroup.Wrap(ObjTcpOptInner, "inner"), tcpOptRows)
+	b.AddObjects(newTcpOptObjects(kernelFeatureMask))
+}
+
+func init() {
+	skbtrace.RegisterFeatures(bpfComplexityFeature)
+}
@@ -127,6 +127,17 @@ func NewTransportSanityFilter(obj string, protoNum int) skbtrace.Filter {
 	return skbtrace.Filter{Object: obj, Field: "protocol", Op: "==", Value: strconv.Itoa(protoNum)}
 }
 
+// getTransportSanityFilter returns sanity filter of the transport object
+// as it was prepared for the IP version
+func getTransportSanityFilter(variable string) skbtrace.Filter {
+	for _, obj := range objTrans {
+		if obj.Variable == variable {
+			return obj.SanityFilter
+		}
+	}
+	return skbtrace.Filter{}
+}
+
 func convTcpFlags(obj, field string) ([]skbtrace.Statement, skbtrace.Expression) {
 	var flagExprs []skbtrace.Expression
 	for _, flag := range tcpFlags {
@@ -0,0 +1,172 @@
+package skb
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/yandex-cloud/skbtrace"
+)
+
+const (
+	ObjQdisc = "$qdisc"
+
+	SkbAddrAlias      = "skbaddr"
+	QdiscKindAlias    = "qdisc-kind"
+	QdiscHandleAlias  = "qdisc-handle"
+	QdiscParentAlias  = "qdisc-parent"
+	QdiscVerdictAlias = "qdisc-verdict"
+	TxQueueAlias      = "txq"
+	XmitRcAlias       = "xmit-rc"
+
+	// Name of the lookup table for results of qdisc enqueue
+	NetXmitTable = "net_xmit"
+
+	// NETDEV_TX_BUSY is returned by driver if it cannot transmit skb,
+	// so qdisc requeues it
+	NetdevTxBusy = 0x10
+
+	ProbeQdiscEnqueue          = "tracepoint:qdisc:qdisc_enqueue"
+	ProbeQdiscEnqueueLegacy    = "kprobe:__dev_queue_xmit"
+	ProbeQdiscEnqueueRet       = "kretprobe:dev_qdisc_enqueue"
+	ProbeQdiscEnqueueRetLegacy = "kretprobe:__dev_queue_xmit"
+	ProbeQdiscDequeue          = "tracepoint:qdisc:qdisc_dequeue"
+	ProbeDevStartXmit          = "tracepoint:net:net_dev_start_xmit"
+	ProbeDevXmit               = "tracepoint:net:net_dev_xmit"
+)
+
+var QdiscEnqueueFeature = &skbtrace.Feature{
+	Component: skbtrace.FeatureComponentKernel,
+	Name:      "qdisc_enqueue",
+	Help: "qdisc_enqueue tracepoint is fired on successful enqueue by dev_qdisc_enqueue() which" +
+		" also reports drops. Otherwise __dev_queue_xmit() is used for enqueue time and drops",
+
+	MinVersion: skbtrace.Version{Major: 5, Submajor: 16, Minor: 0},
+}
+
+var QdiscDequeueFeature = &skbtrace.Feature{
+	Component: skbtrace.FeatureComponentKernel,
+	Name:      "qdisc_dequeue",
+	Help:      "qdisc_dequeue tracepoint is fired when skb is dequeued from qdisc",
+
+	MinVersion: skbtrace.Version{Major: 4, Submajor: 16, Minor: 0},
+}
+
+// NetXmitCodes are names of qdisc enqueue results indexed by their values
+var NetXmitCodes = []string{"SUCCESS", "DROP", "CN"}
+
+func fppNetXmit(op, value string) (string, error) {
+	if _, err := strconv.ParseInt(value, 0, 32); err == nil {
+		return value, nil
+	}
+
+	for i, code := range NetXmitCodes {
+		if code == value {
+			return strconv.Itoa(i), nil
+		}
+	}
+	return "", fmt.Errorf("unknown qdisc verdict mnemonic '%s'", value)
+}
+
+func newNetXmitLookupTable() skbtrace.LookupTable {
+	table := make(skbtrace.LookupTable)
+	for i, code := range NetXmitCodes {
+		table[int64(i)] = code
+	}
+	return table
+}
+
+var fieldsQdisc = []*skbtrace.FieldGroup{
+	{Object: "$skb", Row: "skbaddr", Fields: []*skbtrace.Field{
+		{Name: "skbaddr", Alias: SkbAddrAlias, FmtKey: "addr", FmtSpec: "%lx",
+			Converter:     skbtrace.NewObjectConvExpr("(uint64) %[1]s"),
+			ConverterMask: skbtrace.ConverterDump | skbtrace.ConverterHiddenKey | skbtrace.ConverterFilter,
+			Help:          "Address of sk buff, identifies packet while it is queued"}}},
+	{Object: "$skb", Row: "txq", Fields: []*skbtrace.Field{
+		{Name: "queue_mapping", Alias: TxQueueAlias, FmtKey: "txq",
+			Help: "Transmit queue of the device. Identifies class of mq qdisc which minor is txq + 1"}}},
+
+	{Object: ObjQdisc, Row: "qdisc", Fields: []*skbtrace.Field{
+		{Name: "ops->id", Alias: QdiscKindAlias, FmtKey: "kind", FmtSpec: "%s",
+			Help: "Kind of the qdisc such as fq_codel or htb"},
+		{Name: "handle", Alias: QdiscHandleAlias, FmtSpec: "%x",
+			Help: "Handle of the qdisc as a number, i.e. 10000 for 1:"},
+		{Name: "parent", Alias: QdiscParentAlias, FmtSpec: "%x",
+			Help: "Class qdisc is attached to, i.e. 10001 for 1:1 or ffffffff for root"}}},
+	{Object: ObjQdisc, Row: "qdisc", Fields: []*skbtrace.Field{
+		{Name: "q.qlen", FmtKey: "qlen"},
+		{Name: "qstats.backlog", FmtKey: "backlog"},
+		{Name: "qstats.drops", FmtKey: "drops"},
+		{Name: "qstats.requeues", FmtKey: "requeues"}}},
+
+	// Return value of dev_qdisc_enqueue() or __dev_queue_xmit()
+	{Row: "qdisc-verdict", Fields: []*skbtrace.Field{
+		{Name: "retval", Alias: QdiscVerdictAlias, FmtKey: "verdict", FmtSpec: "%d (%s)",
+			Converter: skbtrace.NewLookupConv(NetXmitTable), LookupTable: NetXmitTable,
+			Preprocessor: fppNetXmit,
+			Help:         "Result of enqueue: 0 - SUCCESS, 1 - DROP, 2 - CN (dropped due to congestion)"}}},
+
+	// Result of the driver transmit in net_dev_xmit
+	{Object: "args", Row: "net_dev_xmit", Fields: []*skbtrace.Field{
+		{Name: "rc", Alias: XmitRcAlias,
+			Help: "Result of transmit by driver: 0 - OK, 16 - BUSY (skb is requeued)"}}},
+}
+
+var objQdisc = []*skbtrace.Object{
+	{Variable: "qdisc"},
+	{Variable: ObjQdisc, HeaderFiles: []string{"net/sch_generic.h"},
+		Casts: map[string]string{
+			"qdisc": `{{ .Dst }} = ({{ StructKeyword }}Qdisc*) {{ .Src }}`,
+		}},
+}
+
+func newQdiscProbes(kernelFeatureMask skbtrace.FeatureFlagMask) []*skbtrace.Probe {
+	probes := []*skbtrace.Probe{
+		{Name: ProbeDevStartXmit, Aliases: []string{"start-xmit"},
+			Args: map[string]string{"skb": "args->skbaddr"},
+			Help: "net_dev_start_xmit tracepoint is fired when skb is passed to the driver"},
+		{Name: ProbeDevXmit, Aliases: []string{"dev-xmit"},
+			Args: map[string]string{"skb": "args->skbaddr"},
+			Help: "net_dev_xmit tracepoint is fired when driver returns, skb might be freed at this point"},
+	}
+
+	if kernelFeatureMask.Supports(QdiscEnqueueFeature) {
+		probes = append(probes,
+			&skbtrace.Probe{Name: ProbeQdiscEnqueue, Aliases: []string{"qdisc-enqueue"},
+				Args: map[string]string{"skb": "args->skbaddr", "qdisc": "args->qdisc"},
+				Help: "qdisc_enqueue tracepoint is fired when skb is successfully enqueued to qdisc"},
+			&skbtrace.Probe{Name: ProbeQdiscEnqueueRet, Aliases: []string{"qdisc-enqueue-ret"},
+				Args: map[string]string{"skb": "arg0", "qdisc": "arg1"}, StashArgs: true,
+				Help: "dev_qdisc_enqueue() returns result of enqueue including drops"})
+	} else {
+		probes = append(probes,
+			&skbtrace.Probe{Name: ProbeQdiscEnqueueLegacy, Aliases: []string{"qdisc-enqueue"},
+				Args: map[string]string{"skb": "arg0"},
+				Help: "__dev_queue_xmit() is called right before skb is enqueued to qdisc"},
+			&skbtrace.Probe{Name: ProbeQdiscEnqueueRetLegacy, Aliases: []string{"qdisc-enqueue-ret"},
+				Args: map[string]string{"skb": "arg0"}, StashArgs: true,
+				Help: "__dev_queue_xmit() returns result of enqueue including drops, qdisc is not accessible"})
+	}
+
+	if kernelFeatureMask.Supports(QdiscDequeueFeature) {
+		probes = append(probes,
+			&skbtrace.Probe{Name: ProbeQdiscDequeue, Aliases: []string{"qdisc-dequeue"},
+				Args: map[string]string{"skb": "args->skbaddr", "qdisc": "args->qdisc"},
+				Help: "qdisc_dequeue tracepoint is fired when skb is dequeued from qdisc." +
+					" Only first skb is reported for bulk dequeues"})
+	}
+	return probes
+}
+
+// RegisterQdisc registers queueing discipline objects and probes of
+// enqueuing to qdisc and dequeuing from it
+func RegisterQdisc(b *skbtrace.Builder, kernelFeatureMask skbtrace.FeatureFlagMask) {
+	b.AddObjects(objQdisc)
+	b.AddFieldGroups(fieldsQdisc)
+	b.AddProbes(newQdiscProbes(kernelFeatureMask))
+
+	b.AddLookupTable(NetXmitTable, newNetXmitLookupTable())
+}
+
+func init() {
+	skbtrace.RegisterFeatures(QdiscEnqueueFeature, QdiscDequeueFeature)
+}
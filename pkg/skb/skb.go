@@ -11,6 +11,13 @@ import (
 const (
 	ProbeXmit = "kprobe:dev_queue_xmit"
 	ProbeRecv = "kprobe:__netif_receive_skb_core"
+
+	ProbeGsoSegment = "kprobe:__skb_gso_segment"
+
+	// Short names of the probes above used in commands and hit counters
+	ProbeXmitAlias       = "xmit"
+	ProbeRecvAlias       = "recv"
+	ProbeGsoSegmentAlias = "gso-segment"
 )
 
 // Offset of cb field in sk_buff which contains overlay control structure
@@ -18,6 +25,12 @@ const SkbCbOffset = "0x28"
 
 const (
 	DevNameAlias = "dev"
+	NetnsAlias   = "netns"
+	IfindexAlias = "ifindex"
+	IifAlias     = "iif"
+
+	// Name of the lookup table which maps interface indices to names
+	IfnamesTable = "ifnames"
 )
 
 var skbHeaderFiles = []string{"linux/skbuff.h"}
@@ -89,6 +102,18 @@ func newFieldsSkb(featureMask skbtrace.FeatureFlagMask) []*skbtrace.FieldGroup {
 			{Name: "mtu"},
 			{Name: "state", FmtSpec: "%x"},
 			{Name: "features", FmtSpec: "%x"}}},
+		{Object: "$netdev", Row: "netdev", Fields: []*skbtrace.Field{
+			{Name: "nd_net.net->ns.inum", FmtKey: "netns", Alias: NetnsAlias,
+				Help: "Inode number of network namespace the device belongs to"}}},
+
+		{Object: "$netdev", Row: "ifindex", Fields: []*skbtrace.Field{
+			{Name: "ifindex", Alias: IfindexAlias, FmtSpec: "%d (%s)",
+				Converter: skbtrace.NewLookupConv(IfnamesTable), LookupTable: IfnamesTable,
+				Help: "Index of the device, printed along with its name if device exists on host"}}},
+		{Object: "$skb", Row: "ifindex", Fields: []*skbtrace.Field{
+			{Name: "skb_iif", FmtKey: "iif", Alias: IifAlias, FmtSpec: "%d (%s)",
+				Converter: skbtrace.NewLookupConv(IfnamesTable), LookupTable: IfnamesTable,
+				Help: "Index of the device packet was received on"}}},
 	}
 }
 
@@ -129,7 +154,7 @@ var objSkb = []*skbtrace.Object{
 }
 
 var xmitProbeSkb = &skbtrace.Probe{
-	Name: ProbeXmit, Aliases: []string{"xmit"}, Args: map[string]string{"skb": "arg0"},
+	Name: ProbeXmit, Aliases: []string{ProbeXmitAlias}, Args: map[string]string{"skb": "arg0"},
 	Help: "dev_queue_xmit() is called when kernel tries to put skb to a send queue of respective device"}
 
 func newRecvSkbProbe(mask skbtrace.FeatureFlagMask) *skbtrace.Probe {
@@ -139,7 +164,7 @@ func newRecvSkbProbe(mask skbtrace.FeatureFlagMask) *skbtrace.Probe {
 	}
 
 	return &skbtrace.Probe{
-		Name: ProbeRecv, Aliases: []string{"recv"}, Args: map[string]string{skbArg: "arg0"},
+		Name: ProbeRecv, Aliases: []string{ProbeRecvAlias}, Args: map[string]string{skbArg: "arg0"},
 		Help: "__netif_receive_skb_core() is called when kernel receives a packet"}
 }
 
@@ -168,7 +193,7 @@ var extProbesSkb = []*skbtrace.Probe{
 		Help: "tcp_gro_receive() is called when GRO tries to merge sk buffs"},
 	{Name: "kprobe:tcp_gro_complete", Args: map[string]string{"skb": "arg0"},
 		Help: "tcp_gro_complete() is called when GRO finishes setting merged sk buff"},
-	{Name: "kprobe:__skb_gso_segment", Args: map[string]string{"skb": "arg0"},
+	{Name: ProbeGsoSegment, Aliases: []string{ProbeGsoSegmentAlias}, Args: map[string]string{"skb": "arg0"},
 		Help: "__skb_gso_segment() is called when device decides to apply GSO to sk buff"},
 
 	// Some IPv4 probes
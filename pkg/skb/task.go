@@ -9,6 +9,8 @@ var taskFields = []*skbtrace.FieldGroup{
 		{Name: "pid", Help: "Process ID of current task"},
 		{Name: "tid", Help: "Thread ID of current task"},
 		{Name: "cpu", Help: "Processor number the probe has fired on"}}},
+	{Row: "retval", Object: "", Fields: []*skbtrace.Field{
+		{Name: "retval", Help: "Value returned by the function, accessible only in return probes"}}},
 }
 
 var taskVars = map[string]skbtrace.Expression{
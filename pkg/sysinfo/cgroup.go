@@ -0,0 +1,21 @@
+package sysinfo
+
+import (
+	"fmt"
+	"os"
+)
+
+// ResolveCgroup resolves cgroup v2 id from the path of its directory in
+// cgroup filesystem such as /sys/fs/cgroup/kubepods.slice/... As cgroup
+// ids are kernfs node ids, they match inode numbers of the directories.
+func ResolveCgroup(path string) (uint64, error) {
+	fi, err := os.Stat(path)
+	if err == nil && !fi.IsDir() {
+		err = fmt.Errorf("not a directory")
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cannot resolve cgroup '%s': %w", path, err)
+	}
+
+	return statInode(path)
+}
@@ -0,0 +1,39 @@
+package sysinfo
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveCgroup(t *testing.T) {
+	cgroupRoot := t.TempDir()
+	podPath := filepath.Join(cgroupRoot, "kubepods.slice", "kubepods-pod1.slice")
+	require.NoError(t, os.MkdirAll(podPath, 0755))
+
+	t.Run("Directory", func(t *testing.T) {
+		var stat syscall.Stat_t
+		require.NoError(t, syscall.Stat(podPath, &stat))
+
+		id, err := ResolveCgroup(podPath)
+		require.NoError(t, err)
+		assert.Equal(t, stat.Ino, id)
+	})
+
+	t.Run("NotExists", func(t *testing.T) {
+		_, err := ResolveCgroup(filepath.Join(cgroupRoot, "system.slice"))
+		assert.Error(t, err)
+	})
+
+	t.Run("NotDirectory", func(t *testing.T) {
+		path := filepath.Join(podPath, "cgroup.procs")
+		require.NoError(t, os.WriteFile(path, nil, 0644))
+
+		_, err := ResolveCgroup(path)
+		assert.Error(t, err)
+	})
+}
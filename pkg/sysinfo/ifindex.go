@@ -0,0 +1,54 @@
+package sysinfo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const DefaultSysRoot = "/sys"
+
+// ListInterfaces returns indices of network interfaces of the current network
+// namespace mapped to their names as exposed by sysfs. Interfaces which index
+// cannot be read are skipped.
+func ListInterfaces(sysRoot string) (map[int64]string, error) {
+	paths, err := filepath.Glob(filepath.Join(sysRoot, "class", "net", "*", "ifindex"))
+	if err != nil {
+		return nil, err
+	}
+
+	interfaces := make(map[int64]string, len(paths))
+	for _, path := range paths {
+		ifindex, err := readIfindex(path)
+		if err != nil {
+			continue
+		}
+
+		interfaces[ifindex] = filepath.Base(filepath.Dir(path))
+	}
+	return interfaces, nil
+}
+
+// ResolveIfindex resolves interface index from the spec which is either
+// a numeric index or a name of the interface in the current network namespace
+func ResolveIfindex(sysRoot, spec string) (int64, error) {
+	if ifindex, err := strconv.ParseInt(spec, 10, 32); err == nil {
+		return ifindex, nil
+	}
+
+	ifindex, err := readIfindex(filepath.Join(sysRoot, "class", "net", spec, "ifindex"))
+	if err != nil {
+		return 0, fmt.Errorf("cannot resolve interface '%s': %w", spec, err)
+	}
+	return ifindex, nil
+}
+
+func readIfindex(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 32)
+}
@@ -0,0 +1,54 @@
+package sysinfo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeFakeSysRoot(t *testing.T, interfaces map[string]string) string {
+	sysRoot := t.TempDir()
+	for name, ifindex := range interfaces {
+		devPath := filepath.Join(sysRoot, "class", "net", name)
+		require.NoError(t, os.MkdirAll(devPath, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(devPath, "ifindex"), []byte(ifindex+"\n"), 0644))
+	}
+	return sysRoot
+}
+
+func TestListInterfaces(t *testing.T) {
+	sysRoot := makeFakeSysRoot(t, map[string]string{"lo": "1", "eth0": "2", "bad0": "x"})
+
+	interfaces, err := ListInterfaces(sysRoot)
+	require.NoError(t, err)
+	assert.Equal(t, map[int64]string{1: "lo", 2: "eth0"}, interfaces)
+}
+
+func TestResolveIfindex(t *testing.T) {
+	sysRoot := makeFakeSysRoot(t, map[string]string{"eth0": "2", "bad0": "x"})
+
+	t.Run("Name", func(t *testing.T) {
+		ifindex, err := ResolveIfindex(sysRoot, "eth0")
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, ifindex)
+	})
+
+	t.Run("Index", func(t *testing.T) {
+		ifindex, err := ResolveIfindex(sysRoot, "17")
+		require.NoError(t, err)
+		assert.EqualValues(t, 17, ifindex)
+	})
+
+	t.Run("NotExists", func(t *testing.T) {
+		_, err := ResolveIfindex(sysRoot, "eth1")
+		assert.Error(t, err)
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		_, err := ResolveIfindex(sysRoot, "bad0")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,58 @@
+package sysinfo
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"syscall"
+)
+
+const DefaultProcRoot = "/proc"
+
+var reNsLink = regexp.MustCompile(`^(\w+):\[(\d+)\]$`)
+
+// ResolveNetns resolves network namespace inode number from the spec which is
+// either a pid of the process living in the namespace, or a path to namespace
+// file such as /run/netns/NAME or /proc/PID/ns/net
+func ResolveNetns(procRoot, spec string) (uint64, error) {
+	path := spec
+	if _, err := strconv.ParseUint(spec, 10, 32); err == nil {
+		path = filepath.Join(procRoot, spec, "ns", "net")
+	}
+
+	ino, err := resolveNsInode(path, "net")
+	if err != nil {
+		return 0, fmt.Errorf("cannot resolve network namespace '%s': %w", spec, err)
+	}
+	return ino, nil
+}
+
+// resolveNsInode returns inode of namespace either from link target of
+// procfs namespace link (in form of 'net:[INODE]'), or from inode of
+// the namespace file itself (i.e. for bind-mounted namespaces)
+func resolveNsInode(path, nsType string) (uint64, error) {
+	if target, err := os.Readlink(path); err == nil {
+		groups := reNsLink.FindStringSubmatch(target)
+		if groups == nil || groups[1] != nsType {
+			return 0, fmt.Errorf("unexpected namespace link target '%s'", target)
+		}
+		return strconv.ParseUint(groups[2], 10, 64)
+	}
+
+	return statInode(path)
+}
+
+func statInode(path string) (uint64, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("cannot get inode of '%s'", path)
+	}
+	return stat.Ino, nil
+}
@@ -0,0 +1,58 @@
+package sysinfo
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeFakeProc(t *testing.T) string {
+	procRoot := t.TempDir()
+	nsDir := filepath.Join(procRoot, "42", "ns")
+	require.NoError(t, os.MkdirAll(nsDir, 0755))
+	require.NoError(t, os.Symlink("net:[4026532001]", filepath.Join(nsDir, "net")))
+	require.NoError(t, os.Symlink("mnt:[4026532002]", filepath.Join(nsDir, "mnt")))
+	return procRoot
+}
+
+func TestResolveNetns(t *testing.T) {
+	procRoot := makeFakeProc(t)
+
+	t.Run("Pid", func(t *testing.T) {
+		ino, err := ResolveNetns(procRoot, "42")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(4026532001), ino)
+	})
+
+	t.Run("Link", func(t *testing.T) {
+		ino, err := ResolveNetns(procRoot, filepath.Join(procRoot, "42", "ns", "net"))
+		require.NoError(t, err)
+		assert.Equal(t, uint64(4026532001), ino)
+	})
+
+	t.Run("BindMount", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "netns")
+		require.NoError(t, os.WriteFile(path, nil, 0644))
+
+		var stat syscall.Stat_t
+		require.NoError(t, syscall.Stat(path, &stat))
+
+		ino, err := ResolveNetns(procRoot, path)
+		require.NoError(t, err)
+		assert.Equal(t, stat.Ino, ino)
+	})
+
+	t.Run("WrongType", func(t *testing.T) {
+		_, err := ResolveNetns(procRoot, filepath.Join(procRoot, "42", "ns", "mnt"))
+		assert.Error(t, err)
+	})
+
+	t.Run("NoPid", func(t *testing.T) {
+		_, err := ResolveNetns(procRoot, "43")
+		assert.Error(t, err)
+	})
+}
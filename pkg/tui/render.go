@@ -0,0 +1,249 @@
+package tui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/yandex-cloud/skbtrace/pkg/bpfout"
+)
+
+const (
+	columnSeparator = "  "
+	histBarChar     = "@"
+
+	helpLine = "p pause  s sort  r reverse  / filter  esc clear  q quit"
+)
+
+// frame renders the shown snapshot as lines which fit the screen
+func (lv *LiveView) frame(width, height int) []string {
+	state := &lv.state
+	snap := state.shown
+	if snap == nil {
+		snap = &snapshot{}
+	}
+
+	var header, body, footer []string
+	header = append(header, lv.titleLine(snap))
+	if state.status != "" {
+		header = append(header, state.status)
+	}
+
+	if state.editing {
+		footer = append(footer, fmt.Sprintf("/%s_", state.filterBuf))
+	} else {
+		footer = append(footer, helpLine)
+	}
+
+	rows := lv.sortedRows(snap)
+	table := lv.renderTable(snap, rows)
+	if snap.isHist && len(rows) > 0 {
+		body = append(body, "")
+		body = append(body, renderHist(rows[0], width)...)
+	}
+	if len(snap.extra) > 0 {
+		body = append(body, "")
+		body = append(body, snap.extra...)
+	}
+
+	// Table rows are truncated first as histogram and other maps are
+	// rendered below them
+	numTableLines := height - len(header) - len(body) - len(footer)
+	if numTableLines < 1 {
+		numTableLines = 1
+	}
+	if len(table) > numTableLines {
+		table = table[:numTableLines]
+	}
+
+	lines := append(header, table...)
+	lines = append(lines, body...)
+	if len(lines) > height-len(footer) {
+		lines = lines[:height-len(footer)]
+	}
+	lines = append(lines, footer...)
+	for i, line := range lines {
+		if len(line) > width {
+			lines[i] = line[:width]
+		}
+	}
+	return lines
+}
+
+func (lv *LiveView) titleLine(snap *snapshot) string {
+	state := &lv.state
+	order := "asc"
+	if lv.isDescending() {
+		order = "desc"
+	}
+
+	parts := []string{"skbtrace"}
+	if snap.time != "" {
+		parts = append(parts, snap.time)
+	}
+	parts = append(parts,
+		fmt.Sprintf("rows: %d", len(snap.rows)),
+		fmt.Sprintf("sort: %s %s", lv.sortHeader(snap), order))
+	if state.filter != "" {
+		parts = append(parts, fmt.Sprintf("filter: %s", state.filter))
+	}
+	if state.paused {
+		parts = append(parts, "[PAUSED]")
+	}
+	return strings.Join(parts, "  ")
+}
+
+func (lv *LiveView) valueHeaders(snap *snapshot) []string {
+	if snap.isHist {
+		return []string{"COUNT", "P50", "P99"}
+	}
+	return []string{"VALUE"}
+}
+
+func (lv *LiveView) sortHeader(snap *snapshot) string {
+	keyHeaders := lv.keyHeaders(snap)
+	if lv.state.sortColumn > 0 && lv.state.sortColumn <= len(keyHeaders) {
+		return keyHeaders[lv.state.sortColumn-1]
+	}
+	return lv.valueHeaders(snap)[0]
+}
+
+// sortedRows returns filtered rows sorted by the selected column. Value
+// column is sorted in descending order so top rows are shown first, while
+// key columns are sorted in ascending order, r key reverses both.
+func (lv *LiveView) sortedRows(snap *snapshot) []*viewRow {
+	rows := slices.Clone(lv.filteredRows(snap))
+	column := lv.state.sortColumn
+	descending := lv.isDescending()
+
+	slices.SortStableFunc(rows, func(a, b *viewRow) int {
+		if descending {
+			a, b = b, a
+		}
+		return compareValues(a.sortValue(column), b.sortValue(column))
+	})
+	return rows
+}
+
+func (lv *LiveView) isDescending() bool {
+	return (lv.state.sortColumn == 0) != lv.state.reverse
+}
+
+func (row *viewRow) sortValue(column int) string {
+	if column == 0 {
+		if row.hist != nil {
+			return strconv.FormatUint(bpfout.HistCount(row.hist), 10)
+		}
+		return row.value
+	}
+	if column <= len(row.keys) {
+		return row.keys[column-1]
+	}
+	return ""
+}
+
+// compareValues compares values numerically if both are numbers
+func compareValues(a, b string) int {
+	aNum, aErr := strconv.ParseFloat(a, 64)
+	bNum, bErr := strconv.ParseFloat(b, 64)
+	if aErr != nil || bErr != nil {
+		return strings.Compare(a, b)
+	}
+
+	switch {
+	case aNum < bNum:
+		return -1
+	case aNum > bNum:
+		return 1
+	}
+	return 0
+}
+
+func (lv *LiveView) renderTable(snap *snapshot, rows []*viewRow) []string {
+	headers := append(slices.Clone(lv.keyHeaders(snap)), lv.valueHeaders(snap)...)
+	cells := [][]string{headers}
+	for _, row := range rows {
+		cells = append(cells, append(slices.Clone(row.keys), row.values()...))
+	}
+
+	widths := make([]int, len(headers))
+	for _, rowCells := range cells {
+		for i, cell := range rowCells {
+			if i < len(widths) && len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+
+	lines := make([]string, 0, len(cells))
+	for _, rowCells := range cells {
+		var sb strings.Builder
+		for i, cell := range rowCells {
+			if i > 0 {
+				sb.WriteString(columnSeparator)
+			}
+			if i < len(widths) {
+				fmt.Fprintf(&sb, "%-*s", widths[i], cell)
+			} else {
+				sb.WriteString(cell)
+			}
+		}
+		lines = append(lines, strings.TrimRight(sb.String(), " "))
+	}
+	return lines
+}
+
+func (row *viewRow) values() []string {
+	if row.hist == nil {
+		return []string{row.value}
+	}
+
+	values := []string{strconv.FormatUint(bpfout.HistCount(row.hist), 10)}
+	for _, pct := range []float64{50, 99} {
+		if bucket := bpfout.HistPercentile(row.hist, pct); bucket != nil {
+			values = append(values, bucket.String())
+		} else {
+			values = append(values, "-")
+		}
+	}
+	return values
+}
+
+// renderHist renders buckets of the histogram with bars scaled to the width
+func renderHist(row *viewRow, width int) []string {
+	lines := []string{fmt.Sprintf("[%s]:", strings.Join(row.keys, ", "))}
+
+	var maxCount uint64
+	labelWidth, countWidth := 0, 0
+	for i := range row.hist {
+		bucket := &row.hist[i]
+		if bucket.Count > maxCount {
+			maxCount = bucket.Count
+		}
+		labelWidth = max(labelWidth, len(bucket.String()))
+		countWidth = max(countWidth, len(strconv.FormatUint(bucket.Count, 10)))
+	}
+
+	barWidth := width - labelWidth - countWidth - 2*len(columnSeparator) - 2
+	for i := range row.hist {
+		bucket := &row.hist[i]
+		barLength := 0
+		if maxCount > 0 && barWidth > 0 {
+			barLength = int(bucket.Count * uint64(barWidth) / maxCount)
+		}
+		lines = append(lines, fmt.Sprintf("%-*s%s%*d%s|%-*s|",
+			labelWidth, bucket.String(), columnSeparator,
+			countWidth, bucket.Count, columnSeparator,
+			max(barWidth, 0), strings.Repeat(histBarChar, barLength)))
+	}
+	return lines
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,133 @@
+package tui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Keys which are not printable runes
+const (
+	KeyEnter     = '\r'
+	KeyEscape    = 0x1b
+	KeyBackspace = 0x7f
+)
+
+const (
+	defaultWidth  = 80
+	defaultHeight = 24
+)
+
+// Terminal is a screen which live view is drawn on and a source of
+// keys pressed by user
+type Terminal interface {
+	// Size returns width and height of the screen in characters
+	Size() (width, height int)
+
+	// Draw replaces contents of the screen with lines
+	Draw(lines []string) error
+
+	// Keys returns channel of the pressed keys
+	Keys() <-chan rune
+
+	// Close restores terminal state
+	Close() error
+}
+
+// ttyTerminal draws on alternate screen of the terminal using ANSI escape
+// sequences. Terminal is switched to non-canonical mode using stty so
+// keys are read as they're pressed, while Ctrl-C still interrupts skbtrace.
+type ttyTerminal struct {
+	in  *os.File
+	out io.Writer
+
+	sttyState string
+	keys      chan rune
+}
+
+// OpenTerminal prepares terminal attached to in and out for drawing
+func OpenTerminal(in *os.File, out io.Writer) (Terminal, error) {
+	state, err := stty(in, "-g")
+	if err != nil {
+		return nil, fmt.Errorf("input is not a terminal: %w", err)
+	}
+	if _, err := stty(in, "-icanon", "-echo", "min", "1"); err != nil {
+		return nil, err
+	}
+
+	term := &ttyTerminal{
+		in:        in,
+		out:       out,
+		sttyState: strings.TrimSpace(state),
+		keys:      make(chan rune),
+	}
+	go term.readKeys()
+
+	// Switch to alternate screen and hide cursor
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	return term, nil
+}
+
+func stty(in *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = in
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+func (term *ttyTerminal) readKeys() {
+	buf := make([]byte, 16)
+	for {
+		n, err := term.in.Read(buf)
+		if err != nil {
+			close(term.keys)
+			return
+		}
+
+		// Escape sequences such as arrow keys are not supported, only
+		// standalone escape key is passed
+		if buf[0] == KeyEscape && n > 1 {
+			continue
+		}
+		for _, key := range string(buf[:n]) {
+			term.keys <- key
+		}
+	}
+}
+
+func (term *ttyTerminal) Size() (width, height int) {
+	out, err := stty(term.in, "size")
+	if err == nil {
+		if _, err = fmt.Sscanf(out, "%d %d", &height, &width); err == nil && width > 0 && height > 0 {
+			return width, height
+		}
+	}
+	return defaultWidth, defaultHeight
+}
+
+func (term *ttyTerminal) Draw(lines []string) error {
+	// Move cursor home and clear each line after printing it, so
+	// screen won't flicker
+	var sb strings.Builder
+	sb.WriteString("\x1b[H")
+	for _, line := range lines {
+		sb.WriteString(line)
+		sb.WriteString("\x1b[K\r\n")
+	}
+	sb.WriteString("\x1b[J")
+
+	_, err := io.WriteString(term.out, sb.String())
+	return err
+}
+
+func (term *ttyTerminal) Keys() <-chan rune {
+	return term.keys
+}
+
+func (term *ttyTerminal) Close() error {
+	fmt.Fprint(term.out, "\x1b[?25h\x1b[?1049l")
+	_, err := stty(term.in, term.sttyState)
+	return err
+}
@@ -0,0 +1,303 @@
+// Package tui renders aggregations printed by bpftrace on interval as
+// a live table in terminal which can be paused, sorted and filtered.
+package tui
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/yandex-cloud/skbtrace/pkg/bpfout"
+)
+
+// EndMarker should be printed by the script after all aggregations are
+// printed on interval, so view knows that snapshot is complete
+const EndMarker = "--- skbtrace tui ---"
+
+var reTime = regexp.MustCompile(`^\d{2}:\d{2}:\d{2}$`)
+
+// LiveView is an output processor which collects entries of the anonymous
+// map into snapshots and draws them as a table sorted by value. If values
+// are histograms, their totals and percentiles are shown along with the
+// histogram of the top row. Entries of other maps are shown below the table.
+type LiveView struct {
+	// Headers of key columns, generic headers are used if number of
+	// keys doesn't match
+	KeyHeaders []string
+
+	// Terminal to draw on. If not set, terminal is opened on
+	// stdin and output writer
+	Terminal Terminal
+
+	state viewState
+}
+
+type viewRow struct {
+	keys  []string
+	value string
+	hist  []bpfout.HistBucket
+}
+
+type snapshot struct {
+	time   string
+	rows   []*viewRow
+	extra  []string
+	isHist bool
+}
+
+type viewState struct {
+	// Last complete snapshot and snapshot which is shown while paused
+	last  *snapshot
+	shown *snapshot
+
+	// Column rows are sorted by: zero for value column, otherwise
+	// index of the key column starting with one
+	sortColumn int
+	reverse    bool
+	paused     bool
+
+	filter    string
+	editing   bool
+	filterBuf string
+
+	status string
+	quit   bool
+}
+
+// Process implements skbtrace.OutputProcessor
+func (lv *LiveView) Process(r io.Reader, w io.Writer) error {
+	term := lv.Terminal
+	var sigCh chan os.Signal
+	if term == nil {
+		var err error
+		term, err = OpenTerminal(os.Stdin, w)
+		if err != nil {
+			return err
+		}
+
+		// Quit on interrupt so terminal is restored. Runner forwards
+		// interrupts to bpftrace, so it finishes along with the view
+		sigCh = make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(sigCh)
+	}
+
+	// Scanner stops sending lines when view is done, so it doesn't block
+	// forever after quit while the caller drains the output
+	lines := make(chan string)
+	scanErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+		close(lines)
+		scanErr <- scanner.Err()
+	}()
+
+	err := lv.loop(term, lines, sigCh)
+	closeErr := term.Close()
+
+	// Alternate screen is lost when terminal is closed, so leave
+	// the last frame in the output
+	if lv.Terminal == nil {
+		for _, line := range lv.frame(term.Size()) {
+			fmt.Fprintln(w, line)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+	if lv.state.quit {
+		// Output is drained by the caller, so scanner may not finish
+		return closeErr
+	}
+	if err := <-scanErr; err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// UsesTerminal implements skbtrace.TerminalOutputProcessor
+func (lv *LiveView) UsesTerminal() bool {
+	return lv.Terminal == nil
+}
+
+func (lv *LiveView) loop(term Terminal, lines <-chan string, sigCh <-chan os.Signal) error {
+	cur := &snapshot{}
+	var histRow *viewRow
+	keys := term.Keys()
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				if len(cur.rows) > 0 {
+					lv.commit(cur)
+				}
+				return lv.draw(term)
+			}
+
+			switch {
+			case line == EndMarker:
+				lv.commit(cur)
+				if err := lv.draw(term); err != nil {
+					return err
+				}
+				cur, histRow = &snapshot{}, nil
+			case histRow != nil && line != "":
+				if bucket, ok := bpfout.ParseHistBucket(line); ok {
+					histRow.hist = append(histRow.hist, *bucket)
+				}
+			case line == "":
+				histRow = nil
+			case reTime.MatchString(line):
+				cur.time = line
+			default:
+				histRow = lv.parseEntry(cur, line)
+			}
+		case key, ok := <-keys:
+			if !ok {
+				keys = nil
+				continue
+			}
+
+			lv.handleKey(key)
+			if lv.state.quit {
+				return nil
+			}
+			if err := lv.draw(term); err != nil {
+				return err
+			}
+		case <-sigCh:
+			lv.state.quit = true
+			return nil
+		}
+	}
+}
+
+// parseEntry adds entry of the anonymous map as a row or line of other map
+// to the snapshot. Returns row if entry is a header of histogram
+func (lv *LiveView) parseEntry(cur *snapshot, line string) *viewRow {
+	if entry, ok := bpfout.ParseMapHeader(line); ok {
+		if entry.Map != "" {
+			cur.extra = append(cur.extra, line)
+			return nil
+		}
+
+		row := &viewRow{keys: entry.Keys, hist: []bpfout.HistBucket{}}
+		cur.rows = append(cur.rows, row)
+		cur.isHist = true
+		return row
+	}
+
+	if entry, ok := bpfout.ParseMapEntry(line); ok {
+		if entry.Map != "" {
+			cur.extra = append(cur.extra, line)
+		} else {
+			cur.rows = append(cur.rows, &viewRow{keys: entry.Keys, value: entry.Value})
+		}
+		return nil
+	}
+
+	// Messages of bpftrace such as "Attaching 2 probes..."
+	lv.state.status = line
+	return nil
+}
+
+func (lv *LiveView) commit(cur *snapshot) {
+	lv.state.last = cur
+	if !lv.state.paused {
+		lv.state.shown = cur
+	}
+}
+
+func (lv *LiveView) handleKey(key rune) {
+	state := &lv.state
+	if state.editing {
+		switch key {
+		case KeyEnter, '\n':
+			state.filter = state.filterBuf
+			state.editing = false
+		case KeyEscape:
+			state.editing = false
+		case KeyBackspace, '\b':
+			if len(state.filterBuf) > 0 {
+				runes := []rune(state.filterBuf)
+				state.filterBuf = string(runes[:len(runes)-1])
+			}
+		default:
+			state.filterBuf += string(key)
+		}
+		return
+	}
+
+	switch key {
+	case 'p', ' ':
+		state.paused = !state.paused
+		if !state.paused {
+			state.shown = state.last
+		}
+	case 's':
+		// Cycles from value column to key columns
+		state.sortColumn++
+		if state.shown == nil || state.sortColumn > len(lv.keyHeaders(state.shown)) {
+			state.sortColumn = 0
+		}
+	case 'r':
+		state.reverse = !state.reverse
+	case '/':
+		state.editing = true
+		state.filterBuf = state.filter
+	case KeyEscape:
+		state.filter = ""
+	case 'q':
+		state.quit = true
+	}
+}
+
+func (lv *LiveView) draw(term Terminal) error {
+	return term.Draw(lv.frame(term.Size()))
+}
+
+func (lv *LiveView) keyHeaders(snap *snapshot) []string {
+	numKeys := 0
+	if len(snap.rows) > 0 {
+		numKeys = len(snap.rows[0].keys)
+	}
+	if len(lv.KeyHeaders) == numKeys {
+		return lv.KeyHeaders
+	}
+
+	headers := make([]string, numKeys)
+	for i := range headers {
+		headers[i] = fmt.Sprintf("KEY%d", i+1)
+	}
+	return headers
+}
+
+// filteredRows returns rows which keys contain filter string
+func (lv *LiveView) filteredRows(snap *snapshot) []*viewRow {
+	if lv.state.filter == "" {
+		return snap.rows
+	}
+
+	var rows []*viewRow
+	for _, row := range snap.rows {
+		if strings.Contains(strings.Join(row.keys, ", "), lv.state.filter) {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
@@ -0,0 +1,199 @@
+package tui
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeTerminal struct {
+	keys   chan rune
+	frames chan []string
+}
+
+func newFakeTerminal() *fakeTerminal {
+	return &fakeTerminal{keys: make(chan rune), frames: make(chan []string)}
+}
+
+func (term *fakeTerminal) Size() (width, height int) {
+	return 72, 20
+}
+
+func (term *fakeTerminal) Draw(lines []string) error {
+	term.frames <- lines
+	return nil
+}
+
+func (term *fakeTerminal) Keys() <-chan rune {
+	return term.keys
+}
+
+func (term *fakeTerminal) Close() error {
+	return nil
+}
+
+// replay feeds recorded bpftrace output to the live view and returns
+// functions which send the next snapshot or a key and return drawn frame
+type replay struct {
+	t     *testing.T
+	term  *fakeTerminal
+	w     *io.PipeWriter
+	done  chan error
+	snaps []string
+}
+
+func newReplay(t *testing.T, lv *LiveView, path string) *replay {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	snaps := strings.SplitAfter(string(data), EndMarker+"\n")
+	r, w := io.Pipe()
+	rp := &replay{t: t, term: newFakeTerminal(), w: w, done: make(chan error), snaps: snaps}
+
+	lv.Terminal = rp.term
+	go func() {
+		rp.done <- lv.Process(r, io.Discard)
+	}()
+	return rp
+}
+
+func (rp *replay) nextSnapshot() []string {
+	snap := rp.snaps[0]
+	rp.snaps = rp.snaps[1:]
+	go func() {
+		_, err := io.WriteString(rp.w, snap)
+		assert.NoError(rp.t, err)
+	}()
+	return <-rp.term.frames
+}
+
+func (rp *replay) key(keys string) (frame []string) {
+	for _, key := range keys {
+		rp.term.keys <- key
+		frame = <-rp.term.frames
+	}
+	return frame
+}
+
+func (rp *replay) close() []string {
+	rp.w.Close()
+	frame := <-rp.term.frames
+	require.NoError(rp.t, <-rp.done)
+	return frame
+}
+
+// tableRows returns fields of the table rows which follow the header
+func tableRows(frame []string, header string) [][]string {
+	var rows [][]string
+	inTable := false
+	for _, line := range frame {
+		switch {
+		case strings.HasPrefix(line, header):
+			inTable = true
+		case line == "" || line == helpLine:
+			inTable = false
+		case inTable:
+			rows = append(rows, strings.Fields(line))
+		}
+	}
+	return rows
+}
+
+func TestLiveViewTable(t *testing.T) {
+	rp := newReplay(t, &LiveView{KeyHeaders: []string{"SRC", "DST"}}, "testdata/aggr.txt")
+
+	frame := rp.nextSnapshot()
+	assert.Equal(t, "skbtrace  12:00:01  rows: 3  sort: VALUE desc", frame[0])
+	assert.Equal(t, "Attaching 3 probes...", frame[1])
+	assert.Equal(t, [][]string{
+		{"10.0.0.1", "10.0.0.2", "15"},
+		{"10.0.0.2", "10.0.0.1", "7"},
+		{"10.0.0.3", "10.0.0.1", "2"},
+	}, tableRows(frame, "SRC"))
+	assert.Equal(t, helpLine, frame[len(frame)-1])
+
+	t.Run("Sort", func(t *testing.T) {
+		frame := rp.key("ss")
+		assert.Equal(t, "skbtrace  12:00:01  rows: 3  sort: DST asc", frame[0])
+		assert.Equal(t, [][]string{
+			{"10.0.0.3", "10.0.0.1", "2"},
+			{"10.0.0.2", "10.0.0.1", "7"},
+			{"10.0.0.1", "10.0.0.2", "15"},
+		}, tableRows(frame, "SRC"))
+
+		frame = rp.key("rsr")
+		assert.Equal(t, "skbtrace  12:00:01  rows: 3  sort: VALUE desc", frame[0])
+	})
+
+	t.Run("Filter", func(t *testing.T) {
+		frame := rp.key("/10.0.0.3x")
+		assert.Equal(t, "/10.0.0.3x_", frame[len(frame)-1])
+
+		frame = rp.key(string([]rune{KeyBackspace, KeyEnter}))
+		assert.Contains(t, frame[0], "filter: 10.0.0.3")
+		assert.Equal(t, [][]string{{"10.0.0.3", "10.0.0.1", "2"}}, tableRows(frame, "SRC"))
+
+		frame = rp.key(string([]rune{KeyEscape}))
+		assert.Len(t, tableRows(frame, "SRC"), 3)
+	})
+
+	t.Run("Pause", func(t *testing.T) {
+		frame := rp.key("p")
+		assert.Contains(t, frame[0], "[PAUSED]")
+
+		frame = rp.nextSnapshot()
+		assert.Contains(t, frame[0], "12:00:01")
+
+		frame = rp.key("p")
+		assert.Equal(t, "skbtrace  12:00:02  rows: 2  sort: VALUE desc", frame[0])
+		assert.Equal(t, [][]string{
+			{"10.0.0.3", "10.0.0.1", "9"},
+			{"10.0.0.1", "10.0.0.2", "4"},
+		}, tableRows(frame, "SRC"))
+	})
+
+	rp.close()
+}
+
+func TestLiveViewHist(t *testing.T) {
+	rp := newReplay(t, &LiveView{}, "testdata/hist.txt")
+
+	frame := rp.nextSnapshot()
+	assert.Equal(t, "skbtrace  12:00:01  rows: 2  sort: COUNT desc", frame[0])
+	assert.Equal(t, [][]string{
+		{"KEY1", "KEY2", "COUNT", "P50", "P99"},
+		{"eth0", "fq_codel", "100", "[2,", "4)", "[8,", "16)"},
+		{"eth1", "pfifo_fast", "1", "[2,", "4)", "[2,", "4)"},
+	}, append([][]string{strings.Fields(frame[2])}, tableRows(frame, "KEY1")[:2]...))
+
+	// Histogram of the top row is drawn with bars scaled to the screen
+	assert.Equal(t, []string{
+		"",
+		"[eth0, fq_codel]:",
+		"[1]       3  |@@@                                                      |",
+		"[2, 4)   50  |@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@|",
+		"[4, 8)   40  |@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@            |",
+		"[8, 16)   7  |@@@@@@@                                                  |",
+		"",
+		"@qdisc_requeues[eth0]: 2",
+	}, frame[5:13])
+
+	rp.close()
+}
+
+func TestLiveViewQuit(t *testing.T) {
+	rp := newReplay(t, &LiveView{}, "testdata/hist.txt")
+	rp.nextSnapshot()
+
+	// Quit key is a part of filter while it is edited
+	frame := rp.key("/q")
+	assert.Equal(t, "/q_", frame[len(frame)-1])
+
+	rp.key(string([]rune{KeyEscape}))
+	rp.term.keys <- 'q'
+	require.NoError(t, <-rp.done)
+}
@@ -0,0 +1,214 @@
+package skbtrace
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// Maximum number of events in pre-trigger history as dumping them
+	// is unrolled for older bpftrace versions lacking loops
+	maxPreTrigger = 32
+
+	preTriggerProbesTable = "pretrig_probes"
+)
+
+// PreTriggerOptions enable recording of events preceding the trigger (such
+// as outlier or duplicate) in a per-key ring buffer. When trigger fires,
+// recorded events are dumped with timestamps relative to the trigger and
+// history of the key is reset. History expires along with start time maps.
+type PreTriggerOptions struct {
+	// PreTrigger is a number of last events dumped for the key including
+	// the trigger event itself. Zero disables recording
+	PreTrigger int
+
+	// PreTriggerFields are compact numeric fields recorded for each event
+	PreTriggerFields []string
+
+	// PreTriggerProbes are extra probes such as free which act as triggers
+	// for the keys history was recorded for
+	PreTriggerProbes []string
+}
+
+type preTriggerContext struct {
+	opt   *PreTriggerOptions
+	hints []string
+
+	// Probes which record events indexed in the lookup table
+	probes LookupTable
+
+	// Field references of the last prepared probe, used for dumping
+	fields []*fieldAliasRef
+}
+
+func newPreTriggerContext(opt *PreTriggerOptions, commonOpt *CommonOptions) (*preTriggerContext, error) {
+	if opt.PreTrigger < 0 || opt.PreTrigger > maxPreTrigger {
+		return nil, fmt.Errorf("pre-trigger history size should be between 0 and %d", maxPreTrigger)
+	}
+	if opt.PreTrigger == 0 && len(opt.PreTriggerProbes) > 0 {
+		return nil, errors.New("pre-trigger probes require pre-trigger history size")
+	}
+
+	return &preTriggerContext{
+		opt:    opt,
+		hints:  commonOpt.Hints,
+		probes: make(LookupTable),
+	}, nil
+}
+
+func (ptCtx *preTriggerContext) enabled() bool {
+	return ptCtx.opt.PreTrigger > 0
+}
+
+// newPreTriggerRecord records event into a ring buffer of the current keys.
+// Fields are recorded in a nested block so their sanity filters won't affect
+// the trigger, fields of the event which are not available are reset. Values
+// are converted as they would be dumped except for the fields using lookup
+// tables which are applied when history is dumped.
+func newPreTriggerRecord(ptCtx *preTriggerContext) timeBuilderHelper {
+	return func(b *Builder, ctx *timeProbeContext) error {
+		if !ptCtx.enabled() {
+			return nil
+		}
+
+		fields, err := b.prepareKeys(ptCtx.opt.PreTriggerFields)
+		if err != nil {
+			return err
+		}
+
+		boSet := b.newBuildObjectSet(ctx.filters, nil, ptCtx.hints)
+		err = b.resolveWeakAliasRefs(b.getFieldWeakRefs(fields), boSet)
+		if err != nil {
+			return err
+		}
+		ptCtx.fields = fields
+
+		probeIndex := int64(len(ptCtx.probes) + 1)
+		ptCtx.probes[probeIndex] = ctx.probeName
+
+		// Slot is always taken by the event, so the dump could skip it
+		keysExpr := ExprJoin(ctx.keysExprs)
+		ctx.block.Addf("$pt_slot = @pretrig_head[%s] %% %d", keysExpr, ptCtx.opt.PreTrigger)
+		ctx.block.Addf("@pretrig_head[%s] += 1", keysExpr)
+		ctx.block.Addf("@pretrig_probe[%s, $pt_slot] = %d", keysExpr, probeIndex)
+		ctx.block.Addf("@pretrig_time[%s, $pt_slot] = nsecs", keysExpr)
+		for i := range fields {
+			ctx.block.Addf("delete(@pretrig_f%d[%s, $pt_slot])", i, keysExpr)
+		}
+
+		block, exprs, err := b.getBlockWithKeys(ctx.block, fields, ConverterHiddenKey)
+		if err != nil {
+			return err
+		}
+		for i, fref := range fields {
+			if fref.field.LookupTable != "" {
+				continue
+			}
+
+			stmts, expr, err := b.generateFieldExpression(fref.fg, fref.field, block, ConverterDump)
+			if err != nil {
+				return err
+			}
+			block.Add(stmts...)
+			exprs[i] = expr
+		}
+
+		for i, expr := range exprs {
+			block.Addf("@pretrig_f%d[%s, $pt_slot] = %s", i, keysExpr, expr)
+		}
+		return nil
+	}
+}
+
+// newPreTriggerDump dumps recorded events from the oldest to the newest one
+// and resets history of the keys. The newest event is the trigger itself
+// which is dumped separately, so it is skipped.
+func newPreTriggerDump(ptCtx *preTriggerContext, timeUnit string) timeBuilderHelper {
+	return func(b *Builder, ctx *timeProbeContext) error {
+		if !ptCtx.enabled() {
+			return nil
+		}
+
+		divisor, err := getTimeUnitDivisor(timeUnit)
+		if err != nil {
+			return err
+		}
+
+		keysExpr := ExprJoin(ctx.keysExprs)
+		fmtSpecs, fieldValues := b.generateMapPrintValues(
+			ctx.block, ptCtx.fields, "pretrig_f%d", Exprf("%s, $pt_slot", keysExpr))
+		fmtSpecs = append([]string{"%s -%d " + timeUnit}, fmtSpecs...)
+
+		ctx.block.Addf("$pt_head = @pretrig_head[%s]", keysExpr)
+		for i := 0; i < ptCtx.opt.PreTrigger; i++ {
+			ctx.block.Addf("$pt_slot = ($pt_head + %d) %% %d", i, ptCtx.opt.PreTrigger)
+
+			if i < ptCtx.opt.PreTrigger-1 {
+				values := append([]Expression{
+					Exprf("@%s[@pretrig_probe[%s, $pt_slot]]", preTriggerProbesTable, keysExpr),
+					Exprf("(nsecs - @pretrig_time[%s, $pt_slot]) / %d", keysExpr, divisor),
+				}, fieldValues...)
+
+				ctx.block.AddIfBlock(Exprf("@pretrig_time[%s, $pt_slot] > 0", keysExpr)).Addf(
+					`printf("HISTORY: %s\n", %s)`, strings.Join(fmtSpecs, " "), ExprJoin(values))
+			}
+
+			for _, aggr := range ptCtx.slotMaps() {
+				ctx.block.Addf("delete(%s[%s, $pt_slot])", aggr, keysExpr)
+			}
+		}
+		ctx.block.Addf("delete(@pretrig_head[%s])", keysExpr)
+		return nil
+	}
+}
+
+// buildPreTriggerProbes adds probes which record event and dump history
+// when hit for the keys history is already recorded for, followed by the
+// dump of the event itself. Keys and hints are taken from keySpec
+func (b *Builder) buildPreTriggerProbes(
+	prog *Program, ptCtx *preTriggerContext, keySpec TimeSpec,
+	opt *CommonOptions, dumpOpt CommonDumpOptions,
+) error {
+	for _, probeName := range ptCtx.opt.PreTriggerProbes {
+		spec := TimeSpec{Probe: probeName, Keys: keySpec.Keys, Hints: keySpec.Hints}
+		_, err := b.buildTimeProbe(prog, nil, spec, dumpOpt.FieldGroupRows, opt,
+			combineTimeHelpers(
+				newTimeMeasurePrepare(ConverterHiddenKey),
+				func(b *Builder, ctx *timeProbeContext) error {
+					ctx.block = ctx.block.AddIfBlock(Exprf("@pretrig_head[%s] > 0",
+						ExprJoin(ctx.keysExprs)))
+					return nil
+				},
+				newPreTriggerRecord(ptCtx),
+				newPreTriggerDump(ptCtx, opt.TimeUnit),
+				func(b *Builder, ctx *timeProbeContext) error {
+					_, err := b.addDumpRowsStatements(ctx.block, dumpOpt)
+					return err
+				}))
+		if err != nil {
+			return newProbeBuildError(fmt.Sprintf("%s (pre-trigger)", probeName), err)
+		}
+	}
+	return nil
+}
+
+// slotMaps returns maps which keep events in ring buffer slots
+func (ptCtx *preTriggerContext) slotMaps() []string {
+	aggrs := []string{"@pretrig_probe", "@pretrig_time"}
+	for i := range ptCtx.fields {
+		aggrs = append(aggrs, fmt.Sprintf("@pretrig_f%d", i))
+	}
+	return aggrs
+}
+
+// addPreTriggerCleanup renders probe names table and returns maps which
+// should be cleaned up along with start time maps
+func (prog *Program) addPreTriggerCleanup(ptCtx *preTriggerContext) []string {
+	if !ptCtx.enabled() {
+		return nil
+	}
+
+	prog.addLookupTable(preTriggerProbesTable, ptCtx.probes)
+	return append([]string{"@pretrig_head"}, ptCtx.slotMaps()...)
+}
@@ -5,6 +5,10 @@ import (
 	"strings"
 )
 
+// retvalArg is an argument of return probes which contains value returned
+// by the function
+const retvalArg = "retval"
+
 func newProbeBuildError(probeName string, err error) *errorImpl {
 	return newErrorf(ErrLevelProbe, probeName, err, "error building probe")
 }
@@ -22,6 +26,12 @@ type Probe struct {
 	// Context contains initial mapping untyped variable names to probe raw arguments
 	Args map[string]string
 
+	// StashArgs marks a return probe which accesses arguments of the function.
+	// Entry probe which saves them into maps keyed by cpu and thread id is added
+	// automatically, they are deleted by return probe. Return value is accessible
+	// as retval argument
+	StashArgs bool
+
 	// Help string dumped by probes command
 	Help string
 }
@@ -39,19 +49,47 @@ func (b *Builder) addProbeBlock(
 	}
 
 	name := probe.Name
-	if isReturn {
+	isStashed := probe.StashArgs
+	if isStashed {
+		if isReturn {
+			return nil, nil, newProbeBuildError(probe.Name,
+				errors.New("probe with stashed args is already a return probe"))
+		}
+
+		var err error
+		probe, err = prog.addStashBlock(probe)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else if isReturn {
 		retName, err := probe.ReturnProbe()
 		if err != nil {
 			return nil, nil, err
 		}
 		name = retName
+
+		// Arguments are not accessible in return probe
+		probe = &Probe{Name: retName, Args: map[string]string{retvalArg: "retval"}, Help: probe.Help}
 	}
 
 	probeBlock := prog.AddProbeBlock(name, probe)
+	probeBlock.probeName = probeName
+	if isStashed {
+		prog.addStashCleanup(probeBlock)
+	}
 	block, err := b.wrapFilters(probeBlock, filters)
 	return probeBlock, block, err
 }
 
+// EntryProbe returns name of the entry probe for return probe
+func (p *Probe) EntryProbe() (string, error) {
+	if strings.HasPrefix(p.Name, "kretprobe:") {
+		return "kprobe:" + p.Name[10:], nil
+	}
+
+	return "", newProbeBuildError(p.Name, errors.New("can't deduce entry probe name"))
+}
+
 func (p *Probe) ReturnProbe() (string, error) {
 	if p.ReturnName != "" {
 		return p.ReturnName, nil
@@ -7,6 +7,9 @@ import (
 	"io"
 	"strings"
 	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 )
 
 const (
@@ -51,19 +54,50 @@ type Block struct {
 	prog  *Program
 	probe *Probe
 
+	// probeName is the name of the probe as specified in options, i.e. alias
+	// of the probe. Used for labeling diagnostic counters such as @hits
+	probeName string
+
 	context BlockContext
+
+	// epilogue contains statements rendered after all other statements of
+	// the block such as deletion of stashed arguments
+	epilogue []Statement
 }
 
+// LookupTable maps integer values to human-readable strings such as
+// interface indices to their names
+type LookupTable map[int64]string
+
 type Program struct {
 	HeaderFiles map[string]struct{}
 	StructDefs  map[string]*StructDef
 	Blocks      []*Block
+
+	// bpftrace allows only single BEGIN and END probes, so they are shared
+	beginBlock *Block
+	endBlock   *Block
+
+	lookupTables map[string]struct{}
+
+	// Copies of probes with stashed arguments referring to stash maps
+	stashProbes map[string]*Probe
+
+	// Last return blocks of probes with stashed arguments which delete them
+	stashReturnBlocks map[string]*Block
+
+	// OutputProcessor is used by runner to post-process bpftrace output
+	OutputProcessor OutputProcessor
 }
 
 func NewProgram() *Program {
 	return &Program{
-		HeaderFiles: make(map[string]struct{}),
-		StructDefs:  make(map[string]*StructDef),
+		HeaderFiles:  make(map[string]struct{}),
+		StructDefs:   make(map[string]*StructDef),
+		lookupTables: make(map[string]struct{}),
+		stashProbes:  make(map[string]*Probe),
+
+		stashReturnBlocks: make(map[string]*Block),
 	}
 }
 
@@ -128,7 +162,7 @@ func (block *Block) render(buf *bufio.Writer, indent string) {
 	buf.WriteString("{\n")
 
 	blockIndent := indent + programIndent
-	for _, stmt := range block.Statements {
+	for _, stmt := range append(slices.Clip(block.Statements), block.epilogue...) {
 		if stmt.b != nil {
 			stmt.b.render(buf, blockIndent)
 			buf.WriteByte('\n')
@@ -166,6 +200,106 @@ func (prog *Program) AddProbeBlock(probeDef string, probe *Probe) *Block {
 	return block
 }
 
+// BeginBlock returns block of BEGIN probe creating it on first call
+func (prog *Program) BeginBlock() *Block {
+	if prog.beginBlock == nil {
+		prog.beginBlock = &Block{
+			Preamble: "BEGIN",
+			prog:     prog,
+			context:  make(BlockContext),
+		}
+		prog.Blocks = append([]*Block{prog.beginBlock}, prog.Blocks...)
+	}
+	return prog.beginBlock
+}
+
+// EndBlock returns block of END probe creating it on first call
+func (prog *Program) EndBlock() *Block {
+	if prog.endBlock == nil {
+		prog.endBlock = prog.AddProbeBlock("END", nil)
+	}
+	return prog.endBlock
+}
+
+// addLookupTable renders lookup table into a global map filled in BEGIN
+// probe and cleared on exit so it won't be printed
+func (prog *Program) addLookupTable(name string, table LookupTable) {
+	if _, ok := prog.lookupTables[name]; ok {
+		return
+	}
+	prog.lookupTables[name] = struct{}{}
+
+	values := maps.Keys(table)
+	slices.Sort(values)
+
+	beginBlock := prog.BeginBlock()
+	for _, value := range values {
+		beginBlock.Addf(`@%s[%d] = "%s"`, name, value, table[value])
+	}
+	prog.EndBlock().Addf("clear(@%s)", name)
+}
+
+// addStashBlock adds entry probe which saves arguments of the return probe
+// into maps keyed by cpu and thread id and returns a copy of probe which refers to them.
+// Thread id alone is not unique as softirq inherits it from the interrupted task
+// (and it is zero for idle task on every cpu). Entries leak if task migrates to
+// another cpu before return, so they are also periodically cleaned up
+func (prog *Program) addStashBlock(probe *Probe) (*Probe, error) {
+	if stashProbe, ok := prog.stashProbes[probe.Name]; ok {
+		return stashProbe, nil
+	}
+
+	entryName, err := probe.EntryProbe()
+	if err != nil {
+		return nil, err
+	}
+
+	stashProbe := &Probe{
+		Name:    probe.Name,
+		Aliases: probe.Aliases,
+		Args:    map[string]string{retvalArg: "retval"},
+		Help:    probe.Help,
+	}
+
+	args := maps.Keys(probe.Args)
+	slices.Sort(args)
+
+	entryBlock := prog.AddProbeBlock(entryName, probe)
+	funcName := entryName[strings.IndexByte(entryName, ':')+1:]
+	stashMaps := make([]string, 0, len(args))
+	for _, arg := range args {
+		stashMap := fmt.Sprintf("@%s_%s", funcName, arg)
+		stashProbe.Args[arg] = stashMap + "[cpu, tid]"
+
+		entryBlock.Addf("%s = %s", stashProbe.Args[arg], probe.Args[arg])
+		stashMaps = append(stashMaps, stashMap)
+	}
+	prog.addAggrCleanupBlock(stashMaps...)
+
+	prog.stashProbes[probe.Name] = stashProbe
+	return stashProbe, nil
+}
+
+// addStashCleanup deletes stashed arguments after they were used by return
+// probe block. If the same return probe is used by multiple blocks, only the
+// last one deletes them
+func (prog *Program) addStashCleanup(block *Block) {
+	if prevBlock, ok := prog.stashReturnBlocks[block.probe.Name]; ok {
+		block.epilogue, prevBlock.epilogue = prevBlock.epilogue, nil
+	} else {
+		args := maps.Keys(block.probe.Args)
+		slices.Sort(args)
+		for _, arg := range args {
+			if arg == retvalArg {
+				continue
+			}
+			block.epilogue = append(block.epilogue, Stmtf("delete(%s)", block.probe.Args[arg]))
+		}
+	}
+
+	prog.stashReturnBlocks[block.probe.Name] = block
+}
+
 func (prog *Program) AddIntervalBlock(d time.Duration) *Block {
 	if d.Seconds() > 0 {
 		return prog.AddProbeBlock(fmt.Sprintf("interval:s:%d", int(d.Seconds())), nil)
@@ -181,28 +315,61 @@ func (prog *Program) AddIntervalOrEndBlock(d time.Duration) *Block {
 }
 
 func (prog *Program) addCommonBlock(opt *CommonOptions) {
+	if opt.Timeout <= 0 {
+		return
+	}
+
 	timeoutBlock := prog.AddIntervalBlock(opt.Timeout)
 	timeoutBlock.Add(Stmt("exit()"))
 }
 
-func (prog *Program) addAggrDumpBlock(interval time.Duration, truncate int) {
-	printStmt := Stmt("print(@)")
-	if truncate > 0 {
-		printStmt = Stmtf("print(@, %d)", truncate)
+// addAggrDumpBlock prints and clears anonymous aggregation on interval
+// followed by extra aggregations such as auxiliary counters and end marker
+func (prog *Program) addAggrDumpBlock(opt *AggregateCommonOptions, aggrs ...string) {
+	block := prog.AddIntervalBlock(opt.Interval)
+	block.Add(Stmt("time()"))
+	for _, aggr := range append([]string{"@"}, aggrs...) {
+		if opt.Truncate > 0 {
+			block.Addf("print(%s, %d)", aggr, opt.Truncate)
+		} else {
+			block.Addf("print(%s)", aggr)
+		}
+		block.Addf("clear(%s)", aggr)
+	}
+	if opt.EndMarker != "" {
+		block.Addf(`printf("%s\n")`, opt.EndMarker)
 	}
+}
 
-	block := prog.AddIntervalBlock(interval)
-	block.Add(
-		Stmt("time()"),
-		printStmt,
-		Stmt("clear(@)"))
+// addTableDumpBlocks prints maps followed by the end marker on interval and on
+// exit, so output processor can join them into a table. Maps in clearMaps are
+// cleared after printing.
+func (prog *Program) addTableDumpBlocks(
+	interval time.Duration, endMarker string, printMaps, clearMaps []string,
+) {
+	intervalBlock := prog.AddIntervalBlock(interval)
+	intervalBlock.Add(Stmt("time()"))
+	for _, block := range []*Block{intervalBlock, prog.EndBlock()} {
+		for _, aggr := range printMaps {
+			block.Addf("print(%s)", aggr)
+		}
+		block.Addf(`printf("%s\n")`, endMarker)
+		for _, aggr := range clearMaps {
+			block.Addf("clear(%s)", aggr)
+		}
+	}
 }
 
 func (prog *Program) addAggrCleanupBlock(aggrs ...string) {
+	if len(aggrs) == 0 {
+		return
+	}
+
 	// Cleanup start_time map in case it will leak
-	block := prog.AddIntervalOrEndBlock(aggrCleanupInterval)
+	block := prog.AddIntervalBlock(aggrCleanupInterval)
 	for _, aggr := range aggrs {
 		block.Addf("clear(%s)", aggr)
+		prog.EndBlock().Addf("clear(%s)", aggr)
 	}
 }
 
@@ -223,6 +390,8 @@ func (block *Block) AddBlock(preamble string) *Block {
 		probe:    block.probe,
 		prog:     block.prog,
 		context:  make(BlockContext),
+
+		probeName: block.probeName,
 	}
 
 	for k, v := range block.context {
@@ -0,0 +1,95 @@
+package skbtrace
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// recordingOutputMarker separates header of the recording from the output
+// of bpftrace. It ends YAML document, and as script is indented in the
+// header, it cannot be found there.
+const recordingOutputMarker = "..."
+
+// Recording is a raw output of bpftrace saved along with the script which
+// produced it and the command line of skbtrace, so output can be processed
+// offline by replaying it. Recording is stored as a YAML header followed
+// by the output, so it can be saved while bpftrace is running.
+type Recording struct {
+	// Command line of skbtrace which produced the recording
+	Args []string `yaml:"args"`
+
+	// Versions of the feature components such as bpftrace as they were
+	// detected when recording
+	Versions map[string]string `yaml:"versions,omitempty"`
+
+	// Options of the command such as keys, rows and time unit
+	Options map[string]string `yaml:"options,omitempty"`
+
+	Script string `yaml:"script"`
+
+	// Output of bpftrace which follows the header
+	Output []byte `yaml:"-"`
+}
+
+// ReadRecording reads recording saved by runner
+func ReadRecording(path string) (*Recording, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	header, output, ok := bytes.Cut(data, []byte("\n"+recordingOutputMarker+"\n"))
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not a skbtrace recording", path)
+	}
+
+	rec := &Recording{Output: output}
+	if err := yaml.Unmarshal(header, rec); err != nil {
+		return nil, fmt.Errorf("cannot parse recording '%s': %w", path, err)
+	}
+	if len(rec.Args) == 0 {
+		return nil, errors.New("recording doesn't contain command line")
+	}
+	return rec, nil
+}
+
+// startRecording creates recording file and writes its header. Output of
+// bpftrace should be written to the returned file.
+func startRecording(prog *Program, opt *RunnerOptions) (*os.File, error) {
+	var script strings.Builder
+	if err := prog.render(&script, false); err != nil {
+		return nil, err
+	}
+	opt.Recording.Script = script.String()
+
+	header, err := yaml.Marshal(opt.Recording)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Create(opt.RecordPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(f, "%s%s\n", header, recordingOutputMarker); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// replayOutput passes output from the recording to the output processor
+// as if it was printed by bpftrace
+func replayOutput(r io.Reader, processor OutputProcessor, w io.Writer) error {
+	if processor == nil {
+		_, err := io.Copy(w, r)
+		return err
+	}
+	return processor.Process(r, w)
+}
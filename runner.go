@@ -1,13 +1,16 @@
 package skbtrace
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/signal"
 	"regexp"
 	"strings"
+	"syscall"
 )
 
 var bpfTraceEnv = []string{
@@ -18,6 +21,39 @@ var bpfTraceEnv = []string{
 type RunnerOptions struct {
 	DumpScript     bool
 	BPFTraceBinary string
+
+	// RecordPath specifies file where Recording is saved along with the
+	// output of bpftrace. Script is filled by runner.
+	RecordPath string
+	Recording  *Recording
+
+	// Replay if set is processed as an output of bpftrace instead of
+	// running it
+	Replay io.Reader
+
+	// ScriptRunner if set runs script instead of bpftrace
+	ScriptRunner ScriptRunner
+}
+
+// ScriptRunner runs rendered script in place of bpftrace, such as
+// simulator used by tests
+type ScriptRunner interface {
+	RunScript(script io.Reader, w io.Writer) error
+}
+
+// OutputProcessor post-processes output of bpftrace before it is shown to
+// user, i.e. to render printed maps as a table
+type OutputProcessor interface {
+	Process(r io.Reader, w io.Writer) error
+}
+
+// TerminalOutputProcessor is an output processor which takes over the
+// terminal, such as live view. Messages of bpftrace are shown after it
+// finishes and sudo is not allowed to prompt for password while it runs
+type TerminalOutputProcessor interface {
+	OutputProcessor
+
+	UsesTerminal() bool
 }
 
 type BPFTraceVersionProvider struct{}
@@ -66,6 +102,36 @@ func Run(w io.Writer, prog *Program, opt RunnerOptions) error {
 		return nil
 	}
 
+	var recordW io.Writer
+	if opt.RecordPath != "" {
+		recordF, err := startRecording(prog, &opt)
+		if err != nil {
+			return err
+		}
+		defer recordF.Close()
+		recordW = recordF
+	}
+
+	if opt.ScriptRunner != nil {
+		// Output of script runner is processed as if it was replayed
+		var script, out bytes.Buffer
+		if err := prog.render(&script, false); err != nil {
+			return err
+		}
+		if err := opt.ScriptRunner.RunScript(&script, &out); err != nil {
+			return err
+		}
+		opt.Replay = &out
+	}
+
+	if opt.Replay != nil {
+		r := opt.Replay
+		if recordW != nil {
+			r = io.TeeReader(r, recordW)
+		}
+		return replayOutput(r, prog.OutputProcessor, w)
+	}
+
 	f, err := ioutil.TempFile(os.TempDir(), "skbtrace")
 	if err != nil {
 		return err
@@ -81,5 +147,97 @@ func Run(w io.Writer, prog *Program, opt RunnerOptions) error {
 	cmd.Stdout = w
 	cmd.Stderr = w
 	cmd.Env = append(cmd.Env, bpfTraceEnv...)
+	if prog.OutputProcessor == nil {
+		if recordW != nil {
+			cmd.Stdout = io.MultiWriter(w, recordW)
+		}
+		return runCommand(cmd, nil)
+	}
+
+	if tp, ok := prog.OutputProcessor.(TerminalOutputProcessor); ok && tp.UsesTerminal() {
+		// Ask for password before processor takes over the terminal and
+		// fail instead of prompting afterwards
+		if err := validateSudo(); err != nil {
+			return err
+		}
+		cmd.Args = append([]string{"sudo", "--non-interactive"}, cmd.Args[1:]...)
+
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		err := runWithProcessor(cmd, prog.OutputProcessor, w, recordW)
+		w.Write(stderr.Bytes())
+		return err
+	}
+
+	return runWithProcessor(cmd, prog.OutputProcessor, w, recordW)
+}
+
+// validateSudo updates cached credentials of sudo prompting for password
+// if needed
+func validateSudo() error {
+	cmd := exec.Command("sudo", "--validate")
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
+
+func runWithProcessor(cmd *exec.Cmd, processor OutputProcessor, w, recordW io.Writer) error {
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	if recordW != nil {
+		cmd.Stdout = io.MultiWriter(pw, recordW)
+	}
+
+	procErrCh := make(chan error, 1)
+	procDoneCh := make(chan struct{})
+	go func() {
+		err := processor.Process(pr, w)
+		close(procDoneCh)
+
+		// Drain the rest of output so bpftrace won't block on failure
+		io.Copy(io.Discard, pr)
+		procErrCh <- err
+	}()
+
+	err := runCommand(cmd, procDoneCh)
+	pw.Close()
+	procErr := <-procErrCh
+	if err != nil {
+		return err
+	}
+	return procErr
+}
+
+// runCommand runs bpftrace forwarding interrupts to it instead of exiting,
+// so bpftrace can run END probe and its output is processed before exit.
+// bpftrace is also interrupted when stopCh is closed, i.e. if output
+// processor has finished before bpftrace, such as when user quits live view.
+func runCommand(cmd *exec.Cmd, stopCh <-chan struct{}) error {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	doneCh := make(chan struct{})
+	defer close(doneCh)
+	go func() {
+		for {
+			select {
+			case sig := <-sigCh:
+				// Process might be already finished, nothing to do then
+				cmd.Process.Signal(sig)
+			case <-stopCh:
+				cmd.Process.Signal(os.Interrupt)
+				stopCh = nil
+			case <-doneCh:
+				return
+			}
+		}
+	}()
+
+	return cmd.Wait()
+}
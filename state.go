@@ -0,0 +1,213 @@
+package skbtrace
+
+import (
+	"errors"
+)
+
+// Options for BuildStateTransitions.
+type StateTransitionOptions struct {
+	CommonOptions
+
+	// Specification of the probe which fires on state change. Keys identify
+	// an entity which changes state, such as connection's 4-tuple.
+	Spec TimeSpec
+
+	// Fields containing old and new state values
+	OldState string
+	NewState string
+
+	// Optional human-readable names of states indexed by state value
+	StateNames []string
+
+	// Filters which identify transition to the final state after which
+	// entity is no longer tracked
+	FinalFilterOptions FilterOptions
+
+	// If set, transitions are aggregated by pair of old and new states
+	// instead of being dumped
+	Aggregate bool
+	AggregateCommonOptions
+
+	// Aggregate Func for time spent in old state
+	Func AggrFunc
+
+	CommonDumpOptions
+}
+
+type stateTransitionContext struct {
+	oldStateExpr Expression
+	newStateExpr Expression
+	hasNames     bool
+}
+
+func newStatePrepare(opt *StateTransitionOptions, stateCtx *stateTransitionContext) timeBuilderHelper {
+	return func(b *Builder, ctx *timeProbeContext) error {
+		stateKeys, err := b.prepareKeys([]string{opt.OldState, opt.NewState})
+		if err != nil {
+			return err
+		}
+
+		block, exprs, err := b.getBlockWithKeys(ctx.block, stateKeys, ConverterHiddenKey)
+		if err != nil {
+			return err
+		}
+
+		ctx.block = block
+		block.Addf("$old_state = %s", exprs[0])
+		block.Addf("$new_state = %s", exprs[1])
+
+		stateCtx.oldStateExpr = Expr("$old_state")
+		stateCtx.newStateExpr = Expr("$new_state")
+		if stateCtx.hasNames {
+			stateCtx.oldStateExpr = Expr("@state_names[$old_state]")
+			stateCtx.newStateExpr = Expr("@state_names[$new_state]")
+		}
+		return nil
+	}
+}
+
+func stateMeasureTimeImpl(b *Builder, ctx *timeProbeContext) error {
+	keysExpr := ExprJoin(ctx.keysExprs)
+	ctx.block.Addf("$st = @state_time[%s]", keysExpr)
+	ctx.block.Add(Stmt("$dt = 0"))
+	ctx.block.AddIfBlock(Expr("$st > 0")).Add(Stmt("$dt = (nsecs - $st)"))
+	ctx.block.Addf("@state_time[%s] = nsecs", keysExpr)
+	return nil
+}
+
+func newStateFinalCleanup(finalFilters [][]*ProcessedFilter) timeBuilderHelper {
+	return func(b *Builder, ctx *timeProbeContext) error {
+		if len(finalFilters) == 0 {
+			return nil
+		}
+
+		block, err := b.wrapFilters(ctx.block, finalFilters)
+		if err != nil {
+			return err
+		}
+
+		block.Addf("delete(@state_time[%s])", ExprJoin(ctx.keysExprs))
+		return nil
+	}
+}
+
+func newStateDumper(opt *StateTransitionOptions, stateCtx *stateTransitionContext) timeBuilderHelper {
+	return func(b *Builder, ctx *timeProbeContext) error {
+		divisor, err := getTimeUnitDivisor(opt.TimeUnit)
+		if err != nil {
+			return err
+		}
+
+		stateFmtSpec := "%d"
+		if stateCtx.hasNames {
+			stateFmtSpec = "%s"
+		}
+
+		// Time and probe are printed before rows if they are specified
+		if len(opt.FieldGroupRows) > 0 {
+			_, err = b.addDumpRowsStatements(ctx.block, opt.CommonDumpOptions)
+		} else {
+			err = b.addTimeStatements(ctx.block, opt.TimeMode, ctx.block.probe.Name)
+		}
+		if err != nil {
+			return err
+		}
+
+		ctx.block.Addf(`printf("STATE: %s -> %s after %%d %s\n", %s, %s, $dt / %d)`,
+			stateFmtSpec, stateFmtSpec, opt.TimeUnit,
+			stateCtx.oldStateExpr, stateCtx.newStateExpr, divisor)
+		return nil
+	}
+}
+
+func newStateAggregate(opt *StateTransitionOptions, stateCtx *stateTransitionContext) timeBuilderHelper {
+	return func(b *Builder, ctx *timeProbeContext) error {
+		aggrKeys := ExprJoin([]Expression{stateCtx.oldStateExpr, stateCtx.newStateExpr})
+		if opt.Func == AFCount {
+			ctx.block.Addf("@[%s] = count()", aggrKeys)
+			return nil
+		}
+
+		divisor, err := getTimeUnitDivisor(opt.TimeUnit)
+		if err != nil {
+			return err
+		}
+
+		// Time spent in the old state is unknown for the first seen transition
+		ctx.block.AddIfBlock(Expr("$st > 0")).Addf("@[%s] = %s($dt / %d)", aggrKeys, opt.Func, divisor)
+		return nil
+	}
+}
+
+func (prog *Program) addStateNamesBlock(stateNames []string) {
+	block := prog.BeginBlock()
+	for value, name := range stateNames {
+		if len(name) > 0 {
+			block.Addf(`@state_names[%d] = "%s"`, value, name)
+		}
+	}
+}
+
+// BuildStateTransitions builds a program which tracks state of entities
+// identified by keys (such as TCP connections) and either dumps or aggregates
+// transitions between states along with the time spent in the old state.
+func (b *Builder) BuildStateTransitions(opt StateTransitionOptions) (*Program, error) {
+	if opt.OldState == "" || opt.NewState == "" {
+		return nil, errors.New("old and new state fields should be specified")
+	}
+
+	finalFilters, err := b.prepareFilters(opt.FinalFilterOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	prog := NewProgram()
+	prog.addCommonBlock(&opt.CommonOptions)
+
+	stateCtx := &stateTransitionContext{hasNames: len(opt.StateNames) > 0}
+	if stateCtx.hasNames {
+		prog.addStateNamesBlock(opt.StateNames)
+	}
+
+	var transitionHelper timeBuilderHelper
+	if opt.Aggregate {
+		transitionHelper = newStateAggregate(&opt, stateCtx)
+	} else {
+		transitionHelper = newStateDumper(&opt, stateCtx)
+	}
+
+	builder := combineTimeHelpers(
+		newTimeMeasurePrepare(ConverterHiddenKey),
+		newStatePrepare(&opt, stateCtx),
+		stateMeasureTimeImpl,
+		transitionHelper,
+		newStateFinalCleanup(finalFilters))
+
+	var rows []string
+	if !opt.Aggregate {
+		rows = opt.FieldGroupRows
+	}
+	_, err = b.buildTimeProbe(prog, nil, opt.Spec, rows, &opt.CommonOptions, builder)
+	if err != nil {
+		return nil, newProbeBuildError(opt.Spec.Probe, err)
+	}
+
+	aggrs := []string{"@state_time"}
+	if stateCtx.hasNames {
+		aggrs = append(aggrs, "@state_names")
+	}
+	if opt.Aggregate {
+		prog.addAggrDumpBlock(&opt.AggregateCommonOptions)
+	}
+	prog.addStateCleanupBlock(aggrs...)
+	return prog, nil
+}
+
+// addStateCleanupBlock clears state maps on exit. Unlike addAggrCleanupBlock
+// it doesn't clear them periodically as states are long-living.
+func (prog *Program) addStateCleanupBlock(aggrs ...string) {
+	block := prog.EndBlock()
+	for _, aggr := range aggrs {
+		block.Addf("clear(%s)", aggr)
+	}
+}
@@ -17,6 +17,8 @@ const (
 	TUNanosecond  = "ns"
 )
 
+var errCountersNotSupported = errors.New("counters are only supported when aggregating time deltas")
+
 var timeUnitDivisors = map[string]time.Duration{
 	TUSecond:      time.Second,
 	TUMillisecond: time.Millisecond,
@@ -36,6 +38,11 @@ type TimeSpec struct {
 
 	// Hints for this probe
 	Hints []string
+
+	// If set, return probe of Probe is used. Only return value and global
+	// variables such as tid are accessible in it, so filters are never
+	// derived from the from probe
+	Return bool
 }
 
 // TimeCommonOptions are shared between time options requests.
@@ -48,6 +55,47 @@ type TimeCommonOptions struct {
 	// Specification of the probe in which time delta is computed.
 	// If filters or keys are omitted in ToSpec, they are derived from FromSpec.
 	ToSpec TimeSpec
+
+	// Keys of the to probe time deltas are aggregated by, such as device.
+	// Only supported by BuildTimeAggregate.
+	AggrKeys []string
+
+	// Counters of auxiliary events, such as drops, which are reported
+	// along with time deltas. Only supported by BuildTimeAggregate.
+	Counters []TimeCounterSpec
+
+	// Explain optionally specifies intermediate probe which values are
+	// saved to annotate outliers. Only supported by BuildTimeOutlierDump.
+	Explain TimeExplainOptions
+}
+
+// TimeExplainOptions specify a probe which fires between from and to probes,
+// such as a routing decision, and which fields are reported along with the
+// outliers. Explain probe keys are mapped to the packet using FromKeys and
+// ToKeys, which should be ordered same as keys of the explain probe.
+type TimeExplainOptions struct {
+	// Specification of the explain probe, ignored if probe is not set
+	Spec TimeSpec
+
+	// Keys of the from and to probes which match keys of the explain probe
+	FromKeys []string
+	ToKeys   []string
+
+	// Fields which are saved in explain probe and dumped with outliers
+	Fields []string
+
+	// Filters which catch events which would never reach to probe. If they
+	// match, event is dumped immediately using MissRows
+	MissFilterOptions FilterOptions
+	MissRows          []string
+}
+
+// TimeCounterSpec specifies auxiliary event which is counted by keys
+type TimeCounterSpec struct {
+	TimeSpec
+
+	// Name of the map counts are kept in
+	Name string
 }
 
 // Options for BuildTimeAggregate.
@@ -75,6 +123,8 @@ type TimeOutlierDumpOptions struct {
 	Exit bool
 
 	CommonDumpOptions
+	DumpLimitOptions
+	PreTriggerOptions
 }
 
 // Options for BuildDuplicateEvent.
@@ -87,7 +137,20 @@ type DuplicateEventOptions struct {
 	// Specifies if exit() should be called on first duplicate
 	Exit bool
 
+	// Window in which repeated events are considered duplicates. Zero
+	// means that key is remembered until periodic cleanup
+	Window time.Duration
+
+	// MinCount is a number of times key should be seen within window
+	// to report duplicate, values less than 2 are treated as 2
+	MinCount int
+
+	// Aggregate counts duplicates per key instead of dumping them
+	Aggregate bool
+	AggregateCommonOptions
+
 	CommonDumpOptions
+	PreTriggerOptions
 }
 
 // timeBuilderHelper is a function which injects statements into a probe
@@ -176,14 +239,42 @@ func newEventCounter(toEventCount int) timeBuilderHelper {
 	}
 }
 
-func newAggregateTimeDelta(aggrFunc AggrFunc, timeUnit string) timeBuilderHelper {
+func newAggregateTimeDelta(aggrFunc AggrFunc, timeUnit string, aggrKeys, hints []string) timeBuilderHelper {
 	return func(b *Builder, ctx *timeProbeContext) error {
 		divisor, err := getTimeUnitDivisor(timeUnit)
 		if err != nil {
 			return err
 		}
 
-		ctx.block.Addf("@ = %s($dt / %d)", aggrFunc, divisor)
+		if len(aggrKeys) == 0 {
+			ctx.block.Addf("@ = %s($dt / %d)", aggrFunc, divisor)
+			return nil
+		}
+
+		keys, err := b.prepareKeys(aggrKeys)
+		if err != nil {
+			return err
+		}
+		err = b.resolveWeakAliasRefs(b.getFieldWeakRefs(keys),
+			b.newBuildObjectSet(ctx.filters, nil, hints))
+		if err != nil {
+			return err
+		}
+
+		// Keys are dumped, so converters are applied. Block is not replaced
+		// as start time should be cleaned up even if keys are not accessible
+		keyBlock, exprs, err := b.getBlockWithKeys(ctx.block, keys, ConverterDump)
+		if err != nil {
+			return err
+		}
+		keyBlock.Addf("@[%s] = %s($dt / %d)", ExprJoin(exprs), aggrFunc, divisor)
+		return nil
+	}
+}
+
+func newCounter(name string) timeBuilderHelper {
+	return func(b *Builder, ctx *timeProbeContext) error {
+		ctx.block.Addf("@%s[%s] = count()", name, ExprJoin(ctx.keysExprs))
 		return nil
 	}
 }
@@ -204,7 +295,7 @@ func newDumper(opt *CommonOptions, dumpOpt CommonDumpOptions, exit bool) timeBui
 
 		ctx.block.Addf(`printf("TIME: %%d %s\n", $dt / %d)`, opt.TimeUnit, divisor)
 
-		err = b.addDumpRowsStatements(ctx.block, dumpOpt)
+		_, err = b.addDumpRowsStatements(ctx.block, dumpOpt)
 		if err != nil {
 			return err
 		}
@@ -216,6 +307,20 @@ func newDumper(opt *CommonOptions, dumpOpt CommonDumpOptions, exit bool) timeBui
 	}
 }
 
+func newDumpLimits(opt DumpLimitOptions) timeBuilderHelper {
+	return func(b *Builder, ctx *timeProbeContext) error {
+		ctx.block = b.addDumpLimits(ctx.block, opt)
+		return nil
+	}
+}
+
+func newDumpEventCounter(opt DumpLimitOptions) timeBuilderHelper {
+	return func(b *Builder, ctx *timeProbeContext) error {
+		b.addDumpEventCounter(ctx.block, opt)
+		return nil
+	}
+}
+
 func newEventCount(event string) timeBuilderHelper {
 	return func(b *Builder, ctx *timeProbeContext) error {
 		ctx.block.Addf(`@["%s:filtered"] = count()`, event)
@@ -224,15 +329,35 @@ func newEventCount(event string) timeBuilderHelper {
 	}
 }
 
-func newDuplicateEvent() timeBuilderHelper {
+func newDuplicateEvent(opt *DuplicateEventOptions) timeBuilderHelper {
 	return func(b *Builder, ctx *timeProbeContext) error {
-		ctx.block.Addf("$st = @start_time[%s]", ExprJoin(ctx.keysExprs))
+		keysExpr := ExprJoin(ctx.keysExprs)
+		ctx.block.Addf("$st = @start_time[%s]", keysExpr)
+
+		// Events seen after window is expired start a new window
+		cond := Expr("$st == 0")
+		if opt.Window > 0 {
+			cond = Exprf("$st == 0 || nsecs - $st > %d", opt.Window.Nanoseconds())
+		}
 
 		outerBlock := ctx.block
-		ctx.block = ctx.block.AddIfBlock(Expr("$st == 0"))
+		ctx.block = ctx.block.AddIfBlock(cond)
 		timeMeasureStartImpl(b, ctx)
+		if opt.MinCount > 2 {
+			ctx.block.Addf("@dup_count[%s] = 1", keysExpr)
+		}
 
 		ctx.block = outerBlock.AddBlock("else")
+		if opt.MinCount > 2 {
+			ctx.block.Addf("@dup_count[%s] += 1", keysExpr)
+			ctx.block = ctx.block.AddIfBlock(Exprf("@dup_count[%s] >= %d", keysExpr, opt.MinCount))
+		}
+
+		if opt.Aggregate {
+			ctx.block.Addf("@[%s] = count()", keysExpr)
+			return nil
+		}
+
 		ctx.block.Add(Stmt(`printf("DUPLICATE EVENT ")`))
 		return timeMeasureDeltaImpl(b, ctx)
 	}
@@ -252,13 +377,17 @@ func newAggrCleanup(aggrVar string) timeBuilderHelper {
 // BuildTimeAggregate is a default time mode builder: measures time deltas, puts them
 // into aggregation and periodically dumps aggregation contents.
 func (b *Builder) BuildTimeAggregate(opt TimeAggregateOptions) (*Program, error) {
+	if opt.Explain.Spec.Probe != "" {
+		return nil, errExplainNotSupported
+	}
+
 	prog, err := b.buildTimeTrace(
 		&opt.TimeCommonOptions, nil,
 		newTimeMeasureStart(ConverterHiddenKey),
 		combineTimeHelpers(
 			newEventCounter(opt.ToEventCount),
 			newTimeMeasureDelta(ConverterHiddenKey),
-			newAggregateTimeDelta(opt.Func, opt.TimeUnit),
+			newAggregateTimeDelta(opt.Func, opt.TimeUnit, opt.AggrKeys, opt.Hints),
 			newAggrCleanup("@start_time")))
 	if err != nil {
 		return nil, err
@@ -269,7 +398,7 @@ func (b *Builder) BuildTimeAggregate(opt TimeAggregateOptions) (*Program, error)
 		aggrs = append(aggrs, "@event_count")
 	}
 
-	prog.addAggrDumpBlock(opt.Interval, opt.Truncate)
+	prog.addAggrDumpBlock(&opt.AggregateCommonOptions, opt.counterMaps()...)
 	prog.addAggrCleanupBlock(aggrs...)
 	return prog, err
 }
@@ -278,18 +407,54 @@ func (b *Builder) BuildTimeAggregate(opt TimeAggregateOptions) (*Program, error)
 // but instead of putting it into an aggregation, it dumps objects that
 // reveal such behaviour, i.e. tcp packet which causes troublingly long handshake.
 func (b *Builder) BuildTimeOutlierDump(opt TimeOutlierDumpOptions) (*Program, error) {
+	ptCtx, err := newPreTriggerContext(&opt.PreTriggerOptions, &opt.CommonOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	ectx, err := b.newExplainContext(&opt.Explain, &opt.CommonOptions, opt.FieldGroupRows)
+	if err != nil {
+		return nil, err
+	}
+	if len(opt.Counters) > 0 {
+		return nil, errCountersNotSupported
+	}
+
 	prog, err := b.buildTimeTrace(
 		&opt.TimeCommonOptions, opt.FieldGroupRows,
-		newTimeMeasureStart(ConverterHiddenKey),
 		combineTimeHelpers(
-			newTimeMeasureDelta(ConverterHiddenKey),
+			newTimeMeasureStart(ConverterHiddenKey),
+			newPreTriggerRecord(ptCtx),
+			newExplainMark(ectx)),
+		combineTimeHelpers(
+			newTimeMeasurePrepare(ConverterHiddenKey),
+			newPreTriggerRecord(ptCtx),
+			timeMeasureStartFetchImpl,
+			timeMeasureDeltaImpl,
 			newOutlierCondition(opt.OutlierThreshold),
-			newDumper(&opt.CommonOptions, opt.CommonDumpOptions, opt.Exit)))
+			newDumpLimits(opt.DumpLimitOptions),
+			newPreTriggerDump(ptCtx, opt.TimeUnit),
+			newDumper(&opt.CommonOptions, opt.CommonDumpOptions, opt.Exit),
+			newExplainDump(ectx),
+			newDumpEventCounter(opt.DumpLimitOptions)))
 	if err != nil {
 		return nil, err
 	}
 
-	prog.addAggrCleanupBlock("@start_time")
+	err = b.buildExplainProbe(prog, ectx, &opt.CommonOptions, opt.CommonDumpOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.buildPreTriggerProbes(prog, ptCtx, opt.FromSpec, &opt.CommonOptions, opt.CommonDumpOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	aggrs := append([]string{"@start_time"}, prog.addPreTriggerCleanup(ptCtx)...)
+	aggrs = append(aggrs, prog.addExplainCleanup(ectx)...)
+	prog.addAggrCleanupBlock(aggrs...)
+	prog.addDumpLimitsCleanupBlock(opt.DumpLimitOptions)
 	return prog, err
 }
 
@@ -297,6 +462,13 @@ func (b *Builder) BuildTimeOutlierDump(opt TimeOutlierDumpOptions) (*Program, er
 // probes are hit. Useful when other time probes do not reveal anything useful
 // because filter is incorrect.
 func (b *Builder) BuildTimeEventCount(opt TimeCommonOptions) (*Program, error) {
+	if opt.Explain.Spec.Probe != "" {
+		return nil, errExplainNotSupported
+	}
+	if len(opt.Counters) > 0 {
+		return nil, errCountersNotSupported
+	}
+
 	return b.buildTimeTrace(
 		&opt, nil,
 		newEventCount("from"),
@@ -304,22 +476,55 @@ func (b *Builder) BuildTimeEventCount(opt TimeCommonOptions) (*Program, error) {
 }
 
 // BuildDuplicateEvent builds a program which attaches to a single probe, but
-// fires only when the probe hits same set of keys second time. Useful for
-// tracking retransmits or measure port reuse time.
+// fires only when the probe hits same set of keys second time (or MinCount
+// times within the window). Useful for tracking retransmits, looping packets
+// or measure port reuse time. In aggregate mode duplicates are counted per key.
 func (b *Builder) BuildDuplicateEvent(opt DuplicateEventOptions) (*Program, error) {
+	ptCtx, err := newPreTriggerContext(&opt.PreTriggerOptions, &opt.CommonOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var builder timeBuilderHelper
+	if opt.Aggregate {
+		if ptCtx.enabled() {
+			return nil, errors.New("pre-trigger history is not supported in aggregate mode")
+		}
+
+		// Use converters here as we're going to dump map with its keys
+		builder = combineTimeHelpers(
+			newTimeMeasurePrepare(ConverterDump),
+			newDuplicateEvent(&opt))
+	} else {
+		builder = combineTimeHelpers(
+			newTimeMeasurePrepare(ConverterHiddenKey),
+			newPreTriggerRecord(ptCtx),
+			newDuplicateEvent(&opt),
+			newPreTriggerDump(ptCtx, opt.TimeUnit),
+			newDumper(&opt.CommonOptions, opt.CommonDumpOptions, opt.Exit))
+	}
+
 	prog := NewProgram()
-	builder := combineTimeHelpers(
-		newTimeMeasurePrepare(ConverterHiddenKey),
-		newDuplicateEvent(),
-		newDumper(&opt.CommonOptions, opt.CommonDumpOptions, opt.Exit))
 
-	_, err := b.buildTimeProbe(prog, nil,
+	_, err = b.buildTimeProbe(prog, nil,
 		opt.Spec, opt.FieldGroupRows, &opt.CommonOptions, builder)
 	if err != nil {
 		return nil, newProbeBuildError(opt.Spec.Probe, err)
 	}
 
-	prog.addAggrCleanupBlock("@start_time")
+	err = b.buildPreTriggerProbes(prog, ptCtx, opt.Spec, &opt.CommonOptions, opt.CommonDumpOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	aggrs := append([]string{"@start_time"}, prog.addPreTriggerCleanup(ptCtx)...)
+	if opt.MinCount > 2 {
+		aggrs = append(aggrs, "@dup_count")
+	}
+	if opt.Aggregate {
+		prog.addAggrDumpBlock(&opt.AggregateCommonOptions)
+	}
+	prog.addAggrCleanupBlock(aggrs...)
 	return prog, err
 }
 
@@ -343,9 +548,29 @@ func (b *Builder) buildTimeTrace(
 		return nil, newProbeBuildError(fmt.Sprintf("%s (to)", opt.ToSpec.Probe), err)
 	}
 
+	// Counters use converters as their maps are dumped along with keys
+	for _, counter := range opt.Counters {
+		_, err = b.buildTimeProbe(prog, nil,
+			counter.TimeSpec, nil, &opt.CommonOptions,
+			combineTimeHelpers(
+				newTimeMeasurePrepare(ConverterDump),
+				newCounter(counter.Name)))
+		if err != nil {
+			return nil, newProbeBuildError(fmt.Sprintf("%s (%s)", counter.Probe, counter.Name), err)
+		}
+	}
+
 	return prog, nil
 }
 
+func (opt *TimeCommonOptions) counterMaps() []string {
+	aggrs := make([]string, 0, len(opt.Counters))
+	for _, counter := range opt.Counters {
+		aggrs = append(aggrs, "@"+counter.Name)
+	}
+	return aggrs
+}
+
 func (b *Builder) buildTimeProbe(
 	prog *Program, ctxBase *timeProbeContext,
 	spec TimeSpec, rows []string, opt *CommonOptions,
@@ -378,13 +603,13 @@ func (b *Builder) buildTimeProbe(
 		if err != nil {
 			return
 		}
-	} else {
+	} else if !spec.Return {
 		ctx.filters = ctxBase.filters
 		sharedFilters = true
 	}
 
-	if spec.Probe != ctxBase.probeName || ctxBase.probeBlock == nil {
-		ctx.probeBlock, ctx.outerBlock, err = b.addProbeBlock(prog, spec.Probe, false, ctx.filters)
+	if spec.Probe != ctxBase.probeName || ctxBase.probeBlock == nil || spec.Return {
+		ctx.probeBlock, ctx.outerBlock, err = b.addProbeBlock(prog, spec.Probe, spec.Return, ctx.filters)
 	} else {
 		ctx.probeBlock = ctxBase.probeBlock
 		if !sharedFilters {
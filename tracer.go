@@ -16,6 +16,7 @@ const (
 
 // CommonOptions are shared between tracer and time requests
 type CommonOptions struct {
+	// Timeout after which script exits, zero disables it
 	Timeout time.Duration
 
 	// Hints contains list of row names that are used for resolving weak aliases
@@ -28,9 +29,14 @@ type CommonOptions struct {
 type TraceCommonOptions struct {
 	CommonOptions
 
-	ContextProbeNames    []string
-	ContextFilterOptions FilterOptions
-	ContextKey           string
+	// Context probes enable main probes for the same context keys
+	// and capture context fields which are printed by them. Main probes
+	// may also filter on captured fields
+	ContextProbeNames         []string
+	ContextFilterOptions      FilterOptions
+	ContextKeys               []string
+	ContextFields             []string
+	ContextFieldFilterOptions FilterOptions
 
 	ProbeNames []string
 	FilterOptions
@@ -45,11 +51,25 @@ type CommonDumpOptions struct {
 	UStack   bool
 }
 
+// DumpLimitOptions limit number of events printed by dumping tracers
+// to avoid flooding terminal and losing perf events on busy hosts
+type DumpLimitOptions struct {
+	// Sample defines that only each Sample-th event is printed on each CPU
+	Sample int
+
+	// Rate limits number of events printed each second on each CPU
+	Rate int
+
+	// MaxEvents defines number of printed events after which tracer exits
+	MaxEvents int
+}
+
 // Options for BuildDumpTrace
 type TraceDumpOptions struct {
 	TraceCommonOptions
 
 	CommonDumpOptions
+	DumpLimitOptions
 }
 
 type AggregateCommonOptions struct {
@@ -59,6 +79,10 @@ type AggregateCommonOptions struct {
 	// Truncate defines number of entries that should be printed when printing
 	// the aggregation if set to positive value
 	Truncate int
+
+	// EndMarker is printed after aggregations on interval if set, so
+	// output processor can detect that snapshot is complete
+	EndMarker string
 }
 
 // Options for BuildAggregate
@@ -77,6 +101,21 @@ type TraceAggregateOptions struct {
 func (b *Builder) buildTracerImpl(
 	opt *TraceCommonOptions, rows []string,
 	builder func(block *Block) error,
+) (*Program, error) {
+	tctx, err := b.newTraceContext(opt, rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(tctx.fields) > 0 {
+		return nil, errContextFieldsNotSupported
+	}
+
+	return b.buildTracerContextImpl(opt, tctx, rows, builder)
+}
+
+func (b *Builder) buildTracerContextImpl(
+	opt *TraceCommonOptions, tctx *traceContext, rows []string,
+	builder func(block *Block) error,
 ) (*Program, error) {
 	filters, err := b.prepareFilters(opt.FilterOptions)
 	if err != nil {
@@ -92,34 +131,11 @@ func (b *Builder) buildTracerImpl(
 	prog := NewProgram()
 	prog.addCommonBlock(&opt.CommonOptions)
 
-	if len(opt.ContextProbeNames) > 0 {
-		traceFlagExpr := Exprf("@trace_flag[%s]", opt.ContextKey)
-		innerBuilder := builder
-		builder = func(block *Block) error {
-			innerBlock := block.AddIfBlock(traceFlagExpr)
-			return innerBuilder(innerBlock)
-		}
-
-		contextFilters, err := b.prepareFilters(opt.ContextFilterOptions)
-		if err != nil {
+	if tctx.enabled() {
+		if err = b.buildContextProbes(prog, tctx); err != nil {
 			return nil, err
 		}
-
-		for _, probeName := range opt.ContextProbeNames {
-			if err = b.buildTracerProbe(prog, probeName, false, contextFilters, true, func(block *Block) error {
-				block.Addf("%s = 1", traceFlagExpr)
-				return nil
-			}); err != nil {
-				return nil, err
-			}
-
-			if err = b.buildTracerProbe(prog, probeName, true, nil, false, func(block *Block) error {
-				block.Addf("delete(%s)", traceFlagExpr)
-				return nil
-			}); err != nil {
-				return nil, err
-			}
-		}
+		builder = b.wrapContextBuilder(tctx, builder)
 	}
 
 	for _, probeName := range opt.ProbeNames {
@@ -158,16 +174,40 @@ func (b *Builder) buildTracerProbe(
 // along with timestamp (as defined by time mode) if conditions specified
 // by filters are met.
 func (b *Builder) BuildDumpTrace(opt TraceDumpOptions) (*Program, error) {
-	return b.buildTracerImpl(&opt.TraceCommonOptions, opt.FieldGroupRows,
+	tctx, err := b.newTraceContext(&opt.TraceCommonOptions, opt.FieldGroupRows)
+	if err != nil {
+		return nil, err
+	}
+
+	prog, err := b.buildTracerContextImpl(&opt.TraceCommonOptions, tctx, opt.FieldGroupRows,
 		func(block *Block) error {
-			return b.addDumpRowsStatements(block, opt.CommonDumpOptions)
+			block = b.addDumpLimits(block, opt.DumpLimitOptions)
+			timeBlock, err := b.addDumpRowsStatements(block, opt.CommonDumpOptions)
+			if err != nil {
+				return err
+			}
+
+			err = b.addContextDumpStatements(timeBlock, tctx)
+			if err != nil {
+				return err
+			}
+
+			b.addDumpEventCounter(timeBlock, opt.DumpLimitOptions)
+			return nil
 		})
+	if err != nil {
+		return nil, err
+	}
+
+	prog.addDumpLimitsCleanupBlock(opt.DumpLimitOptions)
+	return prog, nil
 }
 
 func (b *Builder) BuildAggregate(opt TraceAggregateOptions) (*Program, error) {
 	prog, err := b.buildTracerImpl(&opt.TraceCommonOptions, []string{},
 		func(block *Block) error {
-			aggrBlock, aggrExpr, err := b.generateAggregateExpr(block, opt.Func, opt.Arg)
+			boSet := b.newBuildObjectSet(nil, nil, opt.Hints)
+			aggrBlock, aggrExpr, err := b.generateAggregateExpr(block, opt.Func, opt.Arg, boSet)
 			if err != nil {
 				return err
 			}
@@ -176,6 +216,10 @@ func (b *Builder) BuildAggregate(opt TraceAggregateOptions) (*Program, error) {
 			if err != nil {
 				return err
 			}
+			err = b.resolveWeakAliasRefs(b.getFieldWeakRefs(frefList), boSet)
+			if err != nil {
+				return err
+			}
 
 			// Use converters here as we're going to dump map with its keys
 			keyBlock, keyExprs, err := b.getBlockWithKeys(aggrBlock, frefList, ConverterDump)
@@ -197,7 +241,7 @@ func (b *Builder) BuildAggregate(opt TraceAggregateOptions) (*Program, error) {
 		return nil, err
 	}
 
-	prog.addAggrDumpBlock(opt.Interval, opt.Truncate)
+	prog.addAggrDumpBlock(&opt.AggregateCommonOptions)
 	return prog, nil
 }
 
@@ -231,15 +275,18 @@ func (b *Builder) wrapFilter(
 	return b.addFilterBlock(block, filterChunk)
 }
 
-func (b *Builder) addDumpRowsStatements(block *Block, opt CommonDumpOptions) (err error) {
+// addDumpRowsStatements prints rows of fields preceded by time and probe name.
+// Returns block in which time is printed, i.e. where event is considered printed
+// after sanity filters of the first row are passed.
+func (b *Builder) addDumpRowsStatements(block *Block, opt CommonDumpOptions) (timeBlock *Block, err error) {
 	if len(opt.FieldGroupRows) == 0 {
-		return newCommonError(ErrLevelProbe, block.probe.Name, "no rows are specified in dump options")
+		return nil, newCommonError(ErrLevelProbe, block.probe.Name, "no rows are specified in dump options")
 	}
 
 	for rowIndex, row := range opt.FieldGroupRows {
 		fgList, ok := b.fieldGroupMap[row]
 		if !ok {
-			return newCommonError(ErrLevelRow, row, ErrMsgNotFound)
+			return nil, newCommonError(ErrLevelRow, row, ErrMsgNotFound)
 		}
 
 		objBlock := block
@@ -247,7 +294,7 @@ func (b *Builder) addDumpRowsStatements(block *Block, opt CommonDumpOptions) (er
 			if _, ok := block.context[fg.Object]; !ok {
 				objBlock, err = b.getBlockWithObject(block, fg.Object)
 				if err != nil {
-					return err
+					return nil, err
 				}
 			}
 
@@ -255,13 +302,14 @@ func (b *Builder) addDumpRowsStatements(block *Block, opt CommonDumpOptions) (er
 			if rowIndex == 0 && fgIndex == 0 {
 				err := b.addTimeStatements(objBlock, opt.TimeMode, block.probe.Name)
 				if err != nil {
-					return err
+					return nil, err
 				}
+				timeBlock = objBlock
 			}
 
-			stmts, err := b.generatePrintStatements(fg, block.probe)
+			stmts, err := b.generatePrintStatements(fg, objBlock)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			objBlock.Add(stmts...)
 		}
@@ -276,7 +324,62 @@ func (b *Builder) addDumpRowsStatements(block *Block, opt CommonDumpOptions) (er
 		}
 	}
 
-	return nil
+	return timeBlock, nil
+}
+
+// addDumpLimits wraps block with conditions which implement sampling and
+// per-CPU token bucket with burst of one second worth of events. Events
+// that are suppressed are counted in @hits. Returns block for dumping event.
+func (b *Builder) addDumpLimits(block *Block, opt DumpLimitOptions) *Block {
+	probeName := block.probeName
+	if opt.Sample > 1 {
+		block.Add(Stmt("@dump_sample[cpu] += 1"))
+
+		outerBlock := block
+		block = outerBlock.AddIfBlock(Exprf("@dump_sample[cpu] >= %d", opt.Sample))
+		block.Add(Stmt("@dump_sample[cpu] = 0"))
+		outerBlock.AddBlock("else").Addf(`@hits["%s:sampled"] = count()`, probeName)
+	}
+
+	if opt.Rate > 0 {
+		cost := time.Second.Nanoseconds() / int64(opt.Rate)
+		block.Add(Stmt("@dump_budget[cpu] += nsecs - @dump_refill[cpu]"))
+		block.Add(Stmt("@dump_refill[cpu] = nsecs"))
+		block.AddIfBlock(Exprf("@dump_budget[cpu] > %d", time.Second.Nanoseconds())).Addf(
+			"@dump_budget[cpu] = %d", time.Second.Nanoseconds())
+
+		outerBlock := block
+		block = outerBlock.AddIfBlock(Exprf("@dump_budget[cpu] >= %d", cost))
+		block.Addf("@dump_budget[cpu] = @dump_budget[cpu] - %d", cost)
+		outerBlock.AddBlock("else").Addf(`@hits["%s:ratelimited"] = count()`, probeName)
+	}
+	return block
+}
+
+// addDumpEventCounter adds exit() after reaching maximum number of printed events
+func (b *Builder) addDumpEventCounter(block *Block, opt DumpLimitOptions) {
+	if opt.MaxEvents > 0 {
+		block.Add(Stmt("@dump_events += 1"))
+		block.AddIfBlock(Exprf("@dump_events >= %d", opt.MaxEvents)).Add(Stmt("exit()"))
+	}
+}
+
+// addDumpLimitsCleanupBlock clears limiter state on exit so it won't be printed
+func (prog *Program) addDumpLimitsCleanupBlock(opt DumpLimitOptions) {
+	var aggrs []string
+	if opt.Sample > 1 {
+		aggrs = append(aggrs, "@dump_sample")
+	}
+	if opt.Rate > 0 {
+		aggrs = append(aggrs, "@dump_budget", "@dump_refill")
+	}
+	if opt.MaxEvents > 0 {
+		aggrs = append(aggrs, "@dump_events")
+	}
+
+	for _, aggr := range aggrs {
+		prog.EndBlock().Addf("clear(%s)", aggr)
+	}
 }
 
 func (b *Builder) addStackStatement(block *Block, stkVar string) {
@@ -321,7 +424,7 @@ func (b *Builder) getTimeStatements(timeMode TimeMode) (
 }
 
 func (b *Builder) generateAggregateExpr(
-	block *Block, aggrFunc AggrFunc, arg string,
+	block *Block, aggrFunc AggrFunc, arg string, boSet builderObjectSet,
 ) (*Block, Expression, error) {
 	switch aggrFunc {
 	case AFCount:
@@ -333,6 +436,10 @@ func (b *Builder) generateAggregateExpr(
 	if err != nil {
 		return nil, NilExpr, err
 	}
+	err = b.resolveWeakAliasRefs(b.getFieldWeakRefs(frefList), boSet)
+	if err != nil {
+		return nil, NilExpr, err
+	}
 
 	block, argExprs, err = b.getBlockWithKeys(block, frefList, ConverterAggregateArg)
 	if err != nil {
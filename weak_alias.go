@@ -1,5 +1,13 @@
 package skbtrace
 
+import (
+	"fmt"
+	"strings"
+)
+
+// Prefix of scratch variables holding weak alias values selected at runtime
+const autoAliasVarPrefix = "$auto_"
+
 // Helper for weak alias filters: picks whichever object is more likely
 // to appear based on other filters and print statements
 type builderObjectSet map[string]struct{}
@@ -49,7 +57,8 @@ func (b *Builder) deduceFilterObjects(boSet builderObjectSet, filters [][]*Proce
 // pointer to FieldGroup for filters which use fields with field aliases for which
 // source object is not known due to weak aliasing logic. boSet contains set of objects
 // which will be produced in this trace script due to dump rows, other filters, keys, etc.
-// If deduction is failed due to lack of respective object hints, error is returned
+// If deduction is failed due to lack of respective object hints, value is picked at
+// runtime (see getAutoFieldGroup), and if that is not possible, error is returned
 func (b *Builder) resolveWeakAliasRefs(refs []weakAliasRef, boSet builderObjectSet) error {
 loop:
 	for _, ref := range refs {
@@ -60,9 +69,106 @@ loop:
 			}
 		}
 
+		if fg := b.getAutoFieldGroup(ref.Ref()); fg != nil {
+			ref.Resolve(fg)
+			continue
+		}
+
 		return newErrorf(ref.Level(), ref.Ref().field.Name, nil,
 			"object cannot be deduced for weak alias from rows, filters and hints")
 	}
 
 	return nil
 }
+
+// getAutoFieldGroup returns objectless field group for weak alias which value
+// is picked at runtime from whichever candidate object passes its sanity filters,
+// i.e. sport is taken from tcp or udp header depending on ip protocol. This is only
+// possible when candidates share the same numeric field, otherwise nil is returned.
+func (b *Builder) getAutoFieldGroup(fref *fieldAliasRef) *FieldGroup {
+	baseGroup := fref.weakGroups[0]
+	baseField := baseGroup.findFieldByAlias(fref.field.Alias)
+	if baseField == nil || baseField.FmtSpec == "%s" {
+		return nil
+	}
+	for _, fg := range fref.weakGroups {
+		field := fg.findFieldByAlias(fref.field.Alias)
+		if fg.Object == "" || field == nil || field.Name != baseField.Name {
+			return nil
+		}
+	}
+
+	aliasName := baseField.Alias
+	if len(baseGroup.FieldAliasPrefix) > 0 {
+		aliasName = fmt.Sprintf("%s-%s", baseGroup.FieldAliasPrefix, aliasName)
+	}
+	if fg, ok := b.autoFieldGroups[aliasName]; ok {
+		return fg
+	}
+
+	// Converters are applied by candidates
+	field := *baseField
+	field.Converter = nil
+	field.ConverterMask = 0
+	field.LookupTable = ""
+	field.SanityFilter = nil
+
+	fg := &FieldGroup{
+		Row:              "auto",
+		Fields:           []*Field{&field},
+		FieldAliasPrefix: baseGroup.FieldAliasPrefix,
+		autoGroups:       fref.weakGroups,
+	}
+	b.autoFieldGroups[aliasName] = fg
+	return fg
+}
+
+// generateAutoFieldExpression assigns weak alias value to a scratch variable in
+// the blocks of each of candidate objects, so the runtime branch is chosen by their
+// sanity filters. Variable is zeroed beforehand in case no candidate matches.
+func (b *Builder) generateAutoFieldExpression(
+	fg *FieldGroup, field *Field, block *Block, convMask uint,
+) ([]Statement, Expression, error) {
+	aliasName := field.Alias
+	if len(fg.FieldAliasPrefix) > 0 {
+		aliasName = fmt.Sprintf("%s_%s", fg.FieldAliasPrefix, aliasName)
+	}
+	varName := autoAliasVarPrefix + strings.ReplaceAll(aliasName, "-", "_")
+
+	// Converted and raw values are kept in different variables
+	baseField := fg.autoGroups[0].findFieldByAlias(field.Alias)
+	if baseField.Converter != nil {
+		fieldConvMask := baseField.ConverterMask
+		if fieldConvMask == 0 {
+			fieldConvMask = ConverterDump
+		}
+		if convMask&fieldConvMask != 0 {
+			varName += "_conv"
+		}
+	}
+
+	if _, ok := block.context[varName]; ok {
+		return nil, Expr(varName), nil
+	}
+
+	// Object blocks may already be built in this block, so prepend zeroing
+	block.Statements = append([]Statement{Stmtf("%s = 0", varName)}, block.Statements...)
+	for _, afg := range fg.autoGroups {
+		objBlock, err := b.getBlockWithObject(block, afg.Object)
+		if err != nil {
+			return nil, "", err
+		}
+
+		afield := afg.findFieldByAlias(field.Alias)
+		stmts, expr, err := b.generateFieldExpression(afg, afield, objBlock, convMask)
+		if err != nil {
+			return nil, "", err
+		}
+
+		objBlock.Add(stmts...)
+		objBlock.Addf("%s = %s", varName, expr)
+	}
+
+	block.context[varName] = struct{}{}
+	return nil, Expr(varName), nil
+}